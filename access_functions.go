@@ -2,7 +2,9 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"strings"
@@ -43,10 +45,14 @@ type AccessPolicy struct {
 }
 
 type SystemDB struct {
-	Users    []PrivateAccessUser
-	Groups   []AccessGroup
-	Roles    []AccessRole
-	Policies []AccessPolicy
+	Users       []PrivateAccessUser
+	Groups      []AccessGroup
+	Roles       []AccessRole
+	Policies    []AccessPolicy
+	audit       *auditLog          // nil until the system database has been loaded from file
+	actor       string             // the user that changes are made by, set when a user logs in
+	dataKeys    map[string]dataKey // the data key each system table is sealed with, by the name of the table
+	generations map[string]uint64  // number of times each system table has been saved, by the name of the table
 }
 
 // Loads the system databases from file
@@ -58,7 +64,15 @@ func (s *SystemDB) loadSystemDB() error {
 		content, err := os.ReadFile(fmt.Sprintf("system/%v.dat", tableName))
 		if err != nil {
 			// If the system databases cannot be found, create the base policies and roles
-			if strings.Contains(err.Error(), "cannot find the file") {
+			if errors.Is(err, fs.ErrNotExist) {
+				// A system table that has been saved before was removed, creating the base data again would reset every user
+				generations, generationsErr := readGenerations()
+				if generationsErr != nil {
+					return generationsErr
+				} else if savedGeneration := generations[systemIdentity(tableName).name()]; savedGeneration != 0 {
+					return fmt.Errorf("system table %v is missing, but generation %v was saved", tableName, savedGeneration)
+				}
+
 				// Create base policies
 				s.createBasePolicies()
 
@@ -70,22 +84,35 @@ func (s *SystemDB) loadSystemDB() error {
 
 				// Save the database to create the files
 				// Load the database to check loading works
-				s.saveSystemDB()
-				s.loadSystemDB()
+				saveErr := s.saveSystemDB()
+				if saveErr != nil {
+					return saveErr
+				}
+
+				return s.loadSystemDB()
 			} else {
 				return err
 			}
 		}
 
-		ekerr := generateEncryptionKey(keyPath)
-		if ekerr != nil {
-			return ekerr
+		identity := systemIdentity(tableName)
+		decryptedData, key, generation, decryptErr := openEnvelope(content, identity)
+		if decryptErr != nil {
+			return fmt.Errorf("failed to read system table %v: %w", tableName, decryptErr)
+		}
+
+		identity.Generation = generation
+		generationErr := confirmGeneration(identity)
+		if generationErr != nil {
+			return fmt.Errorf("failed to read system table %v: %w", tableName, generationErr)
 		}
 
-		decryptedData, decryptErr := decryptData([]byte(os.Getenv("EK")), content)
-		if decryptErr != nil {
-			return decryptErr
+		if s.dataKeys == nil {
+			s.dataKeys = map[string]dataKey{}
+			s.generations = map[string]uint64{}
 		}
+		s.dataKeys[tableName] = key
+		s.generations[tableName] = generation
 
 		switch tableName {
 		case "users":
@@ -118,7 +145,19 @@ func (s *SystemDB) loadSystemDB() error {
 
 	}
 
-	return nil
+	// System tables from before their content was bound to the file are saved again straight away, so they can't be put back
+	for _, generation := range s.generations {
+		if generation == 0 {
+			saveErr := s.saveSystemDB()
+			if saveErr != nil {
+				return saveErr
+			}
+			break
+		}
+	}
+
+	// Every change made from here on is added to the audit log
+	return s.openAuditLog()
 }
 
 // Saves the system databases to file
@@ -127,6 +166,9 @@ func (s *SystemDB) saveSystemDB() error {
 	var content []byte
 	var err error
 
+	// The generations are only saved once every system table has been
+	savedIdentities := []fileIdentity{}
+
 	for _, tableName := range systemTables {
 		switch tableName {
 		case "users":
@@ -157,30 +199,23 @@ func (s *SystemDB) saveSystemDB() error {
 			return fmt.Errorf("no system table could be found by that name")
 		}
 
-		file, fileErr := os.OpenFile(fmt.Sprintf("system/%v.dat", tableName), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
-		if fileErr != nil {
-			return fileErr
-		}
-
-		ekerr := generateEncryptionKey(keyPath)
-		if ekerr != nil {
-			return ekerr
-		}
-
-		encryptedContent, EncryptErr := encrpytData([]byte(os.Getenv("EK")), content)
-		if EncryptErr != nil {
-			return EncryptErr
+		key, keyErr := s.sealingKey(tableName)
+		if keyErr != nil {
+			return fmt.Errorf("failed to make a data key for system table %v: %w", tableName, keyErr)
 		}
 
-		_, fileWriteErr := file.Write(encryptedContent)
+		identity := systemIdentity(tableName)
+		identity.Generation = s.generations[tableName] + 1
+		fileWriteErr := writeEnvelopeFile(fmt.Sprintf("system/%v.dat", tableName), key, identity, content)
 		if fileWriteErr != nil {
-			return fileWriteErr
+			return errors.Join(fmt.Errorf("failed to save system table %v: %w", tableName, fileWriteErr), recordGenerations(savedIdentities...))
 		}
 
-		defer file.Close()
+		s.generations[tableName] = identity.Generation
+		savedIdentities = append(savedIdentities, identity)
 	}
 
-	return nil
+	return recordGenerations(savedIdentities...)
 }
 
 // Close the database and remove all data
@@ -190,6 +225,11 @@ func (s *SystemDB) close() {
 		log.Fatalf("failed to save the system database")
 	}
 
+	closeErr := s.closeAuditLog()
+	if closeErr != nil {
+		log.Printf("failed to close the audit log: %v", closeErr)
+	}
+
 	s = nil
 }
 
@@ -213,7 +253,13 @@ func (s *SystemDB) createBasePolicies() {
 		Permissions: []string{"DELETE"},
 	}
 
-	s.Policies = append(s.Policies, readerPolicy, writerPolicy, removerPolicy)
+	architectPolicy := AccessPolicy{
+		PolicyID:    4,
+		Name:        "Architect",
+		Permissions: []string{"CREATE", "DROP", "ALTER"},
+	}
+
+	s.Policies = append(s.Policies, readerPolicy, writerPolicy, removerPolicy, architectPolicy)
 }
 
 // Find policy by name search function
@@ -244,9 +290,10 @@ func (s *SystemDB) createBaseRoles() error {
 	readerPolicy, readerPolicyErr := s.findPolicyByName("Reader")
 	writerPolicy, writerPolicyErr := s.findPolicyByName("Writer")
 	removerPolicy, removerPolicyErr := s.findPolicyByName("Remover")
+	architectPolicy, architectPolicyErr := s.findPolicyByName("Architect")
 
 	// Iterate over each of them to send the appropriate error
-	for _, value := range []error{readerPolicyErr, writerPolicyErr, removerPolicyErr} {
+	for _, value := range []error{readerPolicyErr, writerPolicyErr, removerPolicyErr, architectPolicyErr} {
 		if value != nil {
 			return value
 		}
@@ -262,6 +309,7 @@ func (s *SystemDB) createBaseRoles() error {
 			readerPolicy,
 			writerPolicy,
 			removerPolicy,
+			architectPolicy,
 		},
 	}
 
@@ -315,10 +363,11 @@ func (s *SystemDB) findRoleByID(roleID int) (AccessRole, error) {
 }
 
 // Confirm that the appropriate permission is applied for the specified scope
+// ** A role with the root scope of * applies to every scope
 func (r *AccessRole) confirmPermission(scope string, permission string) bool {
 	for _, policy := range r.Policies {
 		for _, policyPermission := range policy.Permissions {
-			if policyPermission == permission && scope == r.Scope {
+			if policyPermission == permission && (scope == r.Scope || r.Scope == "*") {
 				return true
 			}
 		}
@@ -327,6 +376,59 @@ func (r *AccessRole) confirmPermission(scope string, permission string) bool {
 	return false
 }
 
+// Confirm that a logged in user has a permission for the specified scope, through their own roles or the roles of their groups
+// ** The public token is checked against the user's private token, so a username alone isn't enough to be granted access
+func (s *SystemDB) confirmUserPermission(user PublicAccessUser, scope string, permission string) bool {
+	for _, userItem := range s.Users {
+		if userItem.Username != user.Username {
+			continue
+		}
+
+		isValid, validErr := confirmPublicKey(user.PublicToken, userItem.UserPrivateToken)
+		if validErr != nil || !isValid {
+			return false
+		}
+
+		roles := append([]AccessRole{}, userItem.Roles...)
+		for _, groupItem := range s.Groups {
+			for _, groupUser := range groupItem.UserList {
+				if groupUser.Username == user.Username {
+					roles = append(roles, groupItem.Roles...)
+					break
+				}
+			}
+		}
+
+		for _, role := range roles {
+			if role.confirmPermission(scope, permission) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return false
+}
+
+// Confirm that a logged in user holds every permission of the Root Admin role across the root scope
+func (s *SystemDB) confirmRootAdmin(user PublicAccessUser) bool {
+	adminRole, roleErr := s.findRoleByName("Root Admin")
+	if roleErr != nil {
+		return false
+	}
+
+	for _, policy := range adminRole.Policies {
+		for _, permission := range policy.Permissions {
+			if !s.confirmUserPermission(user, "*", permission) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
 // Assign a user to a role
 func (s *SystemDB) assignUserToRole(User PublicAccessUser, Role AccessRole) error {
 	// check user exists
@@ -337,6 +439,12 @@ func (s *SystemDB) assignUserToRole(User PublicAccessUser, Role AccessRole) erro
 			for _, roleItem := range s.Roles {
 				if Role.RoleID == roleItem.RoleID {
 
+					// The change is recorded before it is made, so a change the audit log can't hold is never made
+					auditErr := s.recordChange("ASSIGN USER TO ROLE", userItem.Username, fmt.Sprintf("role %v", roleItem.Name))
+					if auditErr != nil {
+						return auditErr
+					}
+
 					// assign the role
 					s.Users[userIndex].Roles = append(s.Users[userIndex].Roles, roleItem)
 					return nil
@@ -361,6 +469,11 @@ func (s *SystemDB) assignUserToGroup(User PublicAccessUser, Group AccessGroup) e
 			for groupIndex, groupItem := range s.Groups {
 				if Group.GroupID == groupItem.GroupID {
 
+					auditErr := s.recordChange("ASSIGN USER TO GROUP", userItem.Username, fmt.Sprintf("group %v", groupItem.Name))
+					if auditErr != nil {
+						return auditErr
+					}
+
 					// assign the group
 					s.Groups[groupIndex].UserList = append(s.Groups[groupIndex].UserList, userItem)
 					return nil
@@ -393,6 +506,11 @@ func (s *SystemDB) assignGroupToRole(Group AccessGroup, Role AccessRole) error {
 						}
 					}
 
+					auditErr := s.recordChange("ASSIGN GROUP TO ROLE", groupItem.Name, fmt.Sprintf("role %v", roleItem.Name))
+					if auditErr != nil {
+						return auditErr
+					}
+
 					// if it hits here, there are no duplicates assigned to the group - assign this role to the group
 					s.Groups[groupIndex].Roles = append(s.Groups[groupIndex].Roles, roleItem)
 					return nil
@@ -439,8 +557,12 @@ func (s *SystemDB) createGroup(groupName string) (AccessGroup, error) {
 		GroupPrivateToken: privKey,
 	}
 
-	s.Groups = append(s.Groups, newGroup)
+	auditErr := s.recordChange("CREATE GROUP", groupName, "")
+	if auditErr != nil {
+		return AccessGroup{}, auditErr
+	}
 
+	s.Groups = append(s.Groups, newGroup)
 	return newGroup, nil
 }
 
@@ -465,6 +587,17 @@ func (s *SystemDB) createUser(Username string, Password string) (PublicAccessUse
 		return PublicAccessUser{}, privKeyErr
 	}
 
+	// create the public key for the user using the new private key
+	pubKey, pubKeyErr := generatePublicKey(privKey)
+	if pubKeyErr != nil {
+		return PublicAccessUser{}, pubKeyErr
+	}
+
+	auditErr := s.recordChange("CREATE USER", Username, "")
+	if auditErr != nil {
+		return PublicAccessUser{}, auditErr
+	}
+
 	// create the new user object and append it to the system table
 	s.Users = append(s.Users, PrivateAccessUser{
 		UserID:           (latestID + 1),
@@ -474,12 +607,6 @@ func (s *SystemDB) createUser(Username string, Password string) (PublicAccessUse
 		UserPrivateToken: privKey,
 	})
 
-	// create the public key for the user using the new private key
-	pubKey, pubKeyErr := generatePublicKey(privKey)
-	if pubKeyErr != nil {
-		return PublicAccessUser{}, pubKeyErr
-	}
-
 	// return the public user object
 	return PublicAccessUser{
 		Username:    Username,
@@ -519,6 +646,11 @@ func (s *SystemDB) createRole(roleName string, scope string, policies []AccessPo
 		}
 	}
 
+	auditErr := s.recordChange("CREATE ROLE", roleName, fmt.Sprintf("scope %v", scope))
+	if auditErr != nil {
+		return auditErr
+	}
+
 	// create and add the new role to the system db
 	s.Roles = append(s.Roles, AccessRole{
 		RoleID:   (latestID + 1),
@@ -533,7 +665,7 @@ func (s *SystemDB) createRole(roleName string, scope string, policies []AccessPo
 // create a new policy for a set of permissions
 func (s *SystemDB) createPolicy(policyName string, perms []string) error {
 	// specify the permissions that will actually be accepted for the creation of a policy
-	acceptedPerms := []string{"PULL", "PUSH", "PUT", "DELETE"}
+	acceptedPerms := []string{"PULL", "PUSH", "PUT", "DELETE", "CREATE", "DROP", "ALTER"}
 	latestID := 0
 
 	// check for policy duplicates
@@ -563,6 +695,11 @@ func (s *SystemDB) createPolicy(policyName string, perms []string) error {
 		}
 	}
 
+	auditErr := s.recordChange("CREATE POLICY", policyName, fmt.Sprintf("permissions %v", strings.Join(perms, ", ")))
+	if auditErr != nil {
+		return auditErr
+	}
+
 	// create the policy and append it to the system db
 	s.Policies = append(s.Policies, AccessPolicy{
 		PolicyID:    (latestID + 1),
@@ -582,6 +719,14 @@ func (s *SystemDB) userLogin(username string, password string) (PublicAccessUser
 				return PublicAccessUser{}, pubKeyErr
 			}
 
+			auditErr := s.recordAudit(username, "LOGIN", username, "", nil)
+			if auditErr != nil {
+				return PublicAccessUser{}, auditErr
+			}
+
+			// Changes made to the system database from here on are made by the user that has just logged in
+			s.actor = username
+
 			return PublicAccessUser{
 				Username:    username,
 				PublicToken: pubKey,
@@ -589,7 +734,14 @@ func (s *SystemDB) userLogin(username string, password string) (PublicAccessUser
 		}
 	}
 
-	return PublicAccessUser{}, fmt.Errorf("the username or password was incorrect, please try again")
+	// Failed logins are recorded too, so repeated attempts against a user can be found
+	loginErr := fmt.Errorf("the username or password was incorrect, please try again")
+	auditErr := s.recordAudit(username, "LOGIN", username, "", loginErr)
+	if auditErr != nil {
+		return PublicAccessUser{}, auditErr
+	}
+
+	return PublicAccessUser{}, loginErr
 }
 
 // search for a group by its name
@@ -619,6 +771,11 @@ func (s *SystemDB) findGroupByID(groupID int) (AccessGroup, error) {
 func (s *SystemDB) deleteUser(username string) error {
 	for userIndex, userItem := range s.Users {
 		if userItem.Username == username {
+			auditErr := s.recordChange("DELETE USER", userItem.Username, "")
+			if auditErr != nil {
+				return auditErr
+			}
+
 			s.Users = append(s.Users[:userIndex], s.Users[(userIndex+1):]...)
 			return nil
 		}
@@ -632,6 +789,11 @@ func (s *SystemDB) deleteUser(username string) error {
 func (s *SystemDB) deleteRole(roleID int) error {
 	for roleIndex, roleItem := range s.Roles {
 		if roleItem.RoleID == roleID {
+			auditErr := s.recordChange("DELETE ROLE", roleItem.Name, "")
+			if auditErr != nil {
+				return auditErr
+			}
+
 			s.Roles = append(s.Roles[:roleIndex], s.Roles[(roleIndex+1):]...)
 			return nil
 		}
@@ -644,6 +806,11 @@ func (s *SystemDB) deleteRole(roleID int) error {
 func (s *SystemDB) deleteGroup(groupID int) error {
 	for groupIndex, groupItem := range s.Groups {
 		if groupItem.GroupID == groupID {
+			auditErr := s.recordChange("DELETE GROUP", groupItem.Name, "")
+			if auditErr != nil {
+				return auditErr
+			}
+
 			s.Groups = append(s.Groups[:groupIndex], s.Groups[(groupIndex+1):]...)
 			return nil
 		}
@@ -658,6 +825,11 @@ func (s *SystemDB) removeUserFromGroup(groupID int, username string) error {
 		if groupItem.GroupID == groupID {
 			for userIndex, userItem := range groupItem.UserList {
 				if userItem.Username == username {
+					auditErr := s.recordChange("REMOVE USER FROM GROUP", userItem.Username, fmt.Sprintf("group %v", groupItem.Name))
+					if auditErr != nil {
+						return auditErr
+					}
+
 					s.Groups[groupIndex].UserList = append(s.Groups[groupIndex].UserList[:userIndex], s.Groups[groupIndex].UserList[(userIndex+1):]...)
 					return nil
 				}
@@ -676,6 +848,11 @@ func (s *SystemDB) removeUserFromRole(roleID int, username string) error {
 		if userItem.Username == username {
 			for roleIndex, roleItem := range userItem.Roles {
 				if roleItem.RoleID == roleID {
+					auditErr := s.recordChange("REMOVE USER FROM ROLE", userItem.Username, fmt.Sprintf("role %v", roleItem.Name))
+					if auditErr != nil {
+						return auditErr
+					}
+
 					s.Users[userIndex].Roles = append(s.Users[userIndex].Roles[:roleIndex], s.Users[userIndex].Roles[(roleIndex+1):]...)
 					return nil
 				}
@@ -694,6 +871,11 @@ func (s *SystemDB) removeGroupFromRole(roleID int, groupID int) error {
 		if groupItem.GroupID == groupID {
 			for roleIndex, roleItem := range groupItem.Roles {
 				if roleItem.RoleID == roleID {
+					auditErr := s.recordChange("REMOVE GROUP FROM ROLE", groupItem.Name, fmt.Sprintf("role %v", roleItem.Name))
+					if auditErr != nil {
+						return auditErr
+					}
+
 					s.Groups[groupIndex].Roles = append(s.Groups[groupIndex].Roles[:roleIndex], s.Groups[groupIndex].Roles[(roleIndex+1):]...)
 					return nil
 				}
@@ -15,7 +15,8 @@ type TestTemplate struct {
 
 // test the findPolicyByName function
 func Test_findPolicyByBame(t *testing.T) {
-	// initialise the system
+	// initialise the system in a test store
+	useTestStore(t)
 	systemDB, sysErr := initSystem()
 	if sysErr != nil {
 		log.Println(sysErr)
@@ -46,7 +47,8 @@ func Test_findPolicyByBame(t *testing.T) {
 
 // test the findPolicyByID function
 func Test_findPolicyByID(t *testing.T) {
-	// initialise the system
+	// initialise the system in a test store
+	useTestStore(t)
 	systemDB, sysErr := initSystem()
 	if sysErr != nil {
 		log.Println(sysErr)
@@ -101,7 +103,8 @@ func Test_findPolicyByID(t *testing.T) {
 
 // test the createBaseRoles function
 func Test_createBaseRoles(t *testing.T) {
-	// initialise the system
+	// initialise the system in a test store
+	useTestStore(t)
 	systemDB, sysErr := initSystem()
 	if sysErr != nil {
 		log.Println(sysErr)
@@ -129,7 +132,8 @@ func Test_createBaseRoles(t *testing.T) {
 
 // test the findRoleByName
 func Test_findRoleByName(t *testing.T) {
-	// initialise the system
+	// initialise the system in a test store
+	useTestStore(t)
 	systemDB, sysErr := initSystem()
 	if sysErr != nil {
 		log.Println(sysErr)
@@ -191,7 +195,8 @@ func Test_findRoleByName(t *testing.T) {
 
 // test the findRoleByID function
 func Test_findRoleByID(t *testing.T) {
-	// initialise the system
+	// initialise the system in a test store
+	useTestStore(t)
 	systemDB, sysErr := initSystem()
 	if sysErr != nil {
 		log.Println(sysErr)
@@ -261,7 +266,8 @@ func Test_findRoleByID(t *testing.T) {
 
 // test the confirmPermission function
 func Test_confirmPermission(t *testing.T) {
-	// initialise the system
+	// initialise the system in a test store
+	useTestStore(t)
 	systemDB, sysErr := initSystem()
 	if sysErr != nil {
 		log.Println(sysErr)
@@ -281,7 +287,8 @@ func Test_confirmPermission(t *testing.T) {
 
 // test the assignUserToRole function
 func Test_assignUserToRole(t *testing.T) {
-	// initialise the system
+	// initialise the system in a test store
+	useTestStore(t)
 	systemDB, sysErr := initSystem()
 	if sysErr != nil {
 		log.Println(sysErr)
@@ -401,7 +408,8 @@ func Test_assignUserToRole(t *testing.T) {
 
 // test the assignUserToGroup function
 func Test_assignUserToGroup(t *testing.T) {
-	// initialise the system
+	// initialise the system in a test store
+	useTestStore(t)
 	systemDB, sysErr := initSystem()
 	if sysErr != nil {
 		t.Fatalf("Incorrect error, got: %v", sysErr.Error())
@@ -511,7 +519,8 @@ func Test_assignUserToGroup(t *testing.T) {
 
 // test the assignGroupToRole function
 func Test_assignGroupToRole(t *testing.T) {
-	// initialise the system
+	// initialise the system in a test store
+	useTestStore(t)
 	systemDB, sysErr := initSystem()
 	if sysErr != nil {
 		t.Fatalf("Incorrect error, got: %v", sysErr.Error())
@@ -654,7 +663,8 @@ func Test_assignGroupToRole(t *testing.T) {
 
 // test group creation
 func Test_createGroup(t *testing.T) {
-	// initialise the system
+	// initialise the system in a test store
+	useTestStore(t)
 	systemDB, sysErr := initSystem()
 	if sysErr != nil {
 		t.Fatalf("Incorrect error, got: %v", sysErr.Error())
@@ -687,7 +697,8 @@ func Test_createGroup(t *testing.T) {
 
 // test the createUser function
 func Test_createUser(t *testing.T) {
-	// initialise the system
+	// initialise the system in a test store
+	useTestStore(t)
 	systemDB, sysErr := initSystem()
 	if sysErr != nil {
 		t.Fatalf("Incorrect error, got: %v", sysErr.Error())
@@ -722,7 +733,8 @@ func Test_createUser(t *testing.T) {
 
 // test the createRole function
 func Test_createRole(t *testing.T) {
-	// initialise the system
+	// initialise the system in a test store
+	useTestStore(t)
 	systemDB, sysErr := initSystem()
 	if sysErr != nil {
 		t.Fatalf("Incorrect error, got: %v", sysErr.Error())
@@ -778,7 +790,8 @@ func Test_createRole(t *testing.T) {
 
 // test the createPolicy function
 func Test_createPolicy(t *testing.T) {
-	// initialise the system
+	// initialise the system in a test store
+	useTestStore(t)
 	systemDB, sysErr := initSystem()
 	if sysErr != nil {
 		t.Fatalf("Incorrect error, got: %v", sysErr.Error())
@@ -819,7 +832,8 @@ func Test_createPolicy(t *testing.T) {
 
 // test the userLogin function
 func Test_userLogin(t *testing.T) {
-	// initialise the system
+	// initialise the system in a test store
+	useTestStore(t)
 	systemDB, sysErr := initSystem()
 	if sysErr != nil {
 		t.Fatalf("Incorrect error, got: %v", sysErr.Error())
@@ -858,7 +872,8 @@ func Test_userLogin(t *testing.T) {
 
 // test the findGroupByName function
 func Test_findGroupByName(t *testing.T) {
-	// initialise the system
+	// initialise the system in a test store
+	useTestStore(t)
 	systemDB, sysErr := initSystem()
 	if sysErr != nil {
 		t.Fatalf("Incorrect error, got: %v", sysErr.Error())
@@ -897,7 +912,8 @@ func Test_findGroupByName(t *testing.T) {
 
 // test the findGroupByID function
 func Test_findGroupByID(t *testing.T) {
-	// initialise the system
+	// initialise the system in a test store
+	useTestStore(t)
 	systemDB, sysErr := initSystem()
 	if sysErr != nil {
 		t.Fatalf("Incorrect error, got: %v", sysErr.Error())
@@ -936,7 +952,8 @@ func Test_findGroupByID(t *testing.T) {
 
 // test the deleteUser function
 func Test_deleteUser(t *testing.T) {
-	// initialise the system
+	// initialise the system in a test store
+	useTestStore(t)
 	systemDB, sysErr := initSystem()
 	if sysErr != nil {
 		t.Fatalf("Incorrect error, got: %v", sysErr.Error())
@@ -971,7 +988,8 @@ func Test_deleteUser(t *testing.T) {
 
 // test the deleteGroup function
 func Test_deleteGroup(t *testing.T) {
-	// initialise the system
+	// initialise the system in a test store
+	useTestStore(t)
 	systemDB, sysErr := initSystem()
 	if sysErr != nil {
 		t.Fatalf("Incorrect error, got: %v", sysErr.Error())
@@ -1006,7 +1024,8 @@ func Test_deleteGroup(t *testing.T) {
 
 // test the deleteRole function
 func Test_deleteRole(t *testing.T) {
-	// initialise the system
+	// initialise the system in a test store
+	useTestStore(t)
 	systemDB, sysErr := initSystem()
 	if sysErr != nil {
 		t.Fatalf("Incorrect error, got: %v", sysErr.Error())
@@ -1053,7 +1072,8 @@ func Test_deleteRole(t *testing.T) {
 
 // test removeUserFromGroup function
 func Test_removeUserFromGroup(t *testing.T) {
-	// initialise the system
+	// initialise the system in a test store
+	useTestStore(t)
 	systemDB, sysErr := initSystem()
 	if sysErr != nil {
 		t.Fatalf("Incorrect error, got: %v", sysErr.Error())
@@ -1115,7 +1135,8 @@ func Test_removeUserFromGroup(t *testing.T) {
 
 // test the removeUserFromRole function
 func Test_removeUserFromRole(t *testing.T) {
-	// initialise the system
+	// initialise the system in a test store
+	useTestStore(t)
 	systemDB, sysErr := initSystem()
 	if sysErr != nil {
 		t.Fatalf("Incorrect error, got: %v", sysErr.Error())
@@ -1172,7 +1193,8 @@ func Test_removeUserFromRole(t *testing.T) {
 
 // test the removeGroupFromRole function
 func Test_removeGroupFromRole(t *testing.T) {
-	// initialise the system
+	// initialise the system in a test store
+	useTestStore(t)
 	systemDB, sysErr := initSystem()
 	if sysErr != nil {
 		t.Fatalf("Incorrect error, got: %v", sysErr.Error())
@@ -1228,3 +1250,76 @@ func Test_removeGroupFromRole(t *testing.T) {
 		}
 	})
 }
+
+// test the confirmUserPermission function and the query checks built on it
+func Test_confirmUserPermission(t *testing.T) {
+	// build the system in memory, so the test doesn't depend on the system files
+	systemDB := SystemDB{}
+	systemDB.createBasePolicies()
+	if rolesErr := systemDB.createBaseRoles(); rolesErr != nil {
+		t.Fatalf("Unexpected error: %v", rolesErr.Error())
+	}
+
+	writer, writerErr := systemDB.createUser("writer", "writer")
+	if writerErr != nil {
+		t.Fatalf("Unexpected error: %v", writerErr.Error())
+	}
+
+	writerRole, _ := systemDB.findRoleByName("Root Writer")
+	if assignErr := systemDB.assignUserToRole(writer, writerRole); assignErr != nil {
+		t.Fatalf("Unexpected error: %v", assignErr.Error())
+	}
+
+	architect, architectErr := systemDB.createUser("architect", "architect")
+	if architectErr != nil {
+		t.Fatalf("Unexpected error: %v", architectErr.Error())
+	}
+
+	group, _ := systemDB.createGroup("architects")
+	adminRole, _ := systemDB.findRoleByName("Root Admin")
+	systemDB.assignUserToGroup(architect, group)
+	systemDB.assignGroupToRole(group, adminRole)
+
+	testTemplates := []TestTemplate{
+		{
+			TestName:       "Test permission through a user role",
+			Inputs:         map[string]any{"user": writer, "permission": "PUSH"},
+			ExpectedOutput: true,
+		},
+		{
+			TestName:       "Test missing table definition permission",
+			Inputs:         map[string]any{"user": writer, "permission": "CREATE"},
+			ExpectedOutput: false,
+		},
+		{
+			TestName:       "Test permission through a group role",
+			Inputs:         map[string]any{"user": architect, "permission": "ALTER"},
+			ExpectedOutput: true,
+		},
+		{
+			TestName:       "Test public token from another user",
+			Inputs:         map[string]any{"user": PublicAccessUser{Username: "architect", PublicToken: writer.PublicToken}, "permission": "PULL"},
+			ExpectedOutput: false,
+		},
+	}
+
+	for _, test := range testTemplates {
+		t.Run(test.TestName, func(t *testing.T) {
+			isAllowed := systemDB.confirmUserPermission(test.Inputs["user"].(PublicAccessUser), "Orders", test.Inputs["permission"].(string))
+
+			if isAllowed != test.ExpectedOutput.(bool) {
+				t.Fatalf("result was incorrect, got: %v, expected: %v", isAllowed, test.ExpectedOutput)
+			}
+		})
+	}
+
+	// test queries are rejected before they reach the table
+	t.Run("Test query without permission", func(t *testing.T) {
+		db := DB{System: &systemDB, User: writer}
+
+		_, queryErr := db.runQuery("DROP TABLE Orders")
+		if queryErr == nil || queryErr.Error() != "user writer does not have the DROP permission for table Orders" {
+			t.Fatalf("error result was incorrect, got: %v", queryErr)
+		}
+	})
+}
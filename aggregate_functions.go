@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// The rows sharing the same values for every GROUP BY column
+type rowGroup struct {
+	keyValues map[string]any
+	rows      []RowValue
+}
+
+// Checks if a PULL query needs its rows grouped before being returned
+func (query *DBQuery) isGrouped() bool {
+	return len(query.Aggregates) > 0 || len(query.GroupBy) > 0 || query.HavingClause != nil
+}
+
+// Builds the result set for a PULL query that uses aggregates or GROUP BY
+// ** The groups are turned into a table of their own, so HAVING, SORT BY and the column list work the same way as an ungrouped PULL
+func (table *DBTable) groupedResultSet(query DBQuery) (ResultSet, error) {
+	groupConfigs := []ColumnConfig{}
+	for _, column := range query.GroupBy {
+		config, columnErr := table.getColumnConfig(column)
+		if columnErr != nil {
+			return ResultSet{}, columnErr
+		}
+
+		groupConfigs = append(groupConfigs, config)
+	}
+
+	// Every column returned has to be grouped on or aggregated, otherwise there's no single value to return for it
+	for _, column := range query.ColumnNames {
+		if column == "*" || strings.HasSuffix(column, ".*") {
+			return ResultSet{}, fmt.Errorf("%v cannot be used alongside aggregate functions or GROUP BY", column)
+		}
+
+		if query.hasAggregate(column) {
+			continue
+		}
+
+		config, columnErr := table.getColumnConfig(column)
+		if columnErr != nil {
+			return ResultSet{}, columnErr
+		}
+
+		if !slices.ContainsFunc(groupConfigs, func(groupConfig ColumnConfig) bool { return groupConfig.ColumnName == config.ColumnName }) {
+			return ResultSet{}, fmt.Errorf("column %v must be included in GROUP BY or used within an aggregate function", column)
+		}
+	}
+
+	aggregateConfigs := []ColumnConfig{}
+	sourceColumns := []string{}
+	for _, aggregate := range query.Aggregates {
+		config, sourceColumn, configErr := table.aggregateColumnConfig(aggregate)
+		if configErr != nil {
+			return ResultSet{}, configErr
+		}
+
+		aggregateConfigs = append(aggregateConfigs, config)
+		sourceColumns = append(sourceColumns, sourceColumn)
+	}
+
+	// Filter the rows before grouping them, sorting and pagination apply to the groups instead
+	rows, selectErr := table.selectRows(DBQuery{WhereClause: query.WhereClause})
+	if selectErr != nil {
+		return ResultSet{}, selectErr
+	}
+
+	groups, groupErr := groupRows(rows, groupConfigs)
+	if groupErr != nil {
+		return ResultSet{}, groupErr
+	}
+
+	groupTable := DBTable{
+		Name:         table.Name,
+		ColumnConfig: append(groupConfigs, aggregateConfigs...),
+		RowValues:    []RowValue{},
+	}
+
+	for _, group := range groups {
+		row := RowValue{ColumnValues: group.keyValues}
+
+		for index, aggregate := range query.Aggregates {
+			value, aggregateErr := aggregate.evaluate(group.rows, sourceColumns[index], aggregateConfigs[index])
+			if aggregateErr != nil {
+				return ResultSet{}, aggregateErr
+			}
+
+			row.ColumnValues[aggregate.name()] = value
+		}
+
+		groupTable.RowValues = append(groupTable.RowValues, row)
+	}
+
+	return groupTable.pullResultSet(DBQuery{
+		Operation:   query.Operation,
+		ColumnNames: query.ColumnNames,
+		WhereClause: query.HavingClause,
+		SortClause:  query.SortClause,
+		Limit:       query.Limit,
+		Offset:      query.Offset,
+	})
+}
+
+// Checks if the query uses an aggregate with the name specified
+func (query *DBQuery) hasAggregate(name string) bool {
+	for _, aggregate := range query.Aggregates {
+		if aggregate.name() == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Works out the column config for the result of an aggregate, along with the full name of the column it reads from
+// ** COUNT is always an int and AVG always a float64, SUM keeps int columns as an int
+func (table *DBTable) aggregateColumnConfig(aggregate AggregateCall) (ColumnConfig, string, error) {
+	config := ColumnConfig{ColumnName: aggregate.name(), Nullable: true}
+
+	if aggregate.Column == "*" {
+		config.ColumnType = "int"
+		return config, "*", nil
+	}
+
+	sourceConfig, columnErr := table.getColumnConfig(aggregate.Column)
+	if columnErr != nil {
+		return ColumnConfig{}, "", columnErr
+	}
+
+	sourceType := normaliseColumnType(sourceConfig.ColumnType)
+
+	switch aggregate.Function {
+	case "COUNT":
+		config.ColumnType = "int"
+	case "SUM", "AVG":
+		if sourceType != "int" && sourceType != "float64" {
+			return ColumnConfig{}, "", fmt.Errorf("%v cannot be used on column %v of type %v", aggregate.Function, aggregate.Column, sourceConfig.ColumnType)
+		}
+
+		config.ColumnType = "float64"
+		if aggregate.Function == "SUM" {
+			config.ColumnType = sourceType
+		}
+	default:
+		config.ColumnType = sourceConfig.ColumnType
+	}
+
+	return config, sourceConfig.ColumnName, nil
+}
+
+// Works out the value of an aggregate over a group of rows
+// ** Null values are skipped, SUM, AVG, MIN and MAX return null when the group has no other values
+func (aggregate AggregateCall) evaluate(rows []RowValue, sourceColumn string, config ColumnConfig) (any, error) {
+	if sourceColumn == "*" {
+		return len(rows), nil
+	}
+
+	values := []any{}
+	for _, row := range rows {
+		if value := row.ColumnValues[sourceColumn]; value != nil {
+			values = append(values, value)
+		}
+	}
+
+	if aggregate.Function == "COUNT" {
+		return len(values), nil
+	}
+
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	switch aggregate.Function {
+	case "SUM", "AVG":
+		total := 0.0
+		for _, value := range values {
+			number, _ := numericValue(value)
+			total = total + number
+		}
+
+		if aggregate.Function == "AVG" {
+			return total / float64(len(values)), nil
+		}
+
+		return coerceValue(total, config.ColumnType)
+
+	case "MIN", "MAX":
+		result := values[0]
+		for _, value := range values[1:] {
+			comparison, compareErr := compareValues(value, result)
+			if compareErr != nil {
+				return nil, fmt.Errorf("failed to work out %v: %w", aggregate.name(), compareErr)
+			}
+
+			if (aggregate.Function == "MIN" && comparison < 0) || (aggregate.Function == "MAX" && comparison > 0) {
+				result = value
+			}
+		}
+
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("%v is not a supported aggregate function", aggregate.Function)
+}
+
+// Splits rows into groups by the values of the columns specified, keeping the order each group was first seen in
+// ** Without any columns every row is put into a single group, even when there are no rows
+func groupRows(rows []RowValue, groupConfigs []ColumnConfig) ([]*rowGroup, error) {
+	groups := []*rowGroup{}
+	groupIndex := map[string]*rowGroup{}
+
+	if len(groupConfigs) == 0 {
+		return []*rowGroup{{keyValues: map[string]any{}, rows: rows}}, nil
+	}
+
+	for _, row := range rows {
+		keyValues := map[string]any{}
+		keyParts := []string{}
+
+		for _, config := range groupConfigs {
+			value, typeErr := coerceValue(row.ColumnValues[config.ColumnName], config.ColumnType)
+			if typeErr != nil {
+				return nil, fmt.Errorf("failed to group by column %v: %w", config.ColumnName, typeErr)
+			}
+
+			keyValues[config.ColumnName] = value
+			keyParts = append(keyParts, groupKeyPart(value))
+		}
+
+		key := strings.Join(keyParts, "\x00")
+		group, exists := groupIndex[key]
+		if !exists {
+			group = &rowGroup{keyValues: keyValues}
+			groupIndex[key] = group
+			groups = append(groups, group)
+		}
+
+		group.rows = append(group.rows, row)
+	}
+
+	return groups, nil
+}
+
+// Converts a typed value into part of a group key, keeping null apart from any string value
+func groupKeyPart(value any) string {
+	if value == nil {
+		return "null"
+	}
+
+	return fmt.Sprintf("%T:%v", value, valueToString(value))
+}
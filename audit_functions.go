@@ -0,0 +1,391 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Path of the audit log, which holds an entry for every query run and every change made to the system database
+const auditLogPath = "system/audit.log"
+
+// Blame given to changes made to the system database when no user is logged in, e.g. while it is first set up
+const systemBlame = "system"
+
+// Prefix of the blame given to commands run from the command line, where no user of the database logs in
+const commandLineBlamePrefix = "os:"
+
+// The open audit log of a system database
+type auditLog struct {
+	file         *os.File
+	lastSequence int
+	lastHash     string
+}
+
+// The audit entries to return, empty fields match every entry
+type AuditFilter struct {
+	Username string
+	Table    string
+	Action   string
+	From     time.Time
+	To       time.Time
+}
+
+// Opens the audit log so new entries can be added to it, checking none of the existing entries have been changed
+func (s *SystemDB) openAuditLog() error {
+	if s.audit != nil {
+		return nil
+	}
+
+	content, readErr := os.ReadFile(auditLogPath)
+	if readErr != nil && !errors.Is(readErr, fs.ErrNotExist) {
+		return readErr
+	}
+
+	entries, validLength, parseErr := parseAuditEntries(content)
+	if parseErr != nil {
+		return fmt.Errorf("failed to read the audit log: %w", parseErr)
+	}
+
+	file, openErr := os.OpenFile(auditLogPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0755)
+	if openErr != nil {
+		return openErr
+	}
+
+	// An entry cut short by a crash was never fully written, so it is dropped from the end of the log
+	if validLength < len(content) {
+		truncateErr := file.Truncate(int64(validLength))
+		if truncateErr != nil {
+			file.Close()
+			return fmt.Errorf("failed to remove an incomplete entry from the audit log: %w", truncateErr)
+		}
+	}
+
+	s.audit = &auditLog{file: file}
+	if len(entries) > 0 {
+		s.audit.lastSequence = entries[len(entries)-1].Sequence
+		s.audit.lastHash = entries[len(entries)-1].Hash
+	}
+
+	return nil
+}
+
+// Closes the audit log, changes stop being recorded until it is opened again
+func (s *SystemDB) closeAuditLog() error {
+	if s.audit == nil {
+		return nil
+	}
+
+	closeErr := s.audit.file.Close()
+	s.audit = nil
+
+	return closeErr
+}
+
+// Splits the audit log into its entries, checking each one is in order and linked to the entry before it
+// ** Every entry holds the hash of the one before, so an entry that is changed, removed or moved breaks the chain from that point on.
+// Entries removed from the very end of the log can't be detected this way
+func parseAuditEntries(content []byte) ([]TransactionLog, int, error) {
+	keyring, keyringErr := masterKeyring()
+	if keyringErr != nil {
+		return nil, 0, keyringErr
+	}
+
+	frames, validLength, frameErr := readLogFrames(content, func(_ int, frame []byte) ([]byte, error) {
+		return keyring.open(frame)
+	})
+	if frameErr != nil {
+		return nil, 0, frameErr
+	}
+
+	entries := []TransactionLog{}
+	previousHash := ""
+
+	for frameIndex, frame := range frames {
+		entry := TransactionLog{}
+		unmarshalErr := json.Unmarshal(frame, &entry)
+		if unmarshalErr != nil {
+			return nil, 0, fmt.Errorf("entry %v is invalid: %w", frameIndex+1, unmarshalErr)
+		}
+
+		if entry.Sequence != frameIndex+1 {
+			return nil, 0, fmt.Errorf("entry %v was found where entry %v was expected, entries have been removed or moved", entry.Sequence, frameIndex+1)
+		}
+
+		if entry.PreviousHash != previousHash || entry.hash() != entry.Hash {
+			return nil, 0, fmt.Errorf("entry %v has been changed since it was written", entry.Sequence)
+		}
+
+		entries = append(entries, entry)
+		previousHash = entry.Hash
+	}
+
+	return entries, validLength, nil
+}
+
+// Returns the hash of the entry, which covers every field other than the hash itself
+func (entry TransactionLog) hash() string {
+	entry.Hash = ""
+
+	// ** The entry only holds strings and numbers, so it can always be converted
+	content, _ := json.Marshal(entry)
+	sum := sha256.Sum256(content)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Adds an entry to the end of the audit log, the error is the one returned by the action, if it failed
+// ** Nothing is recorded for a system database that hasn't been loaded from file, as it has no audit log open
+func (s *SystemDB) recordAudit(blame string, actionType string, scope string, detail string, actionErr error) error {
+	if s.audit == nil {
+		return nil
+	}
+
+	entry := TransactionLog{
+		Sequence:     s.audit.lastSequence + 1,
+		EventTime:    time.Now().UTC().Format(time.RFC3339Nano),
+		Action:       TransactionAction{ActionType: actionType, ActionScope: scope},
+		Blame:        blame,
+		Detail:       detail,
+		PreviousHash: s.audit.lastHash,
+	}
+
+	if actionErr != nil {
+		entry.Error = actionErr.Error()
+	}
+
+	entry.Hash = entry.hash()
+
+	content, marshalErr := json.Marshal(entry)
+	if marshalErr != nil {
+		return marshalErr
+	}
+
+	keyring, keyringErr := masterKeyring()
+	if keyringErr != nil {
+		return keyringErr
+	}
+
+	// The audit log isn't a table, so is sealed with the master key rather than a data key of its own
+	appendErr := appendLogFrame(s.audit.file, content, keyring.seal)
+	if appendErr != nil {
+		return fmt.Errorf("failed to write to the audit log: %w", appendErr)
+	}
+
+	s.audit.lastSequence = entry.Sequence
+	s.audit.lastHash = entry.Hash
+	return nil
+}
+
+// Records a change made to the system database, blamed on the user the system database is acting for
+func (s *SystemDB) recordChange(actionType string, scope string, detail string) error {
+	blame := s.actor
+	if blame == "" {
+		blame = systemBlame
+	}
+
+	return s.recordAudit(blame, actionType, scope, detail, nil)
+}
+
+// Confirms the auth token of a logged in user was made from their private token
+func (s *SystemDB) authenticate(user PublicAccessUser) error {
+	for _, userItem := range s.Users {
+		if userItem.Username != user.Username {
+			continue
+		}
+
+		isValid, validErr := confirmPublicKey(user.PublicToken, userItem.UserPrivateToken)
+		if validErr != nil || !isValid {
+			break
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("the auth token for user %v could not be confirmed", user.Username)
+}
+
+// Records a query run against the database, blamed on the user running it
+// ** A user whose auth token wasn't confirmed is only named in the entry, so a query can't be blamed on someone who didn't send it
+func (db *DB) auditQuery(query DBQuery, queryStr string, queryErr error) error {
+	if db.System == nil {
+		return nil
+	}
+
+	scope := query.Database
+	if query.TableName != "" {
+		scopes := []string{}
+		for _, tableName := range query.tableNames() {
+			scopes = append(scopes, db.scopeName(tableName))
+		}
+
+		scope = strings.Join(scopes, ",")
+	}
+
+	blame := db.actor
+	if blame == "" {
+		blame = "unauthenticated: " + db.User.Username
+	}
+
+	return db.System.recordAudit(blame, query.Operation, scope, redactQuery(queryStr), queryErr)
+}
+
+// Reports if running the query can change the rows or tables held in the stores
+func (query DBQuery) changesStores() bool {
+	switch query.Operation {
+	case "PUSH", "PUT", "DELETE", "CREATE", "DROP", "ALTER", "COMMIT":
+		return true
+	}
+
+	return false
+}
+
+// Returns the query with every value in it replaced by ?, so passwords and anything else set or searched for aren't kept in
+// the audit log, which can never have an entry removed
+// ** Values can be given without quotes, so a name straight after an operator is replaced too, even if it names a column.
+// Text that can't be broken into tokens is replaced as a whole
+func redactQuery(queryStr string) string {
+	tokens, tokenErr := tokenizeQuery(queryStr)
+	if tokenErr != nil {
+		return "?"
+	}
+
+	// Token positions are given by line and column, so the offset each line starts at is needed to find them in the query
+	input := []rune(queryStr)
+	lineStarts := []int{0}
+	for index, r := range input {
+		if r == '\n' {
+			lineStarts = append(lineStarts, index+1)
+		}
+	}
+
+	offset := func(position Position) int {
+		return lineStarts[position.Line-1] + position.Column - 1
+	}
+
+	var builder strings.Builder
+	copied := 0
+	for tokenIndex := 0; tokenIndex < len(tokens)-1; tokenIndex++ {
+		token := tokens[tokenIndex]
+		isValue := token.Type == tokenString || token.Type == tokenNumber
+		if token.Type == tokenIdentifier && tokenIndex > 0 && tokens[tokenIndex-1].Type == tokenOperator {
+			isValue = true
+		}
+
+		if !isValue {
+			continue
+		}
+
+		// A token runs up to the one after it, less the space between them
+		start := offset(token.Pos)
+		end := offset(tokens[tokenIndex+1].Pos)
+		for end > start && unicode.IsSpace(input[end-1]) {
+			end = end - 1
+		}
+
+		builder.WriteString(string(input[copied:start]))
+		builder.WriteString("?")
+		copied = end
+	}
+
+	builder.WriteString(string(input[copied:]))
+	return builder.String()
+}
+
+// Reads every entry of the audit log that matches the filter, failing if any entry has been changed
+func readAuditLog(filter AuditFilter) ([]TransactionLog, error) {
+	content, readErr := os.ReadFile(auditLogPath)
+	if errors.Is(readErr, fs.ErrNotExist) {
+		return []TransactionLog{}, nil
+	} else if readErr != nil {
+		return nil, readErr
+	}
+
+	entries, _, parseErr := parseAuditEntries(content)
+	if parseErr != nil {
+		return nil, fmt.Errorf("failed to read the audit log: %w", parseErr)
+	}
+
+	matchingEntries := []TransactionLog{}
+	for _, entry := range entries {
+		if filter.matches(entry) {
+			matchingEntries = append(matchingEntries, entry)
+		}
+	}
+
+	return matchingEntries, nil
+}
+
+// Checks if an audit entry matches every field set in the filter
+// ** Queries that join tables are recorded with each table in the scope, so match a filter for any one of them
+func (filter AuditFilter) matches(entry TransactionLog) bool {
+	if filter.Username != "" && entry.Blame != filter.Username {
+		return false
+	}
+
+	if filter.Action != "" && !strings.EqualFold(entry.Action.ActionType, filter.Action) {
+		return false
+	}
+
+	if filter.Table != "" && !slices.Contains(strings.Split(entry.Action.ActionScope, ","), filter.Table) {
+		return false
+	}
+
+	eventTime, timeErr := time.Parse(time.RFC3339Nano, entry.EventTime)
+	if timeErr != nil {
+		return filter.From.IsZero() && filter.To.IsZero()
+	}
+
+	if !filter.From.IsZero() && eventTime.Before(filter.From) {
+		return false
+	}
+
+	if !filter.To.IsZero() && eventTime.After(filter.To) {
+		return false
+	}
+
+	return true
+}
+
+// Converts audit entries into a result set, so they can be printed like the result of a query
+func auditResultSet(entries []TransactionLog) ResultSet {
+	result := ResultSet{
+		Operation: "PULL",
+		Columns: []ResultColumn{
+			{Name: "Sequence", Type: "int"},
+			{Name: "EventTime", Type: "string"},
+			{Name: "Blame", Type: "string"},
+			{Name: "Action", Type: "string"},
+			{Name: "Scope", Type: "string"},
+			{Name: "Detail", Type: "string"},
+			{Name: "Error", Type: "string"},
+		},
+		Rows: [][]any{},
+	}
+
+	for _, entry := range entries {
+		result.Rows = append(result.Rows, []any{entry.Sequence, entry.EventTime, entry.Blame, entry.Action.ActionType, entry.Action.ActionScope, entry.Detail, entry.Error})
+	}
+
+	return result
+}
+
+// Returns the blame for a command run from the command line, naming the user of the operating system that ran it
+// ** Falls back to systemBlame when the user can't be found, so the command is still audited
+func commandLineBlame() string {
+	current, userErr := user.Current()
+	if userErr != nil || current.Username == "" {
+		return systemBlame
+	}
+
+	return commandLineBlamePrefix + current.Username
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reads the audit log and returns the action of each entry as "<blame> <action> <scope>", failing the test if it can't be read
+func auditActions(t *testing.T, filter AuditFilter) []string {
+	entries, readErr := readAuditLog(filter)
+	if readErr != nil {
+		t.Fatalf("unexpected error: %v", readErr)
+	}
+
+	actions := []string{}
+	for _, entry := range entries {
+		actions = append(actions, strings.TrimSpace(entry.Blame+" "+entry.Action.ActionType+" "+entry.Action.ActionScope))
+	}
+
+	return actions
+}
+
+// rewrites the audit log with the given entries, encrypting each one with the main key as the database would
+func writeAuditEntries(t *testing.T, entries []TransactionLog) {
+	file, openErr := os.OpenFile(auditLogPath, os.O_WRONLY|os.O_TRUNC, 0755)
+	if openErr != nil {
+		t.Fatalf("unexpected error: %v", openErr)
+	}
+	defer file.Close()
+
+	keyring, keyringErr := masterKeyring()
+	if keyringErr != nil {
+		t.Fatalf("unexpected error: %v", keyringErr)
+	}
+
+	for _, entry := range entries {
+		content, _ := json.Marshal(entry)
+		if appendErr := appendLogFrame(file, content, keyring.seal); appendErr != nil {
+			t.Fatalf("unexpected error: %v", appendErr)
+		}
+	}
+}
+
+// test the audit log records queries and system changes, and can be filtered
+func Test_auditLog(t *testing.T) {
+	useTestStore(t)
+
+	systemDB := SystemDB{}
+	if openErr := systemDB.openAuditLog(); openErr != nil {
+		t.Fatalf("unexpected error: %v", openErr)
+	}
+	defer systemDB.closeAuditLog()
+
+	// set up an admin that can do anything and a reader without any roles
+	systemDB.createBasePolicies()
+	if rolesErr := systemDB.createBaseRoles(); rolesErr != nil {
+		t.Fatalf("unexpected error: %v", rolesErr)
+	}
+
+	admin, adminErr := systemDB.createUser("admin", "admin")
+	if adminErr != nil {
+		t.Fatalf("unexpected error: %v", adminErr)
+	}
+
+	adminRole, _ := systemDB.findRoleByName("Root Admin")
+	if assignErr := systemDB.assignUserToRole(admin, adminRole); assignErr != nil {
+		t.Fatalf("unexpected error: %v", assignErr)
+	}
+
+	// changes made after logging in are blamed on the admin rather than the system
+	admin, loginErr := systemDB.userLogin("admin", "admin")
+	if loginErr != nil {
+		t.Fatalf("unexpected error: %v", loginErr)
+	}
+
+	reader, readerErr := systemDB.createUser("reader", "reader")
+	if readerErr != nil {
+		t.Fatalf("unexpected error: %v", readerErr)
+	}
+
+	if _, loginErr := systemDB.userLogin("reader", "wrong"); loginErr == nil {
+		t.Fatalf("expected an error for an incorrect password")
+	}
+
+	adminDB := DB{System: &systemDB, User: admin}
+	defer adminDB.Close()
+	for _, query := range []string{
+		"CREATE TABLE Orders (Order_ID int, Amount int, PRIMARY KEY Order_ID AUTO)",
+		"PUSH Amount = 10 TO Orders",
+	} {
+		if _, queryErr := adminDB.runQuery(query); queryErr != nil {
+			t.Fatalf("unexpected error for %v: %v", query, queryErr)
+		}
+	}
+
+	readerDB := DB{System: &systemDB, User: reader}
+	if _, queryErr := readerDB.runQuery("PULL Amount FROM Orders"); queryErr == nil {
+		t.Fatalf("expected an error for a user without the PULL permission")
+	}
+
+	t.Run("Test every action is recorded", func(t *testing.T) {
+		expectedActions := []string{
+			"system CREATE USER admin",
+			"system ASSIGN USER TO ROLE admin",
+			"admin LOGIN admin",
+			"admin CREATE USER reader",
+			"reader LOGIN reader",
+			"admin CREATE Orders",
+			"admin PUSH Orders",
+			"reader PULL Orders",
+		}
+
+		// the base roles and policies are created before the users, so only the end of the log is checked
+		actions := auditActions(t, AuditFilter{})
+		if len(actions) < len(expectedActions) || !reflect.DeepEqual(actions[len(actions)-len(expectedActions):], expectedActions) {
+			t.Fatalf("result was incorrect, got: %v, expected it to end with: %v", actions, expectedActions)
+		}
+	})
+
+	t.Run("Test failed actions hold their error", func(t *testing.T) {
+		entries, readErr := readAuditLog(AuditFilter{Username: "reader", Action: "pull"})
+		if readErr != nil {
+			t.Fatalf("unexpected error: %v", readErr)
+		}
+
+		if len(entries) != 1 || entries[0].Error != "user reader does not have the PULL permission for table Orders" || entries[0].Detail != "PULL Amount FROM Orders" {
+			t.Fatalf("result was incorrect, got: %+v", entries)
+		}
+	})
+
+	t.Run("Test filtering by table and time", func(t *testing.T) {
+		expectedActions := []string{"admin CREATE Orders", "admin PUSH Orders", "reader PULL Orders"}
+		if actions := auditActions(t, AuditFilter{Table: "Orders"}); !reflect.DeepEqual(actions, expectedActions) {
+			t.Fatalf("result was incorrect, got: %v, expected: %v", actions, expectedActions)
+		}
+
+		// the time range includes entries at its bounds
+		entries, _ := readAuditLog(AuditFilter{Table: "Orders"})
+		createdTime, _ := time.Parse(time.RFC3339Nano, entries[0].EventTime)
+		if actions := auditActions(t, AuditFilter{Username: "admin", To: createdTime}); !reflect.DeepEqual(actions, []string{"admin LOGIN admin", "admin CREATE USER reader", "admin CREATE Orders"}) {
+			t.Fatalf("result was incorrect, got: %v", actions)
+		}
+	})
+
+	t.Run("Test a query with an auth token that can't be confirmed isn't blamed on the user it names", func(t *testing.T) {
+		forgedDB := DB{System: &systemDB, User: PublicAccessUser{Username: "admin", PublicToken: reader.PublicToken}}
+		if _, queryErr := forgedDB.runQuery("PUSH Amount = 20 TO Orders"); queryErr == nil || queryErr.Error() != "the auth token for user admin could not be confirmed" {
+			t.Fatalf("error result was incorrect, got: %v", queryErr)
+		}
+
+		expectedActions := []string{"unauthenticated: admin PUSH Orders"}
+		if actions := auditActions(t, AuditFilter{Username: "unauthenticated: admin"}); !reflect.DeepEqual(actions, expectedActions) {
+			t.Fatalf("result was incorrect, got: %v, expected: %v", actions, expectedActions)
+		}
+
+		if actions := auditActions(t, AuditFilter{Username: "admin", Action: "PUSH"}); !reflect.DeepEqual(actions, []string{"admin PUSH Orders"}) {
+			t.Fatalf("result was incorrect, got: %v", actions)
+		}
+	})
+
+	t.Run("Test a failed change is recorded before it is run and again with its error", func(t *testing.T) {
+		if _, queryErr := adminDB.runQuery("PUSH Amount = 'lots' TO Orders"); queryErr == nil {
+			t.Fatalf("expected an error for a value of the wrong type")
+		}
+
+		entries, readErr := readAuditLog(AuditFilter{Username: "admin", Action: "PUSH"})
+		if readErr != nil {
+			t.Fatalf("unexpected error: %v", readErr)
+		}
+
+		if len(entries) != 3 || entries[1].Error != "" || entries[2].Error == "" || entries[2].Detail != "PUSH Amount = ? TO Orders" {
+			t.Fatalf("result was incorrect, got: %+v", entries)
+		}
+	})
+
+	t.Run("Test a changed entry is detected", func(t *testing.T) {
+		entries, readErr := readAuditLog(AuditFilter{})
+		if readErr != nil {
+			t.Fatalf("unexpected error: %v", readErr)
+		}
+
+		changedEntries := append([]TransactionLog{}, entries...)
+		changedEntries[len(changedEntries)-1].Blame = "intruder"
+		writeAuditEntries(t, changedEntries)
+
+		if _, readErr := readAuditLog(AuditFilter{}); readErr == nil || !strings.Contains(readErr.Error(), "has been changed since it was written") {
+			t.Fatalf("expected an error for a changed entry, got: %v", readErr)
+		}
+
+		// removing an entry from the middle of the log breaks the sequence
+		writeAuditEntries(t, append(append([]TransactionLog{}, entries[:1]...), entries[2:]...))
+		if _, readErr := readAuditLog(AuditFilter{}); readErr == nil || !strings.Contains(readErr.Error(), "entries have been removed or moved") {
+			t.Fatalf("expected an error for a removed entry, got: %v", readErr)
+		}
+
+		writeAuditEntries(t, entries)
+	})
+	t.Run("Test a change the audit log can't hold isn't made", func(t *testing.T) {
+		// closing the file underneath the audit log makes every write to it fail
+		systemDB.audit.file.Close()
+
+		if _, groupErr := systemDB.createGroup("Auditors"); groupErr == nil {
+			t.Fatalf("expected an error writing to a closed audit log")
+		}
+
+		if _, findErr := systemDB.findGroupByName("Auditors"); findErr == nil {
+			t.Fatalf("the group was created without being recorded")
+		}
+
+		tableIndex, _ := adminDB.getTable("Orders")
+		rowCount := len(adminDB.Tables[tableIndex].RowValues)
+		if _, queryErr := adminDB.runQuery("PUSH Amount = 99 TO Orders"); queryErr == nil || !strings.HasPrefix(queryErr.Error(), "the query was not run") {
+			t.Fatalf("error result was incorrect, got: %v", queryErr)
+		}
+
+		if len(adminDB.Tables[tableIndex].RowValues) != rowCount {
+			t.Fatalf("the row was added without being recorded")
+		}
+	})
+}
+
+// test values are taken out of queries before they are added to the audit log
+func Test_redactQuery(t *testing.T) {
+	testTemplates := []TestTemplate{
+		{
+			TestName:       "Test assigned values",
+			Inputs:         map[string]any{"query": "PUT Password = 'hunter2', Attempts = 0 TO Users WHERE Username = admin"},
+			ExpectedOutput: "PUT Password = ?, Attempts = ? TO Users WHERE Username = ?",
+		},
+		{
+			TestName:       "Test values searched for",
+			Inputs:         map[string]any{"query": "PULL Amount FROM Orders WHERE Amount >= 10 AND Note LIKE 'it''s\\%'  SORT BY Amount"},
+			ExpectedOutput: "PULL Amount FROM Orders WHERE Amount >= ? AND Note LIKE ?  SORT BY Amount",
+		},
+		{
+			TestName:       "Test query over several lines",
+			Inputs:         map[string]any{"query": "PUSH Email = 'ada@example.com',\n  Age = 36 TO Members"},
+			ExpectedOutput: "PUSH Email = ?,\n  Age = ? TO Members",
+		},
+		{
+			TestName:       "Test query without values",
+			Inputs:         map[string]any{"query": "InsertStruct(Orders, Order)"},
+			ExpectedOutput: "InsertStruct(Orders, Order)",
+		},
+		{
+			TestName:       "Test text that isn't a query",
+			Inputs:         map[string]any{"query": "PUSH Password = 'hunter2 TO Users"},
+			ExpectedOutput: "?",
+		},
+	}
+
+	for _, test := range testTemplates {
+		t.Run(test.TestName, func(t *testing.T) {
+			if redacted := redactQuery(test.Inputs["query"].(string)); redacted != test.ExpectedOutput {
+				t.Fatalf("result was incorrect, got: %v, expected: %v", redacted, test.ExpectedOutput)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Functions that can be given as the DEFAULT of a column, along with the column type they give a value for
+var defaultFunctions = map[string]string{"now": "time", "uuid": "string"}
+
+// Parsed CHECK expressions, so each one is only parsed once for all the rows it is checked against
+var checkCache sync.Map
+
+// Generates a random version 4 UUID
+func newUUID() (string, error) {
+	id := make([]byte, 16)
+	if _, randErr := rand.Read(id); randErr != nil {
+		return "", fmt.Errorf("failed to generate a uuid: %w", randErr)
+	}
+
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:]), nil
+}
+
+// Returns the value a column is given when a PUSH leaves it out, nil if the column has no default
+func (config ColumnConfig) defaultValue() (any, error) {
+	if config.Default == nil {
+		return nil, nil
+	}
+
+	switch config.Default.Function {
+	case "now":
+		return time.Now().UTC(), nil
+	case "uuid":
+		return newUUID()
+	case "":
+		return config.Default.Value, nil
+	}
+
+	return nil, fmt.Errorf("%v() is not a supported default for column %v", config.Default.Function, config.ColumnName)
+}
+
+// Returns the parsed CHECK expression of a column
+func (config ColumnConfig) checkExpression() (WhereExpr, error) {
+	if cached, exists := checkCache.Load(config.Check); exists {
+		return cached.(WhereExpr), nil
+	}
+
+	expr, parseErr := parseExpression(config.Check)
+	if parseErr != nil {
+		return nil, fmt.Errorf("the CHECK of column %v is invalid: %w", config.ColumnName, parseErr)
+	}
+
+	checkCache.Store(config.Check, expr)
+	return expr, nil
+}
+
+// Returns the index of a UNIQUE column, building it first if it has been cleared
+func (table *DBTable) uniqueIndex(columnName string) keyIndex {
+	if index, exists := table.uniqueIndexes[columnName]; exists {
+		return index
+	}
+
+	if table.uniqueIndexes == nil {
+		table.uniqueIndexes = map[string]keyIndex{}
+	}
+
+	index := keyIndex{}
+	for rowIndex, row := range table.RowValues {
+		index.add(row.ColumnValues[columnName], rowIndex)
+	}
+
+	table.uniqueIndexes[columnName] = index
+	return index
+}
+
+// Checks the values of a row against the UNIQUE and CHECK constraints of the table, skipping the row at the position given
+// ** UNIQUE is only checked for the changed values, null values never break UNIQUE and skip the CHECK of their column
+func (table *DBTable) confirmConstraints(row map[string]any, changedValues map[string]any, skipRow int) error {
+	for _, config := range table.ColumnConfig {
+		value := row[config.ColumnName]
+		if value == nil {
+			continue
+		}
+
+		if _, isChanged := changedValues[config.ColumnName]; isChanged && config.Unique {
+			for _, rowIndex := range table.uniqueIndex(config.ColumnName)[indexKey(value)] {
+				if rowIndex != skipRow {
+					return fmt.Errorf("a row already exists in table %v with the value %v for UNIQUE column %v", table.Name, value, config.ColumnName)
+				}
+			}
+		}
+
+		if config.Check == "" {
+			continue
+		}
+
+		check, checkErr := config.checkExpression()
+		if checkErr != nil {
+			return checkErr
+		}
+
+		isMatch, matchErr := table.rowMatches(RowValue{ColumnValues: row}, check)
+		if matchErr != nil {
+			return fmt.Errorf("failed to check column %v: %w", config.ColumnName, matchErr)
+		}
+
+		if !isMatch {
+			return fmt.Errorf("value %v for column %v does not pass its CHECK (%v)", value, config.ColumnName, config.Check)
+		}
+	}
+
+	return nil
+}
+
+// Calls visit with every operand within the expression, so they can be read or changed in place
+func visitOperands(expr WhereExpr, visit func(operand *Operand)) {
+	switch expr := expr.(type) {
+	case *LogicalCondition:
+		visitOperands(expr.Left, visit)
+		visitOperands(expr.Right, visit)
+
+	case *NotCondition:
+		visitOperands(expr.Expr, visit)
+
+	case *Condition:
+		visit(&expr.Left)
+		visit(&expr.Right)
+		for index := range expr.Values {
+			visit(&expr.Values[index])
+		}
+	}
+}
+
+// Checks if a CHECK expression refers to the column given
+func checkUsesColumn(check string, columnName string) (bool, error) {
+	expr, parseErr := parseExpression(check)
+	if parseErr != nil {
+		return false, parseErr
+	}
+
+	usesColumn := false
+	visitOperands(expr, func(operand *Operand) {
+		usesColumn = usesColumn || (operand.isIdentifier() && operand.Text == columnName)
+	})
+
+	return usesColumn, nil
+}
+
+// Rewrites a CHECK expression to use the new name of a renamed column
+// ** The expression is parsed again rather than taken from the cache, as the cached expression is shared
+func renameCheckColumn(check string, columnName string, newColumnName string) (string, error) {
+	expr, parseErr := parseExpression(check)
+	if parseErr != nil {
+		return "", parseErr
+	}
+
+	visitOperands(expr, func(operand *Operand) {
+		if operand.isIdentifier() && operand.Text == columnName {
+			operand.Text = newColumnName
+			operand.Value = newColumnName
+		}
+	})
+
+	return expr.String(), nil
+}
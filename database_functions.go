@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Name of the database used when none has been chosen, its tables are kept in the stores folder itself
+const defaultDatabase = "default"
+
+// The catalog of every database and the tables within it, kept alongside the table files
+// ** The file extension keeps it apart from the .dat file of a table called catalog
+const catalogPath = "stores/untold.catalog"
+
+// Lists each database and the schema of every table within it
+type Catalog struct {
+	Databases []CatalogDatabase
+}
+
+type CatalogDatabase struct {
+	Name   string
+	Tables []CatalogTable
+}
+
+// The schema of a table, as it was when the table was last saved
+type CatalogTable struct {
+	Name          string
+	Columns       []ColumnConfig
+	PrimaryKey    string
+	AutoIncrement bool         `json:",omitempty"`
+	ForeignKeys   []ForeignKey `json:",omitempty"`
+}
+
+// Returns the name of the database the tables are stored in
+func (db *DB) databaseName() string {
+	if db.Name == "" {
+		return defaultDatabase
+	}
+
+	return db.Name
+}
+
+// Returns the name a table is given in role scopes and the audit log, tables outside the default database are given as
+// <database>.<table>
+func (db *DB) scopeName(tableName string) string {
+	if db.databaseName() == defaultDatabase {
+		return tableName
+	}
+
+	return db.databaseName() + "." + tableName
+}
+
+// Returns the folder holding the files of a database, tables of the default database are kept directly in stores
+func databaseFolder(databaseName string) string {
+	if databaseName == "" || databaseName == defaultDatabase {
+		return "stores"
+	}
+
+	return "stores/" + databaseName
+}
+
+// Returns the catalog entry of a table, describing its current schema
+func (table *DBTable) catalogEntry() CatalogTable {
+	return CatalogTable{
+		Name:          table.Name,
+		Columns:       table.ColumnConfig,
+		PrimaryKey:    table.PrimaryKeyColumnName,
+		AutoIncrement: table.AutoIncrementPrimary,
+		ForeignKeys:   table.ForeignKeys,
+	}
+}
+
+// Returns the entry of a database within the catalog, nil if it isn't there
+func (catalog *Catalog) database(databaseName string) *CatalogDatabase {
+	databaseIndex := slices.IndexFunc(catalog.Databases, func(database CatalogDatabase) bool { return database.Name == databaseName })
+	if databaseIndex == -1 {
+		return nil
+	}
+
+	return &catalog.Databases[databaseIndex]
+}
+
+// Reads the catalog from file, building it from the table files when there isn't one yet
+func readCatalog() (Catalog, error) {
+	content, readErr := os.ReadFile(catalogPath)
+	if errors.Is(readErr, fs.ErrNotExist) {
+		return buildCatalog()
+	} else if readErr != nil {
+		return Catalog{}, readErr
+	}
+
+	decryptedContent, decryptErr := openData(content)
+	if decryptErr != nil {
+		return Catalog{}, fmt.Errorf("failed to read the catalog: %w", decryptErr)
+	}
+
+	catalog := Catalog{}
+	unmarshalErr := json.Unmarshal(decryptedContent, &catalog)
+	if unmarshalErr != nil {
+		return Catalog{}, fmt.Errorf("failed to read the catalog: %w", unmarshalErr)
+	}
+
+	return catalog, nil
+}
+
+// Builds the catalog from the table files in the stores folder, for stores made before there was a catalog
+// ** Every folder within stores is taken to be a database
+func buildCatalog() (Catalog, error) {
+	catalog := Catalog{Databases: []CatalogDatabase{{Name: defaultDatabase, Tables: []CatalogTable{}}}}
+
+	entries, readErr := os.ReadDir("stores")
+	if readErr != nil && !errors.Is(readErr, fs.ErrNotExist) {
+		return Catalog{}, readErr
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			catalog.Databases = append(catalog.Databases, CatalogDatabase{Name: entry.Name(), Tables: []CatalogTable{}})
+		}
+	}
+
+	for index := range catalog.Databases {
+		database := &catalog.Databases[index]
+		tableFiles, globErr := filepath.Glob(filepath.Join(databaseFolder(database.Name), "*.dat"))
+		if globErr != nil {
+			return Catalog{}, globErr
+		}
+
+		for _, tableFile := range tableFiles {
+			table, tableErr := readTableFile(database.Name, strings.TrimSuffix(filepath.Base(tableFile), ".dat"))
+			if tableErr != nil {
+				return Catalog{}, fmt.Errorf("failed to add %v to the catalog: %w", tableFile, tableErr)
+			}
+
+			database.Tables = append(database.Tables, table.catalogEntry())
+		}
+	}
+
+	return catalog, nil
+}
+
+// Reads the catalog, applies a change to it, then saves it again
+// ** The change returns false when it left the catalog as it was, so nothing needs to be written unless the catalog was
+// only just built from the table files
+func updateCatalog(change func(catalog *Catalog) (bool, error)) error {
+	_, statErr := os.Stat(catalogPath)
+	isBuilt := errors.Is(statErr, fs.ErrNotExist)
+
+	catalog, readErr := readCatalog()
+	if readErr != nil {
+		return readErr
+	}
+
+	isChanged, changeErr := change(&catalog)
+	if changeErr != nil || (!isChanged && !isBuilt) {
+		return changeErr
+	}
+
+	content, marshalErr := json.Marshal(catalog)
+	if marshalErr != nil {
+		return fmt.Errorf("failed to convert the catalog for saving: %w", marshalErr)
+	}
+
+	writeErr := writeEncryptedFile(catalogPath, content)
+	if writeErr != nil {
+		return fmt.Errorf("failed to save the catalog: %w", writeErr)
+	}
+
+	return nil
+}
+
+// Adds or updates the schema of a table in the catalog, only writing the catalog when the schema has changed
+func (table *DBTable) recordInCatalog() error {
+	return updateCatalog(func(catalog *Catalog) (bool, error) {
+		entry := table.catalogEntry()
+
+		database := catalog.database(table.database)
+		if database == nil {
+			catalog.Databases = append(catalog.Databases, CatalogDatabase{Name: table.database, Tables: []CatalogTable{entry}})
+			return true, nil
+		}
+
+		tableIndex := slices.IndexFunc(database.Tables, func(existing CatalogTable) bool { return existing.Name == table.Name })
+		if tableIndex == -1 {
+			database.Tables = append(database.Tables, entry)
+			return true, nil
+		}
+
+		if reflect.DeepEqual(database.Tables[tableIndex], entry) {
+			return false, nil
+		}
+
+		database.Tables[tableIndex] = entry
+		return true, nil
+	})
+}
+
+// Removes a dropped table from the catalog
+func removeFromCatalog(databaseName string, tableName string) error {
+	return updateCatalog(func(catalog *Catalog) (bool, error) {
+		database := catalog.database(databaseName)
+		if database == nil {
+			return false, nil
+		}
+
+		tableCount := len(database.Tables)
+		database.Tables = slices.DeleteFunc(database.Tables, func(table CatalogTable) bool { return table.Name == tableName })
+		return len(database.Tables) != tableCount, nil
+	})
+}
+
+// Runs CREATE DATABASE, USE, SHOW DATABASES, SHOW TABLES or DESCRIBE
+func (db *DB) runCatalogQuery(query DBQuery) (ResultSet, error) {
+	switch query.Operation {
+	case "CREATE":
+		return ResultSet{Operation: query.Operation}, db.createDatabase(query.Database)
+	case "USE":
+		return ResultSet{Operation: query.Operation}, db.useDatabase(query.Database)
+	case "SHOW":
+		return db.showCatalog(query.ShowTarget)
+	case "DESCRIBE":
+		return db.describeTable(query.TableName)
+	}
+
+	return ResultSet{}, fmt.Errorf("%v is not a catalog operation", query.Operation)
+}
+
+// Creates an empty database, with its own folder within stores
+func (db *DB) createDatabase(databaseName string) error {
+	return updateCatalog(func(catalog *Catalog) (bool, error) {
+		if catalog.database(databaseName) != nil {
+			return false, fmt.Errorf("a database already exists with the name: %v", databaseName)
+		}
+
+		mkdirErr := os.MkdirAll(databaseFolder(databaseName), 0755)
+		if mkdirErr != nil {
+			return false, fmt.Errorf("failed to create the folder for database %v: %w", databaseName, mkdirErr)
+		}
+
+		catalog.Databases = append(catalog.Databases, CatalogDatabase{Name: databaseName, Tables: []CatalogTable{}})
+		return true, nil
+	})
+}
+
+// Switches the database the queries are run against, saving and closing the tables of the current database first
+func (db *DB) useDatabase(databaseName string) error {
+	if db.tx != nil {
+		return fmt.Errorf("USE cannot be used within a transaction")
+	}
+
+	catalog, catalogErr := readCatalog()
+	if catalogErr != nil {
+		return catalogErr
+	}
+
+	if databaseName != defaultDatabase && catalog.database(databaseName) == nil {
+		return fmt.Errorf("no database was found with the name: %v", databaseName)
+	}
+
+	closeErr := db.Close()
+	if closeErr != nil {
+		return closeErr
+	}
+
+	db.Tables = []DBTable{}
+	db.Name = databaseName
+	return nil
+}
+
+// Lists the databases in the catalog, or the tables within the current database
+func (db *DB) showCatalog(target string) (ResultSet, error) {
+	catalog, catalogErr := readCatalog()
+	if catalogErr != nil {
+		return ResultSet{}, catalogErr
+	}
+
+	result := ResultSet{Operation: "SHOW", Rows: [][]any{}}
+
+	if target == "DATABASES" {
+		result.Columns = []ResultColumn{{Name: "Database", Type: "string"}, {Name: "Tables", Type: "int"}}
+		for _, database := range catalog.Databases {
+			result.Rows = append(result.Rows, []any{database.Name, len(database.Tables)})
+		}
+
+		return result, nil
+	}
+
+	result.Columns = []ResultColumn{{Name: "Table", Type: "string"}, {Name: "Columns", Type: "int"}, {Name: "PrimaryKey", Type: "string"}}
+	if database := catalog.database(db.databaseName()); database != nil {
+		for _, table := range database.Tables {
+			result.Rows = append(result.Rows, []any{table.Name, len(table.Columns), table.PrimaryKey})
+		}
+	}
+
+	return result, nil
+}
+
+// Lists the columns of a table along with their type, key and constraints
+func (db *DB) describeTable(tableName string) (ResultSet, error) {
+	loadTableErr := db.loadQueryTable(tableName)
+	if loadTableErr != nil {
+		return ResultSet{}, loadTableErr
+	}
+
+	tableIndex, tableErr := db.getTable(tableName)
+	if tableErr != nil {
+		return ResultSet{}, tableErr
+	}
+
+	table := db.Tables[tableIndex]
+	result := ResultSet{
+		Operation: "DESCRIBE",
+		Columns: []ResultColumn{
+			{Name: "Column", Type: "string"},
+			{Name: "Type", Type: "string"},
+			{Name: "Nullable", Type: "bool"},
+			{Name: "Key", Type: "string"},
+			{Name: "Default", Type: "string"},
+			{Name: "Check", Type: "string"},
+		},
+		Rows: [][]any{},
+	}
+
+	for _, config := range table.ColumnConfig {
+		keys := []string{}
+		if config.ColumnName == table.PrimaryKeyColumnName {
+			keys = append(keys, "PRIMARY")
+		}
+
+		if config.Unique {
+			keys = append(keys, "UNIQUE")
+		}
+
+		for _, foreignKey := range table.ForeignKeys {
+			if foreignKey.Column == config.ColumnName {
+				keys = append(keys, fmt.Sprintf("FOREIGN %v.%v", foreignKey.ReferencedTable, foreignKey.ReferencedColumn))
+			}
+		}
+
+		var columnDefault any
+		if config.ColumnName == table.PrimaryKeyColumnName && table.AutoIncrementPrimary {
+			columnDefault = "AUTO"
+		} else if config.Default != nil && config.Default.Function != "" {
+			columnDefault = config.Default.Function + "()"
+		} else if config.Default != nil {
+			columnDefault = fmt.Sprint(config.Default.Value)
+		}
+
+		var check any
+		if config.Check != "" {
+			check = config.Check
+		}
+
+		result.Rows = append(result.Rows, []any{config.ColumnName, config.ColumnType, config.Nullable, strings.Join(keys, ", "), columnDefault, check})
+	}
+
+	return result, nil
+}
@@ -6,15 +6,17 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
-	random "math/rand/v2"
+	"errors"
+	"io/fs"
 	"os"
-	"strings"
 	"log"
-	//"fmt"
+	"fmt"
+	"time"
 )
 
 // Encrypt the specified data with a specified key
-func encrpytData(key []byte, data []byte) ([]byte, error) {
+// ** The additional data isn't encrypted or stored, but the data can only be decrypted again with the same additional data
+func encrpytData(key []byte, data []byte, additionalData []byte) ([]byte, error) {
 	aes, err := aes.NewCipher(key)
     if err != nil {
         return nil, err
@@ -36,13 +38,13 @@ func encrpytData(key []byte, data []byte) ([]byte, error) {
     // ciphertext here is actually nonce+ciphertext
     // So that when we decrypt, just knowing the nonce size
     // is enough to separate it from the ciphertext.
-    ciphertext := gcm.Seal(nonce, nonce, []byte(data), nil)
+    ciphertext := gcm.Seal(nonce, nonce, []byte(data), additionalData)
 
     return ciphertext, nil
 }
 
 // Decrypt the specified data with a specified key
-func decryptData(key []byte, data []byte) ([]byte, error) {
+func decryptData(key []byte, data []byte, additionalData []byte) ([]byte, error) {
 	aes, err := aes.NewCipher(key)
     if err != nil {
         return nil, err
@@ -56,9 +58,12 @@ func decryptData(key []byte, data []byte) ([]byte, error) {
     // Since we know the ciphertext is actually nonce+ciphertext
     // And len(nonce) == NonceSize(). We can separate the two.
     nonceSize := gcm.NonceSize()
+    if len(data) < nonceSize {
+        return nil, fmt.Errorf("encrypted data is too short to hold a nonce")
+    }
     nonce, data := data[:nonceSize], data[nonceSize:]
 
-    plaintext, err := gcm.Open(nil, []byte(nonce), []byte(data), nil)
+    plaintext, err := gcm.Open(nil, []byte(nonce), []byte(data), additionalData)
     if err != nil {
         return nil, err
     }
@@ -67,58 +72,47 @@ func decryptData(key []byte, data []byte) ([]byte, error) {
 }
 
 // Either get an existing key or generate a new one
+// ** A key file from an older version, such as one holding just the key, is saved again in the current format the first time it's read
 func generateEncryptionKey(keyFilePath string) (error) {
 	content, err := os.ReadFile(keyFilePath)
-	if err != nil {
-		if strings.Contains(err.Error(), "The system cannot find the file") {
-			log.Println("System cannot find an existing key - creating a new one.")
-		} else {
-			return err
-		}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
 	}
 
-	if len(content) < 32 {
-		var letters = []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
-
-		// Handle opening / creating the new key file
-		file, err := os.OpenFile("keys/main.dat", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
-		if err != nil {
-			return err
-		}
-
-		// Generate a new byte key at random
-		b := make([]byte, 32)
-		for i := range b {
-			b[i] = letters[random.IntN(len(letters))]
-		}
-		newKey := string(b)
+	if len(content) == 0 {
+		log.Println("System cannot find an existing key - creating a new one.")
 
-		// Set the key to environment
-		setEnvErr := os.Setenv("EK", newKey)
-		if setEnvErr != nil {
-			return setEnvErr
+		newKey, newKeyErr := newEncryptionKey()
+		if newKeyErr != nil {
+			return newKeyErr
 		}
 
 		// Handle errors with writing the key to file
-		_, fileWriteErr := file.Write(b)
-		if fileWriteErr != nil {
-			return fileWriteErr
-		}
+		return writeKeyring(keyFilePath, Keyring{ActiveID: 1, Keys: []KeyringKey{{ID: 1, Key: newKey, Created: time.Now().UTC()}}})
+	}
 
-		defer file.Close()
-	} else {
-		// Set the key to environment
-		setEnvErr := os.Setenv("EK", string(content))
-		if setEnvErr != nil {
-			return setEnvErr
-		}
+	keyring, keyringErr := parseKeyring(content)
+	if keyringErr != nil {
+		return fmt.Errorf("failed to read the key file %v: %w", keyFilePath, keyringErr)
+	}
+
+	if keyring.Version < keyFileVersion {
+		return writeKeyring(keyFilePath, keyring)
 	}
 
 	return nil
 }
 
-// Function to rotate the encryption key, should be used periodicallys
-func rotateEncryptionKey(keyFilePath string) (error) {return nil}
+// Generate a new key for AES-256 from the secure random source
+func newEncryptionKey() ([]byte, error) {
+	key := make([]byte, encryptionKeySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate an encryption key: %w", err)
+	}
+
+	return key, nil
+}
 
 // Function to generate private key
 func generatePrivateKey() ([]byte, error) {
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+)
+
+// Marks a file sealed with its own data key, it is followed by the 8 byte generation of the file, the 4 byte length of the
+// wrapped data key and then the key itself
+var envelopeTag = []byte("UTE2")
+
+// Marks a file sealed with its own data key before the generation was added, it is followed by the 4 byte length of the wrapped
+// data key and then the key itself
+// ** The content of these files isn't bound to the file it belongs to, so they're saved again as soon as they're loaded
+var unboundEnvelopeTag = []byte("UTE1")
+
+// Version of the envelope written by sealEnvelope, bound to the content along with the file it belongs to
+const envelopeVersion = 2
+
+// The parts of a file sealed with its own data key
+type envelope struct {
+	version    int
+	generation uint64 // number of times the file has been saved, 0 for a version 1 envelope
+	wrappedKey []byte // the data key, sealed with the master key
+	body       []byte // the content, sealed with the data key
+}
+
+// The file sealed content belongs to, which the content is bound to when it's sealed so it can't be opened as another file
+// ** The generation goes up each time the file is saved, so an older copy of the file can be told apart from the current one
+type fileIdentity struct {
+	Database   string
+	Table      string
+	Version    int
+	Generation uint64
+	File       string `json:",omitempty"` // wal or idx for the log and indexes of the table, empty for its .dat file
+	Position   int    `json:",omitempty"` // wal only, the position of the record within the log
+}
+
+// Marks content sealed with a data key rather than the master key
+var dataKeyTag = []byte("UTD1")
+
+// A key belonging to a single table, which seals every file of the table and is kept in the header of its .dat file, wrapped
+// by the master key
+type dataKey []byte
+
+// Makes a new random data key
+func newDataKey() (dataKey, error) {
+	return newEncryptionKey()
+}
+
+// Encrypts content with the data key, tagging it so it isn't mistaken for content sealed with the master key
+func (key dataKey) seal(content []byte) ([]byte, error) {
+	return key.sealFor(content, nil)
+}
+
+// Encrypts content with the data key, binding it to the additional data given so it can only be opened along with it
+func (key dataKey) sealFor(content []byte, additionalData []byte) ([]byte, error) {
+	if key == nil {
+		return nil, fmt.Errorf("the content can't be sealed, as there is no data key")
+	}
+
+	encryptedContent, encryptErr := encrpytData(key, content, additionalData)
+	if encryptErr != nil {
+		return nil, encryptErr
+	}
+
+	return append(slices.Clip(dataKeyTag), encryptedContent...), nil
+}
+
+// Decrypts content sealed with the data key
+// ** Content sealed before the table had its own data key was sealed with the master key, so is opened with that instead
+func (key dataKey) open(content []byte) ([]byte, error) {
+	return key.openFor(content, nil)
+}
+
+// Decrypts content sealed with the data key and bound to the additional data given
+// ** Content bound to additional data is only ever opened with the data key, as content sealed with the master key isn't bound
+func (key dataKey) openFor(content []byte, additionalData []byte) ([]byte, error) {
+	if !bytes.HasPrefix(content, dataKeyTag) {
+		if additionalData != nil {
+			return nil, fmt.Errorf("the content wasn't sealed with its data key")
+		}
+
+		return openData(content)
+	}
+
+	if key == nil {
+		return nil, fmt.Errorf("the content was sealed with a data key that isn't available")
+	}
+
+	return decryptData(key, content[len(dataKeyTag):], additionalData)
+}
+
+// Seals content for one of the files of a table, bound to the file and the generation of the table it was written for
+func (key dataKey) sealForFile(content []byte, identity fileIdentity) ([]byte, error) {
+	identity.Version = envelopeVersion
+	return key.sealFor(content, identity.additionalData())
+}
+
+// Opens content sealed for one of the files of a table, returning true if it was sealed for the generation before the current one
+// ** The .dat file is saved before the indexes and log that go with it, so the process stopping part way through a checkpoint
+// leaves them bound to the generation before. Tables from before content was bound to them are generation 0, so content
+// written for them isn't bound to anything
+func (key dataKey) openForFile(content []byte, identity fileIdentity) ([]byte, bool, error) {
+	decryptedContent, openErr := key.openBound(content, identity)
+	if openErr == nil || identity.Generation == 0 {
+		return decryptedContent, false, openErr
+	}
+
+	identity.Generation = identity.Generation - 1
+	decryptedContent, previousErr := key.openBound(content, identity)
+	if previousErr != nil {
+		return nil, false, openErr
+	}
+
+	return decryptedContent, true, nil
+}
+
+// Opens content sealed for one of the files of a table at the generation given
+func (key dataKey) openBound(content []byte, identity fileIdentity) ([]byte, error) {
+	if identity.Generation == 0 {
+		return key.open(content)
+	}
+
+	identity.Version = envelopeVersion
+	return key.openFor(content, identity.additionalData())
+}
+
+// Returns the identity of the .dat file of a table, the generation is left for the caller to fill in
+func tableIdentity(databaseName string, tableName string) fileIdentity {
+	if databaseName == "" {
+		databaseName = defaultDatabase
+	}
+
+	return fileIdentity{Database: databaseName, Table: tableName}
+}
+
+// Returns the identity of the .dat file of the table at its current generation
+func (table *DBTable) identity() fileIdentity {
+	identity := tableIdentity(table.database, table.Name)
+	identity.Generation = table.generation
+	return identity
+}
+
+// Returns the identity of the .dat file of a system table, the generation is left for the caller to fill in
+// ** System tables belong to no database, so they can't be mistaken for the tables of a database called system
+func systemIdentity(tableName string) fileIdentity {
+	return fileIdentity{Table: tableName}
+}
+
+// Returns the name the file is known by in system/generations.dat, which is the path of the file without its extension
+func (identity fileIdentity) name() string {
+	if identity.Database == "" {
+		return "system/" + identity.Table
+	}
+
+	return databaseFolder(identity.Database) + "/" + identity.Table
+}
+
+// Returns the name the log of the table is known by in system/generations.dat
+func (identity fileIdentity) logName() string {
+	return identity.name() + ".wal"
+}
+
+// Returns the identity of a record in the log of the table, at its position within the log
+func (identity fileIdentity) logRecord(position int) fileIdentity {
+	identity.File = "wal"
+	identity.Position = position
+	return identity
+}
+
+// Returns the identity of the indexes of the table
+func (identity fileIdentity) indexes() fileIdentity {
+	identity.File = "idx"
+	return identity
+}
+
+// Returns the additional data content is bound to when sealed for the file
+func (identity fileIdentity) additionalData() []byte {
+	// ** The identity only holds strings and numbers, so it can always be converted
+	content, _ := json.Marshal(identity)
+	return content
+}
+
+// Seals content for a file with a data key, with the data key wrapped by the master key in a header in front of it
+// ** The content is bound to the file and its generation, which is kept in the header so it can be checked when the file is opened
+func sealEnvelope(key dataKey, identity fileIdentity, content []byte) ([]byte, error) {
+	keyring, keyringErr := masterKeyring()
+	if keyringErr != nil {
+		return nil, keyringErr
+	}
+
+	wrappedKey, wrapErr := keyring.seal(key)
+	if wrapErr != nil {
+		return nil, fmt.Errorf("failed to wrap the data key: %w", wrapErr)
+	}
+
+	identity.Version = envelopeVersion
+	body, sealErr := key.sealFor(content, identity.additionalData())
+	if sealErr != nil {
+		return nil, sealErr
+	}
+
+	return envelope{version: envelopeVersion, generation: identity.Generation, wrappedKey: wrappedKey, body: body}.bytes(), nil
+}
+
+// Returns the envelope as it's written to file, with the header holding the wrapped data key in front of the sealed content
+func (sealed envelope) bytes() []byte {
+	content := make([]byte, 0, envelopeHeaderSize(envelopeTag)+len(sealed.wrappedKey)+len(sealed.body))
+	if sealed.version == 1 {
+		content = append(content, unboundEnvelopeTag...)
+	} else {
+		content = append(content, envelopeTag...)
+		content = binary.BigEndian.AppendUint64(content, sealed.generation)
+	}
+
+	content = binary.BigEndian.AppendUint32(content, uint32(len(sealed.wrappedKey)))
+	content = append(content, sealed.wrappedKey...)
+
+	return append(content, sealed.body...)
+}
+
+// Returns the size of the header in front of the wrapped data key of an envelope, or 0 if the content isn't an envelope
+func envelopeHeaderSize(content []byte) int {
+	if bytes.HasPrefix(content, envelopeTag) {
+		return len(envelopeTag) + 8 + 4
+	} else if bytes.HasPrefix(content, unboundEnvelopeTag) {
+		return len(unboundEnvelopeTag) + 4
+	}
+
+	return 0
+}
+
+// Splits an envelope into its parts, returning false if the content isn't an envelope
+func splitEnvelope(content []byte) (envelope, bool, error) {
+	headerSize := envelopeHeaderSize(content)
+	if headerSize == 0 {
+		return envelope{}, false, nil
+	}
+
+	if len(content) < headerSize {
+		return envelope{}, true, fmt.Errorf("the header of the envelope is cut short")
+	}
+
+	sealed := envelope{version: 1}
+	if bytes.HasPrefix(content, envelopeTag) {
+		sealed.version = envelopeVersion
+		sealed.generation = binary.BigEndian.Uint64(content[len(envelopeTag):])
+	}
+
+	wrappedEnd := headerSize + int(binary.BigEndian.Uint32(content[headerSize-4:headerSize]))
+	if wrappedEnd > len(content) {
+		return envelope{}, true, fmt.Errorf("the data key of the envelope is cut short")
+	}
+
+	sealed.wrappedKey = content[headerSize:wrappedEnd]
+	sealed.body = content[wrappedEnd:]
+	return sealed, true, nil
+}
+
+// Opens the envelope of a file, returning the content along with its data key and generation
+// ** Files from before data keys were sealed with the master key alone, so are opened with it and have no data key. They, and
+// envelopes from before the generation was added, are given as generation 0 and are saved as the current version the next time
+// they're saved. The content of an envelope is only ever opened with its data key, otherwise the generation in its header
+// could be made up
+func openEnvelope(content []byte, identity fileIdentity) ([]byte, dataKey, uint64, error) {
+	sealed, isEnvelope, splitErr := splitEnvelope(content)
+	if splitErr != nil {
+		return nil, nil, 0, splitErr
+	} else if !isEnvelope {
+		decryptedContent, openErr := openData(content)
+		return decryptedContent, nil, 0, openErr
+	}
+
+	keyring, keyringErr := masterKeyring()
+	if keyringErr != nil {
+		return nil, nil, 0, keyringErr
+	}
+
+	key, unwrapErr := keyring.open(sealed.wrappedKey)
+	if unwrapErr != nil {
+		return nil, nil, 0, fmt.Errorf("failed to unwrap the data key: %w", unwrapErr)
+	}
+
+	if !bytes.HasPrefix(sealed.body, dataKeyTag) {
+		return nil, nil, 0, fmt.Errorf("%v could not be opened, its content wasn't sealed with its data key", identity.name())
+	}
+
+	var additionalData []byte
+	if sealed.version == envelopeVersion {
+		identity.Version = sealed.version
+		identity.Generation = sealed.generation
+		additionalData = identity.additionalData()
+	}
+
+	decryptedContent, openErr := dataKey(key).openFor(sealed.body, additionalData)
+	if openErr != nil {
+		return nil, nil, 0, fmt.Errorf("%v could not be opened, it has been changed or belongs to another file: %w", identity.name(), openErr)
+	}
+
+	return decryptedContent, key, sealed.generation, nil
+}
+
+// Wraps the data key of an envelope again with the active master key, leaving the sealed content as it is
+// ** Returns false if the data key was already wrapped with the active key
+func (keyring Keyring) rewrapEnvelope(content []byte) ([]byte, bool, error) {
+	sealed, _, splitErr := splitEnvelope(content)
+	if splitErr != nil {
+		return nil, false, splitErr
+	}
+
+	if keyID, _ := sealedKeyID(sealed.wrappedKey); keyID == keyring.ActiveID {
+		return content, false, nil
+	}
+
+	key, unwrapErr := keyring.open(sealed.wrappedKey)
+	if unwrapErr != nil {
+		return nil, false, fmt.Errorf("failed to unwrap the data key: %w", unwrapErr)
+	}
+
+	sealed.wrappedKey, unwrapErr = keyring.seal(key)
+	if unwrapErr != nil {
+		return nil, false, fmt.Errorf("failed to wrap the data key: %w", unwrapErr)
+	}
+
+	return sealed.bytes(), true, nil
+}
+
+// Destroys the data key in the header of a file in place, so the file, its logs and indexes can never be opened again
+// ** Copies of the file taken before it was shredded still hold the wrapped key, and can be opened for as long as the master key
+// that wrapped it is kept, rotating the master key afterwards shreds those as well
+func shredEnvelope(path string) error {
+	file, openErr := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(openErr, fs.ErrNotExist) {
+		return nil
+	} else if openErr != nil {
+		return openErr
+	}
+	defer file.Close()
+
+	// A file from before data keys has no key of its own to destroy
+	header := make([]byte, envelopeHeaderSize(envelopeTag))
+	readLength, _ := io.ReadFull(file, header)
+	headerSize := envelopeHeaderSize(header[:readLength])
+	if headerSize == 0 || readLength < headerSize {
+		return nil
+	}
+
+	noise := make([]byte, binary.BigEndian.Uint32(header[headerSize-4:headerSize]))
+	if _, randErr := rand.Read(noise); randErr != nil {
+		return randErr
+	}
+
+	if _, writeErr := file.WriteAt(noise, int64(headerSize)); writeErr != nil {
+		return fmt.Errorf("failed to destroy the data key of %v: %w", path, writeErr)
+	}
+
+	return file.Sync()
+}
+
+// Seals content in an envelope with its data key and writes it to file atomically
+func writeEnvelopeFile(path string, key dataKey, identity fileIdentity, content []byte) error {
+	sealed, sealErr := sealEnvelope(key, identity, content)
+	if sealErr != nil {
+		return fmt.Errorf("failed to encrypt %v: %w", path, sealErr)
+	}
+
+	return writeFileAtomic(path, sealed, 0755)
+}
+
+// Returns the data key the files of the table are sealed with, making one the first time the table is saved
+func (table *DBTable) sealingKey() (dataKey, error) {
+	if table.dataKey != nil {
+		return table.dataKey, nil
+	}
+
+	key, keyErr := newDataKey()
+	if keyErr != nil {
+		return nil, keyErr
+	}
+
+	table.dataKey = key
+	return key, nil
+}
+
+// Returns the data key a system table is sealed with, making one the first time the table is saved
+// ** A system table from before data keys is given its own key the next time the system database is saved
+func (s *SystemDB) sealingKey(tableName string) (dataKey, error) {
+	if key := s.dataKeys[tableName]; key != nil {
+		return key, nil
+	}
+
+	key, keyErr := newDataKey()
+	if keyErr != nil {
+		return nil, keyErr
+	}
+
+	if s.dataKeys == nil {
+		s.dataKeys = map[string]dataKey{}
+		s.generations = map[string]uint64{}
+	}
+
+	s.dataKeys[tableName] = key
+	return key, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// returns the data key the .dat file of a table was sealed with
+func storedDataKey(t *testing.T, tableName string) dataKey {
+	content, readErr := os.ReadFile(storePath(defaultDatabase, tableName, "dat"))
+	if readErr != nil {
+		t.Fatalf("unexpected error: %v", readErr)
+	}
+
+	if !bytes.HasPrefix(content, envelopeTag) {
+		t.Fatalf("table %v was not sealed with a data key", tableName)
+	}
+
+	_, key, _, openErr := openEnvelope(content, tableIdentity(defaultDatabase, tableName))
+	if openErr != nil {
+		t.Fatalf("unexpected error: %v", openErr)
+	}
+
+	return key
+}
+
+// test every table is sealed with a data key of its own, wrapped by the master key
+func Test_envelope(t *testing.T) {
+	useTestStore(t)
+
+	db := DB{}
+	for _, query := range []string{
+		"CREATE TABLE Orders (Order_ID int, Amount int, PRIMARY KEY Order_ID AUTO)",
+		"CREATE INDEX Orders_By_Amount ON Orders (Amount)",
+		"CREATE TABLE Refunds (Refund_ID int, Amount int, PRIMARY KEY Refund_ID AUTO)",
+		"PUSH Amount = 10 TO Orders",
+		"PUSH Amount = 20 TO Orders",
+	} {
+		if _, queryErr := db.runQuery(query); queryErr != nil {
+			t.Fatalf("unexpected error for %v: %v", query, queryErr)
+		}
+	}
+
+	// checks the rows of the Orders table can still be read
+	confirmReadable := func(t *testing.T) {
+		reloaded := DB{}
+		defer reloaded.Close()
+
+		result, queryErr := reloaded.runQuery("PULL Amount FROM Orders SORT BY Order_ID")
+		if queryErr != nil {
+			t.Fatalf("unexpected error: %v", queryErr)
+		}
+
+		if expectedRows := [][]any{{10}, {20}}; !reflect.DeepEqual(result.Rows, expectedRows) {
+			t.Fatalf("result was incorrect, got: %v, expected: %v", result.Rows, expectedRows)
+		}
+	}
+
+	t.Run("Test each table has its own data key", func(t *testing.T) {
+		ordersKey := storedDataKey(t, "Orders")
+		refundsKey := storedDataKey(t, "Refunds")
+		if len(ordersKey) != encryptionKeySize || bytes.Equal(ordersKey, refundsKey) {
+			t.Fatalf("data keys were incorrect, got: %v and %v", ordersKey, refundsKey)
+		}
+
+		walContent, _ := os.ReadFile(storePath(defaultDatabase, "Orders", "wal"))
+		frames, _ := splitLogFrames(walContent)
+		if len(frames) != 2 {
+			t.Fatalf("expected 2 records in the log, got: %v", len(frames))
+		}
+
+		for _, frame := range frames {
+			if !bytes.HasPrefix(frame, dataKeyTag) {
+				t.Fatalf("a record in the log was not sealed with the data key")
+			}
+		}
+
+		idxContent, _ := os.ReadFile(storePath(defaultDatabase, "Orders", "idx"))
+		if !bytes.HasPrefix(idxContent, dataKeyTag) {
+			t.Fatalf("the indexes were not sealed with the data key")
+		}
+
+		if closeErr := db.Close(); closeErr != nil {
+			t.Fatalf("unexpected error: %v", closeErr)
+		}
+
+		confirmReadable(t)
+	})
+
+	t.Run("Test a table sealed with the master key is given a data key", func(t *testing.T) {
+		path := storePath(defaultDatabase, "Orders", "dat")
+		content, _ := os.ReadFile(path)
+		decryptedContent, _, _, openErr := openEnvelope(content, tableIdentity(defaultDatabase, "Orders"))
+		if openErr != nil {
+			t.Fatalf("unexpected error: %v", openErr)
+		}
+
+		// a table from before data keys is also from before generations were saved
+		if writeErr := writeEncryptedFile(path, decryptedContent); writeErr != nil {
+			t.Fatalf("unexpected error: %v", writeErr)
+		}
+		os.Remove(storePath(defaultDatabase, "Orders", "idx"))
+		os.Remove(generationsPath)
+
+		confirmReadable(t)
+
+		if key := storedDataKey(t, "Orders"); len(key) != encryptionKeySize {
+			t.Fatalf("data key was incorrect, got: %v", key)
+		}
+	})
+
+	t.Run("Test rotating the master key only wraps the data key again", func(t *testing.T) {
+		before, _ := os.ReadFile(storePath(defaultDatabase, "Orders", "dat"))
+		if _, rotateErr := rotateEncryptionKey(&fileKeyProvider{path: keyPath}); rotateErr != nil {
+			t.Fatalf("unexpected error: %v", rotateErr)
+		}
+		after, _ := os.ReadFile(storePath(defaultDatabase, "Orders", "dat"))
+
+		beforeEnvelope, _, _ := splitEnvelope(before)
+		afterEnvelope, _, _ := splitEnvelope(after)
+		if bytes.Equal(beforeEnvelope.wrappedKey, afterEnvelope.wrappedKey) || !bytes.Equal(beforeEnvelope.body, afterEnvelope.body) {
+			t.Fatalf("only the wrapped data key should have changed")
+		}
+
+		confirmSealedWith(t, 2)
+		confirmReadable(t)
+	})
+
+	t.Run("Test a shredded table can't be opened", func(t *testing.T) {
+		path := storePath(defaultDatabase, "Orders", "dat")
+		backupPath := path + ".backup"
+		content, _ := os.ReadFile(path)
+		if writeErr := os.WriteFile(backupPath, content, 0755); writeErr != nil {
+			t.Fatalf("unexpected error: %v", writeErr)
+		}
+
+		if shredErr := shredEnvelope(backupPath); shredErr != nil {
+			t.Fatalf("unexpected error: %v", shredErr)
+		}
+
+		shredded, _ := os.ReadFile(backupPath)
+		if _, _, _, openErr := openEnvelope(shredded, tableIdentity(defaultDatabase, "Orders")); openErr == nil {
+			t.Fatalf("expected an error opening a shredded file")
+		}
+		os.Remove(backupPath)
+
+		dropDB := DB{}
+		if _, queryErr := dropDB.runQuery("DROP TABLE Refunds"); queryErr != nil {
+			t.Fatalf("unexpected error: %v", queryErr)
+		}
+
+		if _, statErr := os.Stat(storePath(defaultDatabase, "Refunds", "dat")); !errors.Is(statErr, fs.ErrNotExist) {
+			t.Fatalf("the store file of the dropped table was not removed")
+		}
+	})
+
+	t.Run("Test system tables have their own data keys", func(t *testing.T) {
+		systemDB := SystemDB{}
+		systemDB.createBasePolicies()
+		if saveErr := systemDB.saveSystemDB(); saveErr != nil {
+			t.Fatalf("unexpected error: %v", saveErr)
+		}
+
+		keys := [][]byte{}
+		for _, tableName := range []string{"users", "policies"} {
+			content, _ := os.ReadFile("system/" + tableName + ".dat")
+			_, key, _, openErr := openEnvelope(content, systemIdentity(tableName))
+			if openErr != nil || key == nil {
+				t.Fatalf("system table %v was not sealed with a data key: %v", tableName, openErr)
+			}
+
+			keys = append(keys, key)
+		}
+
+		if bytes.Equal(keys[0], keys[1]) {
+			t.Fatalf("system tables were sealed with the same data key")
+		}
+
+		loaded := SystemDB{}
+		if loadErr := loaded.loadSystemDB(); loadErr != nil {
+			t.Fatalf("unexpected error: %v", loadErr)
+		}
+		defer loaded.closeAuditLog()
+
+		if !reflect.DeepEqual(loaded.Policies, systemDB.Policies) {
+			t.Fatalf("result was incorrect, got: %v, expected: %v", loaded.Policies, systemDB.Policies)
+		}
+	})
+}
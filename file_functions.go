@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Writes content to a file so that it is either fully replaced or left untouched, even if the process crashes part way through
+// ** The content is written to a temp file next to the target, synced to disk and renamed over the target, then the directory is synced so the rename is kept
+func writeFileAtomic(path string, content []byte, perm os.FileMode) error {
+	directory := filepath.Dir(path)
+
+	tempFile, createErr := os.CreateTemp(directory, fmt.Sprintf(".%v.tmp-*", filepath.Base(path)))
+	if createErr != nil {
+		return fmt.Errorf("failed to create a temp file for %v: %w", path, createErr)
+	}
+	tempPath := tempFile.Name()
+
+	// Clean up the temp file if anything fails before it is renamed into place
+	renamed := false
+	defer func() {
+		if !renamed {
+			tempFile.Close()
+			os.Remove(tempPath)
+		}
+	}()
+
+	if _, writeErr := tempFile.Write(content); writeErr != nil {
+		return fmt.Errorf("failed to write %v: %w", path, writeErr)
+	}
+
+	if chmodErr := tempFile.Chmod(perm); chmodErr != nil && runtime.GOOS != "windows" {
+		return fmt.Errorf("failed to set the permissions of %v: %w", path, chmodErr)
+	}
+
+	if syncErr := tempFile.Sync(); syncErr != nil {
+		return fmt.Errorf("failed to sync %v to disk: %w", path, syncErr)
+	}
+
+	if closeErr := tempFile.Close(); closeErr != nil {
+		return fmt.Errorf("failed to close %v: %w", path, closeErr)
+	}
+
+	if renameErr := os.Rename(tempPath, path); renameErr != nil {
+		return fmt.Errorf("failed to move %v into place: %w", path, renameErr)
+	}
+	renamed = true
+
+	return syncDirectory(directory)
+}
+
+// Syncs a directory to disk, so that files created or renamed within it are kept after a crash
+// ** Windows can't sync a directory handle, renames there are already kept by the file system
+func syncDirectory(directory string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	dir, openErr := os.Open(directory)
+	if openErr != nil {
+		return fmt.Errorf("failed to open directory %v: %w", directory, openErr)
+	}
+
+	syncErr := dir.Sync()
+	closeErr := dir.Close()
+	if syncErr != nil {
+		return fmt.Errorf("failed to sync directory %v: %w", directory, syncErr)
+	}
+
+	return closeErr
+}
+
+// Encrypts content with the main encryption key and writes it to file atomically
+func writeEncryptedFile(path string, content []byte) error {
+	encryptedContent, encryptErr := sealData(content)
+	if encryptErr != nil {
+		return fmt.Errorf("failed to encrypt %v: %w", path, encryptErr)
+	}
+
+	return writeFileAtomic(path, encryptedContent, 0755)
+}
+
+// Removes a file and syncs its directory, so the removal is kept after a crash
+func removeFileDurably(path string) error {
+	removeErr := os.Remove(path)
+	if removeErr != nil {
+		return removeErr
+	}
+
+	return syncDirectory(filepath.Dir(path))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// test the writeFileAtomic function replaces files without leaving temp files behind
+func Test_writeFileAtomic(t *testing.T) {
+	directory := t.TempDir()
+	path := filepath.Join(directory, "table.dat")
+
+	testTemplates := []TestTemplate{
+		{
+			TestName:       "Test creating a new file",
+			Inputs:         map[string]any{"path": path, "content": "first"},
+			ExpectedOutput: "first",
+		},
+		{
+			TestName:       "Test replacing an existing file",
+			Inputs:         map[string]any{"path": path, "content": "second"},
+			ExpectedOutput: "second",
+		},
+		{
+			TestName:       "Test writing into a missing directory",
+			IsError:        true,
+			Inputs:         map[string]any{"path": filepath.Join(directory, "missing", "table.dat"), "content": "third"},
+			ExpectedOutput: "second",
+		},
+	}
+
+	for _, test := range testTemplates {
+		t.Run(test.TestName, func(t *testing.T) {
+			writeErr := writeFileAtomic(test.Inputs["path"].(string), []byte(test.Inputs["content"].(string)), 0600)
+			if test.IsError != (writeErr != nil) {
+				t.Fatalf("error result was incorrect, got: %v, expected an error: %v", writeErr, test.IsError)
+			}
+
+			// A failed write must leave the existing file as it was
+			content, readErr := os.ReadFile(path)
+			if readErr != nil {
+				t.Fatalf("unexpected error: %v", readErr)
+			}
+
+			if string(content) != test.ExpectedOutput.(string) {
+				t.Fatalf("result was incorrect, got: %v, expected: %v", string(content), test.ExpectedOutput)
+			}
+
+			entries, _ := os.ReadDir(directory)
+			for _, entry := range entries {
+				if entry.Name() != "table.dat" {
+					t.Fatalf("temp file was left behind: %v", entry.Name())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Most compiled patterns kept at once
+const patternCacheSize = 256
+
+// Compiled LIKE and regex patterns, so a pattern is only compiled once for all the rows it is checked against
+// ** Patterns come from queries, so there's no end to how many different ones there can be. Only the most recently used are kept
+var patternCache = newPatternCache(patternCacheSize)
+
+// Compiled patterns by their operator and pattern, dropping the least recently used once it is full
+type compiledPatterns struct {
+	lock    sync.Mutex
+	limit   int
+	order   *list.List // entries from the most to the least recently used
+	entries map[string]*list.Element
+}
+
+// A compiled pattern held in the cache, along with the key it is found by
+type cachedPattern struct {
+	key        string
+	expression *regexp.Regexp
+}
+
+// Makes an empty cache that holds up to limit patterns
+func newPatternCache(limit int) *compiledPatterns {
+	return &compiledPatterns{limit: limit, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+// Returns the compiled pattern for a key, marking it as the most recently used
+func (cache *compiledPatterns) load(key string) (*regexp.Regexp, bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	element, exists := cache.entries[key]
+	if !exists {
+		return nil, false
+	}
+
+	cache.order.MoveToFront(element)
+	return element.Value.(cachedPattern).expression, true
+}
+
+// Adds a compiled pattern, dropping the least recently used pattern if the cache is full
+func (cache *compiledPatterns) store(key string, expression *regexp.Regexp) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if element, exists := cache.entries[key]; exists {
+		cache.order.MoveToFront(element)
+		return
+	}
+
+	cache.entries[key] = cache.order.PushFront(cachedPattern{key: key, expression: expression})
+	if cache.order.Len() > cache.limit {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(cachedPattern).key)
+	}
+}
+
+// Checks a single comparison between two resolved values
+// ** Any comparison against null is false, IS NULL and IS NOT NULL should be used to check for null values
+func evaluateCondition(operator string, left any, right any) (bool, error) {
+	switch operator {
+	case "IS NULL":
+		return left == nil, nil
+	case "IS NOT NULL":
+		return left != nil, nil
+	}
+
+	if left == nil {
+		return false, nil
+	}
+
+	switch operator {
+	case "IN", "NOT IN":
+		values, isList := right.([]any)
+		if !isList {
+			return false, fmt.Errorf("%v requires a list of values", operator)
+		}
+
+		found := false
+		for _, value := range values {
+			if value != nil && valuesEqual(left, value) {
+				found = true
+				break
+			}
+		}
+
+		return found == (operator == "IN"), nil
+
+	case "BETWEEN", "NOT BETWEEN":
+		bounds, isList := right.([]any)
+		if !isList || len(bounds) != 2 {
+			return false, fmt.Errorf("%v requires a lower and upper bound", operator)
+		}
+
+		if bounds[0] == nil || bounds[1] == nil {
+			return false, nil
+		}
+
+		lower, lowerErr := compareValues(left, bounds[0])
+		if lowerErr != nil {
+			return false, lowerErr
+		}
+
+		upper, upperErr := compareValues(left, bounds[1])
+		if upperErr != nil {
+			return false, upperErr
+		}
+
+		return (lower >= 0 && upper <= 0) == (operator == "BETWEEN"), nil
+	}
+
+	if right == nil {
+		return false, nil
+	}
+
+	switch operator {
+	case "=":
+		return valuesEqual(left, right), nil
+	case "!=":
+		return !valuesEqual(left, right), nil
+
+	case "<", "<=", ">", ">=":
+		comparison, compareErr := compareValues(left, right)
+		if compareErr != nil {
+			return false, compareErr
+		}
+
+		switch operator {
+		case "<":
+			return comparison < 0, nil
+		case "<=":
+			return comparison <= 0, nil
+		case ">":
+			return comparison > 0, nil
+		default:
+			return comparison >= 0, nil
+		}
+
+	case "LIKE", "NOT LIKE", "~":
+		pattern, isString := right.(string)
+		if !isString {
+			return false, fmt.Errorf("the pattern for %v must be a string, got: %v", operator, right)
+		}
+
+		expression, patternErr := compilePattern(operator, pattern)
+		if patternErr != nil {
+			return false, patternErr
+		}
+
+		return expression.MatchString(valueToString(left)) == (operator != "NOT LIKE"), nil
+	}
+
+	return false, fmt.Errorf("invalid operator was supplied to filter table rows: %v", operator)
+}
+
+// Orders two values, returning -1, 0 or 1 in the same way as strings.Compare
+func compareValues(left any, right any) (int, error) {
+	leftNumber, leftIsNumber := numericValue(left)
+	rightNumber, rightIsNumber := numericValue(right)
+	if leftIsNumber && rightIsNumber {
+		switch {
+		case leftNumber < rightNumber:
+			return -1, nil
+		case leftNumber > rightNumber:
+			return 1, nil
+		}
+
+		return 0, nil
+	}
+
+	switch l := left.(type) {
+	case string:
+		if r, ok := right.(string); ok {
+			return strings.Compare(l, r), nil
+		}
+	case []byte:
+		if r, ok := right.([]byte); ok {
+			return strings.Compare(string(l), string(r)), nil
+		}
+	case time.Time:
+		if r, ok := right.(time.Time); ok {
+			return l.Compare(r), nil
+		}
+	case bool:
+		if r, ok := right.(bool); ok {
+			switch {
+			case l == r:
+				return 0, nil
+			case r:
+				return -1, nil
+			}
+
+			return 1, nil
+		}
+	}
+
+	return 0, fmt.Errorf("cannot compare %v (%T) with %v (%T)", left, left, right, right)
+}
+
+// Compiles a LIKE pattern or regular expression, LIKE uses % for any run of characters and _ for a single one
+func compilePattern(operator string, pattern string) (*regexp.Regexp, error) {
+	cacheKey := operator + "\x00" + pattern
+	if cached, exists := patternCache.load(cacheKey); exists {
+		return cached, nil
+	}
+
+	expressionString := pattern
+	if operator != "~" {
+		var builder strings.Builder
+		builder.WriteString("^")
+
+		for _, r := range pattern {
+			switch r {
+			case '%':
+				builder.WriteString(".*")
+			case '_':
+				builder.WriteString(".")
+			default:
+				builder.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+
+		builder.WriteString("$")
+		expressionString = "(?s)" + builder.String()
+	}
+
+	expression, compileErr := regexp.Compile(expressionString)
+	if compileErr != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, compileErr)
+	}
+
+	patternCache.store(cacheKey, expression)
+	return expression, nil
+}
+
+// Converts a stored value into the string used for pattern matching and terminal output
+func valueToString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+
+	return fmt.Sprintf("%v", value)
+}
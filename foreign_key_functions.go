@@ -0,0 +1,419 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Row changes made to the tables referencing a table when rows are deleted from it
+type deletePlan struct {
+	deletes map[string][]int
+	nulls   []nullChange
+}
+
+// Rows of a table that have a foreign key column set to null by ON DELETE SET NULL
+type nullChange struct {
+	table  string
+	column string
+	rows   []int
+}
+
+// Returns the table a foreign key references, it has to already be loaded into the database
+func (table *DBTable) referencedTable(foreignKey ForeignKey) (*DBTable, error) {
+	return table.relatedTable(foreignKey.ReferencedTable)
+}
+
+// Returns a table in the same database as the table, it has to already be loaded
+// ** Loading a table here would move the tables in memory, leaving the table this was called on out of date
+func (table *DBTable) relatedTable(tableName string) (*DBTable, error) {
+	if table.db == nil {
+		return nil, fmt.Errorf("table %v is not attached to a database, so table %v can't be found", table.Name, tableName)
+	}
+
+	tableIndex, tableErr := table.db.getTable(tableName)
+	if tableErr != nil {
+		return nil, tableErr
+	}
+
+	return &table.db.Tables[tableIndex], nil
+}
+
+// Finds the rows holding a value in a column, through an index when the column has one
+func (table *DBTable) rowsWithValue(columnName string, value any) []int {
+	if rows, isIndexed := table.equalRows(columnName, []any{value}); isIndexed {
+		return rows
+	}
+
+	if config, _ := table.getColumnConfig(columnName); config.Unique {
+		return table.uniqueIndex(columnName)[indexKey(value)]
+	}
+
+	rows := []int{}
+	for rowIndex, row := range table.RowValues {
+		if row.ColumnValues[columnName] != nil && indexKey(row.ColumnValues[columnName]) == indexKey(value) {
+			rows = append(rows, rowIndex)
+		}
+	}
+
+	return rows
+}
+
+// Loads the tables a table references and every table referencing it, directly or through other tables, so they are in
+// memory before any rows are changed
+// ** A delete can cascade down any number of tables, so the tables referencing each referencing table are loaded too
+func (db *DB) loadRelatedTables(tableName string) error {
+	tableIndex, tableErr := db.getTable(tableName)
+	if tableErr != nil {
+		return tableErr
+	}
+
+	for _, foreignKey := range db.Tables[tableIndex].ForeignKeys {
+		loadTableErr := db.loadQueryTable(foreignKey.ReferencedTable)
+		if loadTableErr != nil {
+			return loadTableErr
+		}
+	}
+
+	visitedTables := map[string]bool{tableName: true}
+	pendingTables := []string{tableName}
+	for len(pendingTables) > 0 {
+		// Loading a table can move the tables in memory, so each table is found again by name
+		parentIndex, parentErr := db.getTable(pendingTables[0])
+		if parentErr != nil {
+			return parentErr
+		}
+		pendingTables = pendingTables[1:]
+
+		for _, childName := range slices.Clone(db.Tables[parentIndex].ReferencedBy) {
+			if visitedTables[childName] {
+				continue
+			}
+			visitedTables[childName] = true
+
+			loadTableErr := db.loadQueryTable(childName)
+			if loadTableErr != nil {
+				return loadTableErr
+			}
+
+			pendingTables = append(pendingTables, childName)
+		}
+	}
+
+	return nil
+}
+
+// Checks the foreign key values set on a row can be found in the tables they reference
+// ** Null values don't reference anything, so are always allowed
+func (table *DBTable) confirmForeignKeys(changedValues map[string]any) error {
+	for _, foreignKey := range table.ForeignKeys {
+		value := changedValues[foreignKey.Column]
+		if value == nil {
+			continue
+		}
+
+		parent, parentErr := table.referencedTable(foreignKey)
+		if parentErr != nil {
+			return parentErr
+		}
+
+		if len(parent.rowsWithValue(foreignKey.ReferencedColumn, value)) == 0 {
+			return fmt.Errorf("no row exists in table %v with %v = %v, as referenced by column %v", foreignKey.ReferencedTable, foreignKey.ReferencedColumn, value, foreignKey.Column)
+		}
+	}
+
+	return nil
+}
+
+// Checks a PUT doesn't change a value that rows in other tables still reference
+func (table *DBTable) confirmReferencesKept(rowIndexes []int, updatedValues map[string]any) error {
+	for _, childName := range table.ReferencedBy {
+		child, childErr := table.relatedTable(childName)
+		if childErr != nil {
+			return childErr
+		}
+
+		for _, foreignKey := range child.ForeignKeys {
+			newValue, isChanged := updatedValues[foreignKey.ReferencedColumn]
+			if foreignKey.ReferencedTable != table.Name || !isChanged {
+				continue
+			}
+
+			for _, rowIndex := range rowIndexes {
+				oldValue := table.RowValues[rowIndex].ColumnValues[foreignKey.ReferencedColumn]
+				if oldValue == nil || (newValue != nil && indexKey(oldValue) == indexKey(newValue)) {
+					continue
+				}
+
+				if len(child.rowsWithValue(foreignKey.Column, oldValue)) > 0 {
+					return fmt.Errorf("rows in table %v reference %v = %v through column %v, so it can't be changed", childName, foreignKey.ReferencedColumn, oldValue, foreignKey.Column)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Works out the changes a delete makes to the tables referencing the rows being deleted, following ON DELETE CASCADE
+// through each table it reaches
+// ** Nothing is changed until the whole plan is known, so a RESTRICT found part way through leaves every table as it was
+func (table *DBTable) planDelete(rowIndexes []int) (deletePlan, error) {
+	plan := deletePlan{deletes: map[string][]int{table.Name: rowIndexes}, nulls: []nullChange{}}
+
+	type pendingDelete struct {
+		table *DBTable
+		rows  []int
+	}
+
+	pending := []pendingDelete{{table: table, rows: rowIndexes}}
+	for len(pending) > 0 {
+		parent := pending[0]
+		pending = pending[1:]
+
+		for _, childName := range parent.table.ReferencedBy {
+			child, childErr := table.relatedTable(childName)
+			if childErr != nil {
+				return deletePlan{}, childErr
+			}
+
+			for _, foreignKey := range child.ForeignKeys {
+				if foreignKey.ReferencedTable != parent.table.Name {
+					continue
+				}
+
+				// Rows already being deleted don't need to be checked again
+				childRows := []int{}
+				for _, rowIndex := range parent.rows {
+					value := parent.table.RowValues[rowIndex].ColumnValues[foreignKey.ReferencedColumn]
+					if value == nil {
+						continue
+					}
+
+					for _, childRow := range child.rowsWithValue(foreignKey.Column, value) {
+						if !slices.Contains(plan.deletes[childName], childRow) && !slices.Contains(childRows, childRow) {
+							childRows = append(childRows, childRow)
+						}
+					}
+				}
+
+				if len(childRows) == 0 {
+					continue
+				}
+
+				switch foreignKey.OnDelete {
+				case "CASCADE":
+					plan.deletes[childName] = append(plan.deletes[childName], childRows...)
+					pending = append(pending, pendingDelete{table: child, rows: childRows})
+				case "SET NULL":
+					plan.nulls = append(plan.nulls, nullChange{table: childName, column: foreignKey.Column, rows: childRows})
+				default:
+					return deletePlan{}, fmt.Errorf("rows in table %v reference the rows being deleted from table %v through column %v", childName, parent.table.Name, foreignKey.Column)
+				}
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+// Deletes rows from a table along with the changes to the tables referencing them
+// ** When other tables are changed and no transaction is in progress, one is started for the delete so either every table is
+// changed or none are
+func (db *DB) deleteWithReferences(tableName string, rowIndexes []int) error {
+	tableIndex, tableErr := db.getTable(tableName)
+	if tableErr != nil {
+		return tableErr
+	}
+
+	plan, planErr := db.Tables[tableIndex].planDelete(rowIndexes)
+	if planErr != nil {
+		return planErr
+	}
+
+	startsTx := db.tx == nil && (len(plan.deletes) > 1 || len(plan.nulls) > 0)
+	if startsTx {
+		if _, beginErr := db.Begin(); beginErr != nil {
+			return beginErr
+		}
+	}
+
+	applyErr := db.applyDeletePlan(plan)
+	if !startsTx {
+		return applyErr
+	}
+
+	if applyErr != nil {
+		return errors.Join(applyErr, db.tx.Rollback())
+	}
+
+	return db.tx.Commit()
+}
+
+// Makes the row changes of a delete plan, setting columns to null before any rows are removed so the row positions still match
+func (db *DB) applyDeletePlan(plan deletePlan) error {
+	for _, change := range plan.nulls {
+		tableIndex, tableErr := db.getTable(change.table)
+		if tableErr != nil {
+			return tableErr
+		}
+
+		// Rows that are also being deleted are left as they are
+		rows := slices.DeleteFunc(slices.Clone(change.rows), func(rowIndex int) bool { return slices.Contains(plan.deletes[change.table], rowIndex) })
+		if len(rows) == 0 {
+			continue
+		}
+
+		table := &db.Tables[tableIndex]
+		if db.tx != nil {
+			db.tx.track(table)
+		}
+
+		commitErr := table.commitChange(walRecord{Operation: "PUT", Rows: rows, Values: map[string]any{change.column: nil}})
+		if commitErr != nil {
+			return commitErr
+		}
+	}
+
+	// Tables are changed in name order, so the changes are always logged the same way
+	tableNames := []string{}
+	for tableName := range plan.deletes {
+		tableNames = append(tableNames, tableName)
+	}
+	slices.Sort(tableNames)
+
+	for _, tableName := range tableNames {
+		tableIndex, tableErr := db.getTable(tableName)
+		if tableErr != nil {
+			return tableErr
+		}
+
+		table := &db.Tables[tableIndex]
+		if db.tx != nil {
+			db.tx.track(table)
+		}
+
+		rows := slices.Clone(plan.deletes[tableName])
+		slices.Sort(rows)
+		commitErr := table.commitChange(walRecord{Operation: "DELETE", Rows: rows})
+		if commitErr != nil {
+			return commitErr
+		}
+	}
+
+	return nil
+}
+
+// Checks the foreign keys of a new table against the tables they reference, then adds the table to their ReferencedBy
+func (db *DB) addReferences(table *DBTable) error {
+	for _, foreignKey := range table.ForeignKeys {
+		if foreignKey.ReferencedTable == table.Name {
+			continue
+		}
+
+		loadTableErr := db.loadQueryTable(foreignKey.ReferencedTable)
+		if loadTableErr != nil {
+			return loadTableErr
+		}
+	}
+
+	parents := []*DBTable{}
+	for _, foreignKey := range table.ForeignKeys {
+		parent := table
+		if foreignKey.ReferencedTable != table.Name {
+			tableIndex, tableErr := db.getTable(foreignKey.ReferencedTable)
+			if tableErr != nil {
+				return tableErr
+			}
+
+			parent = &db.Tables[tableIndex]
+		}
+
+		config, _ := table.getColumnConfig(foreignKey.Column)
+		referencedConfig, columnErr := parent.getColumnConfig(foreignKey.ReferencedColumn)
+		if columnErr != nil {
+			return fmt.Errorf("FOREIGN KEY column %v references column %v, which isn't in table %v", foreignKey.Column, foreignKey.ReferencedColumn, parent.Name)
+		}
+
+		if referencedConfig.ColumnName != parent.PrimaryKeyColumnName && !referencedConfig.Unique {
+			return fmt.Errorf("FOREIGN KEY column %v has to reference the primary key or a UNIQUE column of table %v", foreignKey.Column, parent.Name)
+		}
+
+		if config.ColumnType != referencedConfig.ColumnType {
+			return fmt.Errorf("FOREIGN KEY column %v is a %v column, but %v.%v is a %v column", foreignKey.Column, config.ColumnType, parent.Name, referencedConfig.ColumnName, referencedConfig.ColumnType)
+		}
+
+		if !slices.Contains(parents, parent) {
+			parents = append(parents, parent)
+		}
+	}
+
+	for _, parent := range parents {
+		if slices.Contains(parent.ReferencedBy, table.Name) {
+			continue
+		}
+
+		parent.ReferencedBy = append(parent.ReferencedBy, table.Name)
+		if parent == table {
+			continue
+		}
+
+		checkpointErr := parent.checkpoint()
+		if checkpointErr != nil {
+			return checkpointErr
+		}
+	}
+
+	return nil
+}
+
+// Removes a dropped table from the ReferencedBy of the tables it referenced, they have to already be loaded
+func (db *DB) removeReferences(tableName string, foreignKeys []ForeignKey) error {
+	for _, foreignKey := range foreignKeys {
+		tableIndex, tableErr := db.getTable(foreignKey.ReferencedTable)
+		if tableErr != nil {
+			continue
+		}
+
+		parent := &db.Tables[tableIndex]
+		if !slices.Contains(parent.ReferencedBy, tableName) {
+			continue
+		}
+
+		parent.ReferencedBy = slices.DeleteFunc(parent.ReferencedBy, func(name string) bool { return name == tableName })
+		checkpointErr := parent.checkpoint()
+		if checkpointErr != nil {
+			return checkpointErr
+		}
+	}
+
+	return nil
+}
+
+// Checks a column isn't referenced by a foreign key before it is dropped or renamed, or used as a foreign key when dropping
+func (table *DBTable) confirmColumnNotReferenced(columnName string, isDrop bool) error {
+	for _, childName := range table.ReferencedBy {
+		child, childErr := table.relatedTable(childName)
+		if childName == table.Name {
+			child, childErr = table, nil
+		}
+
+		if childErr != nil {
+			return childErr
+		}
+
+		for _, foreignKey := range child.ForeignKeys {
+			if foreignKey.ReferencedTable == table.Name && foreignKey.ReferencedColumn == columnName {
+				return fmt.Errorf("column %v is referenced by a foreign key in table %v", columnName, childName)
+			}
+		}
+	}
+
+	for _, foreignKey := range table.ForeignKeys {
+		if isDrop && foreignKey.Column == columnName {
+			return fmt.Errorf("column %v cannot be dropped, as it is a foreign key to table %v", columnName, foreignKey.ReferencedTable)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// The generation each table and system table was last saved at, along with the last change in the log of each table when
+// the log was last closed, sealed with the master key
+// ** It's kept apart from the stores, so a table can't be rolled back along with it by anyone who can only write to the stores.
+// Rolling back the system folder as a whole can't be detected
+const generationsPath = "system/generations.dat"
+
+// Held while the generations are read and saved again, so tables saved at the same time don't lose each other's generation
+var generationsLock sync.Mutex
+
+// Reads the generation of every file from system/generations.dat, which is empty until the first file is saved
+func readGenerations() (map[string]uint64, error) {
+	generations := map[string]uint64{}
+
+	content, readErr := os.ReadFile(generationsPath)
+	if errors.Is(readErr, fs.ErrNotExist) {
+		return generations, nil
+	} else if readErr != nil {
+		return nil, readErr
+	}
+
+	decryptedContent, decryptErr := openData(content)
+	if decryptErr != nil {
+		return nil, fmt.Errorf("failed to read the generations: %w", decryptErr)
+	}
+
+	unmarshalErr := json.Unmarshal(decryptedContent, &generations)
+	if unmarshalErr != nil {
+		return nil, fmt.Errorf("failed to read the generations: %w", unmarshalErr)
+	}
+
+	return generations, nil
+}
+
+// Saves the generation each file has just been saved at, a file with a generation of 0 is removed
+func recordGenerations(identities ...fileIdentity) error {
+	return updateGenerations(func(generations map[string]uint64) {
+		for _, identity := range identities {
+			setGeneration(generations, identity.name(), identity.Generation)
+		}
+	})
+}
+
+// Saves the sequence of the last change written to the log of a table, it is never moved back to an earlier change
+func recordLogSequence(identity fileIdentity, sequence int) error {
+	return updateGenerations(func(generations map[string]uint64) {
+		if uint64(sequence) > generations[identity.logName()] {
+			setGeneration(generations, identity.logName(), uint64(sequence))
+		}
+	})
+}
+
+// Removes the sequence saved for the log of a table, for when the table is dropped or made again
+func clearLogSequence(identity fileIdentity) error {
+	return updateGenerations(func(generations map[string]uint64) {
+		setGeneration(generations, identity.logName(), 0)
+	})
+}
+
+// Sets the generation of a file, removing it when the generation is 0
+func setGeneration(generations map[string]uint64, name string, generation uint64) {
+	if generation == 0 {
+		delete(generations, name)
+	} else {
+		generations[name] = generation
+	}
+}
+
+// Reads the generations, changes them and saves them again
+func updateGenerations(change func(generations map[string]uint64)) error {
+	generationsLock.Lock()
+	defer generationsLock.Unlock()
+
+	generations, readErr := readGenerations()
+	if readErr != nil {
+		return readErr
+	}
+
+	change(generations)
+
+	content, marshalErr := json.Marshal(generations)
+	if marshalErr != nil {
+		return marshalErr
+	}
+
+	writeErr := writeEncryptedFile(generationsPath, content)
+	if writeErr != nil {
+		return fmt.Errorf("failed to save the generations: %w", writeErr)
+	}
+
+	return nil
+}
+
+// Checks a file isn't older than the last generation saved, which would mean it has been replaced with an older copy
+// ** A newer generation is allowed, as the process can stop after a file is saved but before its generation is
+func confirmGeneration(identity fileIdentity) error {
+	generations, readErr := readGenerations()
+	if readErr != nil {
+		return readErr
+	}
+
+	savedGeneration := generations[identity.name()]
+	if identity.Generation >= savedGeneration {
+		return nil
+	}
+
+	if identity.Generation == 0 {
+		return fmt.Errorf("%v was saved in an older format than generation %v, it has been replaced with an older copy", identity.name(), savedGeneration)
+	}
+
+	return fmt.Errorf("%v is generation %v but generation %v was last saved, it has been replaced with an older copy", identity.name(), identity.Generation, savedGeneration)
+}
+
+// Checks the log of a table still holds every change it held when it was last closed, once replayed over the .dat file
+// ** Whole records dropped from the end of the log can't be told apart from a log that was never written to otherwise
+func confirmLogSequence(identity fileIdentity, lastSequence int) error {
+	generations, readErr := readGenerations()
+	if readErr != nil {
+		return readErr
+	}
+
+	if savedSequence := generations[identity.logName()]; uint64(lastSequence) < savedSequence {
+		return fmt.Errorf("the log for table %v ends at change %v but change %v was written, it has been cut short", identity.Table, lastSequence, savedSequence)
+	}
+
+	return nil
+}
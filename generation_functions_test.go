@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// copies a file, for putting an older or different copy of a table in place
+func copyFile(t *testing.T, source string, destination string) {
+	content, readErr := os.ReadFile(source)
+	if readErr != nil {
+		t.Fatalf("unexpected error: %v", readErr)
+	}
+
+	if writeErr := os.WriteFile(destination, content, 0755); writeErr != nil {
+		t.Fatalf("unexpected error: %v", writeErr)
+	}
+}
+
+// runs queries and closes the logs without saving the tables, so the changes are only held in the logs
+func runLoggedQueries(t *testing.T, queries ...string) {
+	db := DB{}
+	for _, query := range queries {
+		if _, queryErr := db.runQuery(query); queryErr != nil {
+			t.Fatalf("unexpected error for %v: %v", query, queryErr)
+		}
+	}
+
+	for tableIndex := range db.Tables {
+		if closeErr := db.Tables[tableIndex].closeLog(); closeErr != nil {
+			t.Fatalf("unexpected error: %v", closeErr)
+		}
+	}
+}
+
+// test a table can't be loaded from the .dat file of another table, or from an older copy of its own
+func Test_tableGenerations(t *testing.T) {
+	ordersPath := storePath(defaultDatabase, "Orders", "dat")
+
+	testTemplates := []TestTemplate{
+		{
+			TestName: "Test unchanged table",
+			Inputs:   map[string]any{"tamper": func(t *testing.T) {}},
+		},
+		{
+			TestName: "Test table swapped with another",
+			IsError:  true,
+			Inputs: map[string]any{"tamper": func(t *testing.T) {
+				copyFile(t, storePath(defaultDatabase, "Refunds", "dat"), ordersPath)
+			}},
+			ExpectedOutput: "stores/Orders could not be opened, it has been changed or belongs to another file",
+		},
+		{
+			TestName: "Test table rolled back",
+			IsError:  true,
+			Inputs: map[string]any{"tamper": func(t *testing.T) {
+				copyFile(t, ordersPath, "Orders.backup")
+
+				db := DB{}
+				if _, queryErr := db.runQuery("PUSH Amount = 30 TO Orders"); queryErr != nil {
+					t.Fatalf("unexpected error: %v", queryErr)
+				}
+				db.Close()
+
+				copyFile(t, "Orders.backup", ordersPath)
+			}},
+			ExpectedOutput: "stores/Orders is generation 2 but generation 3 was last saved, it has been replaced with an older copy",
+		},
+		{
+			TestName: "Test generation changed",
+			IsError:  true,
+			Inputs: map[string]any{"tamper": func(t *testing.T) {
+				content, _ := os.ReadFile(ordersPath)
+				content[len(envelopeTag)+7]++
+				os.WriteFile(ordersPath, content, 0755)
+			}},
+			ExpectedOutput: "stores/Orders could not be opened, it has been changed or belongs to another file",
+		},
+		{
+			TestName: "Test table replaced with one from before generations",
+			IsError:  true,
+			Inputs: map[string]any{"tamper": func(t *testing.T) {
+				content, _ := os.ReadFile(ordersPath)
+				decryptedContent, _, _, _ := openEnvelope(content, tableIdentity(defaultDatabase, "Orders"))
+				writeEncryptedFile(ordersPath, decryptedContent)
+			}},
+			ExpectedOutput: "stores/Orders was saved in an older format than generation 2, it has been replaced with an older copy",
+		},
+		{
+			TestName: "Test envelope made up around content sealed with the master key",
+			IsError:  true,
+			Inputs: map[string]any{"tamper": func(t *testing.T) {
+				content, _ := os.ReadFile(ordersPath)
+				sealed, _, _ := splitEnvelope(content)
+
+				rows := `{"Name":"Orders","RowValues":[{"Order_ID":999,"Amount":999}]}`
+				sealed.generation = 1 << 40
+				sealed.body, _ = sealData([]byte(rows))
+				os.WriteFile(ordersPath, sealed.bytes(), 0755)
+			}},
+			ExpectedOutput: "stores/Orders could not be opened, its content wasn't sealed with its data key",
+		},
+		{
+			TestName: "Test log swapped with another",
+			IsError:  true,
+			Inputs: map[string]any{"tamper": func(t *testing.T) {
+				runLoggedQueries(t, "PUSH Amount = 10 TO Refunds")
+				copyFile(t, storePath(defaultDatabase, "Refunds", "wal"), storePath(defaultDatabase, "Orders", "wal"))
+			}},
+			ExpectedOutput: "failed to read the log for table Orders: record 1 could not be decrypted",
+		},
+		{
+			TestName: "Test log records reordered",
+			IsError:  true,
+			Inputs: map[string]any{"tamper": func(t *testing.T) {
+				runLoggedQueries(t, "PUSH Amount = 20 TO Orders", "PUSH Amount = 30 TO Orders")
+
+				walPath := storePath(defaultDatabase, "Orders", "wal")
+				content, _ := os.ReadFile(walPath)
+				frames, _ := splitLogFrames(content)
+				os.WriteFile(walPath, append(logFrame(frames[1]), logFrame(frames[0])...), 0755)
+			}},
+			ExpectedOutput: "failed to read the log for table Orders: record 1 could not be decrypted",
+		},
+		{
+			TestName: "Test log left behind by a save that stopped before emptying it",
+			Inputs: map[string]any{"tamper": func(t *testing.T) {
+				walPath := storePath(defaultDatabase, "Orders", "wal")
+				runLoggedQueries(t, "PUSH Amount = 20 TO Orders")
+				copyFile(t, walPath, "Orders.backup")
+
+				db := DB{}
+				if _, queryErr := db.runQuery("PULL Amount FROM Orders"); queryErr != nil {
+					t.Fatalf("unexpected error: %v", queryErr)
+				}
+				db.Close()
+
+				copyFile(t, "Orders.backup", walPath)
+			}},
+		},
+		{
+			TestName: "Test log cut short",
+			IsError:  true,
+			Inputs: map[string]any{"tamper": func(t *testing.T) {
+				walPath := storePath(defaultDatabase, "Orders", "wal")
+				copyFile(t, walPath, "Orders.backup")
+				runLoggedQueries(t, "PUSH Amount = 20 TO Orders")
+				copyFile(t, "Orders.backup", walPath)
+			}},
+			ExpectedOutput: "the log for table Orders ends at change 1 but change 2 was written, it has been cut short",
+		},
+		{
+			TestName: "Test indexes swapped with another",
+			IsError:  true,
+			Inputs: map[string]any{"tamper": func(t *testing.T) {
+				runLoggedQueries(t, "CREATE INDEX Orders_By_Amount ON Orders (Amount)", "CREATE INDEX Refunds_By_Amount ON Refunds (Amount)")
+				copyFile(t, storePath(defaultDatabase, "Refunds", "idx"), storePath(defaultDatabase, "Orders", "idx"))
+			}},
+			ExpectedOutput: "failed to read the indexes for table Orders: ",
+		},
+	}
+
+	for _, test := range testTemplates {
+		t.Run(test.TestName, func(t *testing.T) {
+			useTestStore(t)
+
+			db := DB{}
+			for _, query := range []string{
+				"CREATE TABLE Orders (Order_ID int, Amount int, PRIMARY KEY Order_ID AUTO)",
+				"CREATE TABLE Refunds (Refund_ID int, Amount int, PRIMARY KEY Refund_ID AUTO)",
+				"PUSH Amount = 10 TO Orders",
+			} {
+				if _, queryErr := db.runQuery(query); queryErr != nil {
+					t.Fatalf("unexpected error for %v: %v", query, queryErr)
+				}
+			}
+			db.Close()
+
+			test.Inputs["tamper"].(func(t *testing.T))(t)
+
+			reloaded := DB{}
+			defer reloaded.Close()
+
+			loadErr := reloaded.loadTable("Orders")
+			if test.IsError {
+				if loadErr == nil || !strings.HasPrefix(loadErr.Error(), test.ExpectedOutput.(string)) {
+					t.Fatalf("error result was incorrect, got: %v, expected: %v", loadErr, test.ExpectedOutput)
+				}
+				return
+			}
+
+			if loadErr != nil {
+				t.Fatalf("unexpected error: %v", loadErr)
+			}
+		})
+	}
+
+	t.Run("Test writing a change only saves the generations once the log is closed", func(t *testing.T) {
+		useTestStore(t)
+
+		db := DB{}
+		if _, queryErr := db.runQuery("CREATE TABLE Orders (Order_ID int, Amount int, PRIMARY KEY Order_ID AUTO)"); queryErr != nil {
+			t.Fatalf("unexpected error: %v", queryErr)
+		}
+
+		before, _ := os.ReadFile(generationsPath)
+		for _, query := range []string{"PUSH Amount = 10 TO Orders", "PUT Amount = 20 TO Orders WHERE Order_ID = 1"} {
+			if _, queryErr := db.runQuery(query); queryErr != nil {
+				t.Fatalf("unexpected error for %v: %v", query, queryErr)
+			}
+		}
+
+		if after, _ := os.ReadFile(generationsPath); !bytes.Equal(before, after) {
+			t.Fatalf("the generations were saved again for a change to the log")
+		}
+
+		tableIndex, _ := db.getTable("Orders")
+		if closeErr := db.Tables[tableIndex].closeLog(); closeErr != nil {
+			t.Fatalf("unexpected error: %v", closeErr)
+		}
+
+		generations, _ := readGenerations()
+		if sequence := generations[tableIdentity(defaultDatabase, "Orders").logName()]; sequence != 2 {
+			t.Fatalf("result was incorrect, got: %v, expected: 2", sequence)
+		}
+	})
+
+	t.Run("Test a dropped table can be created again", func(t *testing.T) {
+		useTestStore(t)
+
+		db := DB{}
+		defer db.Close()
+
+		for _, query := range []string{
+			"CREATE TABLE Orders (Order_ID int, Amount int, PRIMARY KEY Order_ID AUTO)",
+			"PUSH Amount = 10 TO Orders",
+			"DROP TABLE Orders",
+			"CREATE TABLE Orders (Order_ID int, Amount int, PRIMARY KEY Order_ID AUTO)",
+		} {
+			if _, queryErr := db.runQuery(query); queryErr != nil {
+				t.Fatalf("unexpected error for %v: %v", query, queryErr)
+			}
+		}
+
+		generations, _ := readGenerations()
+		if generation := generations[tableIdentity(defaultDatabase, "Orders").name()]; generation != 1 {
+			t.Fatalf("result was incorrect, got: %v, expected: 1", generation)
+		}
+	})
+}
+
+// test the system tables can't be swapped, rolled back or removed
+func Test_systemGenerations(t *testing.T) {
+	testTemplates := []TestTemplate{
+		{
+			TestName: "Test system table swapped with another",
+			IsError:  true,
+			Inputs: map[string]any{"tamper": func(t *testing.T) {
+				copyFile(t, "system/roles.dat", "system/users.dat")
+			}},
+			ExpectedOutput: "failed to read system table users: system/users could not be opened, it has been changed or belongs to another file",
+		},
+		{
+			TestName: "Test system table rolled back",
+			IsError:  true,
+			Inputs: map[string]any{"tamper": func(t *testing.T) {
+				copyFile(t, "system/users.dat", "users.backup")
+
+				systemDB := SystemDB{}
+				if loadErr := systemDB.loadSystemDB(); loadErr != nil {
+					t.Fatalf("unexpected error: %v", loadErr)
+				}
+				systemDB.close()
+
+				copyFile(t, "users.backup", "system/users.dat")
+			}},
+			ExpectedOutput: "failed to read system table users: system/users is generation 1 but generation 2 was last saved, it has been replaced with an older copy",
+		},
+		{
+			TestName: "Test system table removed",
+			IsError:  true,
+			Inputs: map[string]any{"tamper": func(t *testing.T) {
+				os.Remove("system/users.dat")
+			}},
+			ExpectedOutput: "system table users is missing, but generation 1 was saved",
+		},
+	}
+
+	for _, test := range testTemplates {
+		t.Run(test.TestName, func(t *testing.T) {
+			useTestStore(t)
+
+			systemDB := SystemDB{}
+			if loadErr := systemDB.loadSystemDB(); loadErr != nil {
+				t.Fatalf("unexpected error: %v", loadErr)
+			}
+			systemDB.closeAuditLog()
+
+			test.Inputs["tamper"].(func(t *testing.T))(t)
+
+			reloaded := SystemDB{}
+			loadErr := reloaded.loadSystemDB()
+			defer reloaded.closeAuditLog()
+
+			if loadErr == nil || !strings.HasPrefix(loadErr.Error(), test.ExpectedOutput.(string)) {
+				t.Fatalf("error result was incorrect, got: %v, expected: %v", loadErr, test.ExpectedOutput)
+			}
+		})
+	}
+}
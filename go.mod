@@ -1,3 +1,7 @@
 module untold
 
 go 1.22.2
+
+require golang.org/x/term v0.20.0
+
+require golang.org/x/sys v0.20.0 // indirect
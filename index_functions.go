@@ -0,0 +1,541 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"time"
+)
+
+// Positions of the rows holding each value of a column
+// ** Tables saved before primary keys were checked can hold the same key more than once, so each value holds a list of rows
+type keyIndex map[any][]int
+
+// An index added to a table with CREATE INDEX
+// ** The entries are cleared when rows are deleted, as every row after them moves, and are rebuilt the next time the index is used
+type tableIndex struct {
+	Name    string
+	Column  string
+	Kind    string    // HASH or ORDERED
+	hash    keyIndex  // entries of a HASH index, nil until built
+	ordered *skipList // entries of an ORDERED index, nil until built
+}
+
+// The indexes of a table, as saved in the .idx file next to its snapshot
+// ** Entries are only reused when the table is at the same sequence it was when they were saved, otherwise they are rebuilt
+type indexFile struct {
+	Sequence int
+	Indexes  []indexSnapshot
+}
+
+type indexSnapshot struct {
+	Name    string
+	Column  string
+	Kind    string
+	Entries []indexEntry
+}
+
+// A value held in an index, along with the position of every row holding it
+type indexEntry struct {
+	Value any
+	Rows  []int
+}
+
+// Operators that give the same comparison when the two sides are swapped, e.g. 5 < Amount is the same as Amount > 5
+var swappedOperators = map[string]string{"=": "=", "<": ">", "<=": ">=", ">": "<", ">=": "<="}
+
+// Converts a value into a key that can be used in a map, values that are equal give the same key
+func indexKey(value any) any {
+	switch typed := value.(type) {
+	case []byte:
+		return string(typed)
+	case time.Time:
+		// ** UTC drops the location and monotonic reading, so the same instant always gives the same key
+		return typed.UTC()
+	}
+
+	return value
+}
+
+// Adds a row to the index under the value it holds, null values aren't indexed
+func (index keyIndex) add(value any, rowIndex int) {
+	if value == nil {
+		return
+	}
+
+	key := indexKey(value)
+	index[key] = append(index[key], rowIndex)
+}
+
+// Removes a row from the index under the value it held
+func (index keyIndex) remove(value any, rowIndex int) {
+	if value == nil {
+		return
+	}
+
+	key := indexKey(value)
+	index[key] = slices.DeleteFunc(index[key], func(indexedRow int) bool { return indexedRow == rowIndex })
+	if len(index[key]) == 0 {
+		delete(index, key)
+	}
+}
+
+// Rebuilds the primary key index from the rows of the table
+func (table *DBTable) buildPrimaryIndex() {
+	table.primaryIndex = keyIndex{}
+	for rowIndex, row := range table.RowValues {
+		table.primaryIndex.add(row.ColumnValues[table.PrimaryKeyColumnName], rowIndex)
+	}
+}
+
+// Returns the primary key index of the table, building it first if it has been cleared
+func (table *DBTable) primaryKeyIndex() keyIndex {
+	if table.primaryIndex == nil {
+		table.buildPrimaryIndex()
+	}
+
+	return table.primaryIndex
+}
+
+// Returns an error if another row already holds the primary key value, skipping the row at the position given
+func (table *DBTable) confirmPrimaryKeyFree(value any, skipRow int) error {
+	for _, rowIndex := range table.primaryKeyIndex()[indexKey(value)] {
+		if rowIndex != skipRow {
+			return fmt.Errorf("a row already exists in table %v with the primary key %v = %v", table.Name, table.PrimaryKeyColumnName, value)
+		}
+	}
+
+	return nil
+}
+
+// Checks if the entries of the index have been built
+func (index *tableIndex) isBuilt() bool {
+	return index.hash != nil || index.ordered != nil
+}
+
+// Clears the entries of the index, so they are rebuilt the next time it is used
+func (index *tableIndex) clear() {
+	index.hash = nil
+	index.ordered = nil
+}
+
+// Adds a row to a built index under the value it holds, null values aren't indexed
+func (index *tableIndex) add(value any, rowIndex int) {
+	if value == nil || !index.isBuilt() {
+		return
+	}
+
+	if index.Kind == "ORDERED" {
+		index.ordered.insert(value, rowIndex)
+	} else {
+		index.hash.add(value, rowIndex)
+	}
+}
+
+// Removes a row from a built index under the value it held
+func (index *tableIndex) remove(value any, rowIndex int) {
+	if value == nil || !index.isBuilt() {
+		return
+	}
+
+	if index.Kind == "ORDERED" {
+		index.ordered.remove(value, rowIndex)
+	} else {
+		index.hash.remove(value, rowIndex)
+	}
+}
+
+// Returns the index, building its entries from the rows of the table first if they have been cleared
+func (table *DBTable) builtIndex(index *tableIndex) *tableIndex {
+	if index.isBuilt() {
+		return index
+	}
+
+	if index.Kind == "ORDERED" {
+		index.ordered = newSkipList()
+	} else {
+		index.hash = keyIndex{}
+	}
+
+	for rowIndex, row := range table.RowValues {
+		index.add(row.ColumnValues[index.Column], rowIndex)
+	}
+
+	return index
+}
+
+// Finds an index on the column, ORDERED indexes are only returned when ordered is true
+// ** A HASH index is preferred when both kinds exist on the column, as its lookups don't need to search
+func (table *DBTable) findIndex(columnName string, ordered bool) *tableIndex {
+	var found *tableIndex
+	for _, index := range table.indexes {
+		if index.Column != columnName || (ordered && index.Kind != "ORDERED") {
+			continue
+		}
+
+		if found == nil || index.Kind == "HASH" {
+			found = index
+		}
+	}
+
+	return found
+}
+
+// Returns every value held by an index along with its rows, in value order for ORDERED indexes
+func (table *DBTable) indexEntries(index *tableIndex) []indexEntry {
+	table.builtIndex(index)
+
+	if index.Kind == "ORDERED" {
+		return index.ordered.entries()
+	}
+
+	entries := []indexEntry{}
+	for _, rows := range index.hash {
+		// The value is taken from a row rather than the map key, as the key of a []byte or time value isn't its stored form
+		entries = append(entries, indexEntry{Value: table.RowValues[rows[0]].ColumnValues[index.Column], Rows: slices.Clone(rows)})
+	}
+
+	return entries
+}
+
+// Adds an index on a column of the table, building its entries straight away
+func (table *DBTable) createIndex(definition IndexDefinition) error {
+	if slices.ContainsFunc(table.indexes, func(index *tableIndex) bool { return index.Name == definition.Name }) {
+		return fmt.Errorf("an index already exists on table %v with the name: %v", table.Name, definition.Name)
+	}
+
+	config, columnErr := table.getColumnConfig(definition.Column)
+	if columnErr != nil {
+		return columnErr
+	}
+
+	if definition.Kind != "HASH" && definition.Kind != "ORDERED" {
+		return fmt.Errorf("%v is not a supported index kind, expected one of HASH or ORDERED", definition.Kind)
+	}
+
+	index := &tableIndex{Name: definition.Name, Column: config.ColumnName, Kind: definition.Kind}
+	table.indexes = append(table.indexes, table.builtIndex(index))
+
+	return nil
+}
+
+// Removes an index from the table by its name
+func (table *DBTable) dropIndex(indexName string) error {
+	indexPosition := slices.IndexFunc(table.indexes, func(index *tableIndex) bool { return index.Name == indexName })
+	if indexPosition == -1 {
+		return fmt.Errorf("no index was found on table %v with the name: %v", table.Name, indexName)
+	}
+
+	table.indexes = slices.Delete(table.indexes, indexPosition, indexPosition+1)
+	return nil
+}
+
+// Copies the indexes of the table without their entries, so the copy builds its own from its rows
+func (table *DBTable) copyIndexes() []*tableIndex {
+	copied := []*tableIndex{}
+	for _, index := range table.indexes {
+		copied = append(copied, &tableIndex{Name: index.Name, Column: index.Column, Kind: index.Kind})
+	}
+
+	return copied
+}
+
+// Saves the indexes of the table to its .idx file, removing the file once the table has no indexes
+func (table *DBTable) saveIndexes() error {
+	path := storePath(table.database, table.Name, "idx")
+
+	if len(table.indexes) == 0 {
+		removeErr := os.Remove(path)
+		if removeErr != nil && !errors.Is(removeErr, fs.ErrNotExist) {
+			return removeErr
+		}
+
+		return nil
+	}
+
+	file := indexFile{Sequence: table.LastSequence, Indexes: []indexSnapshot{}}
+	for _, index := range table.indexes {
+		file.Indexes = append(file.Indexes, indexSnapshot{Name: index.Name, Column: index.Column, Kind: index.Kind, Entries: table.indexEntries(index)})
+	}
+
+	content, marshalErr := json.Marshal(file)
+	if marshalErr != nil {
+		return marshalErr
+	}
+
+	sealedContent, sealErr := table.dataKey.sealForFile(content, table.identity().indexes())
+	if sealErr != nil {
+		return fmt.Errorf("failed to encrypt %v: %w", path, sealErr)
+	}
+
+	return writeFileAtomic(path, sealedContent, 0755)
+}
+
+// Loads the indexes of the table from its .idx file, reusing the saved entries if the table hasn't changed since they were saved
+// ** An index on a column that no longer exists is skipped, which can happen if the process stopped part way through an ALTER TABLE
+func (table *DBTable) loadIndexes() error {
+	content, readErr := os.ReadFile(storePath(table.database, table.Name, "idx"))
+	if errors.Is(readErr, fs.ErrNotExist) {
+		return nil
+	} else if readErr != nil {
+		return readErr
+	}
+
+	// ** Indexes left from the generation before are still used, as their entries are rebuilt when the table has changed since
+	decryptedContent, _, decryptErr := table.dataKey.openForFile(content, table.identity().indexes())
+	if decryptErr != nil {
+		return fmt.Errorf("failed to read the indexes for table %v: %w", table.Name, decryptErr)
+	}
+
+	file := indexFile{}
+	unmarshalErr := json.Unmarshal(decryptedContent, &file)
+	if unmarshalErr != nil {
+		return fmt.Errorf("failed to read the indexes for table %v: %w", table.Name, unmarshalErr)
+	}
+
+	table.indexes = []*tableIndex{}
+	for _, snapshot := range file.Indexes {
+		config, columnErr := table.getColumnConfig(snapshot.Column)
+		if columnErr != nil {
+			continue
+		}
+
+		index := &tableIndex{Name: snapshot.Name, Column: config.ColumnName, Kind: snapshot.Kind}
+		table.indexes = append(table.indexes, index)
+
+		if file.Sequence == table.LastSequence {
+			table.restoreIndexEntries(index, config, snapshot.Entries)
+		}
+	}
+
+	return nil
+}
+
+// Fills an index from its saved entries, leaving it to be rebuilt if any entry doesn't fit the table
+func (table *DBTable) restoreIndexEntries(index *tableIndex, config ColumnConfig, entries []indexEntry) {
+	if index.Kind == "ORDERED" {
+		index.ordered = newSkipList()
+	} else {
+		index.hash = keyIndex{}
+	}
+
+	for _, entry := range entries {
+		value, valueErr := normaliseStoredValue(entry.Value, config.ColumnType)
+		if valueErr != nil || value == nil {
+			index.clear()
+			return
+		}
+
+		for _, rowIndex := range entry.Rows {
+			if rowIndex < 0 || rowIndex >= len(table.RowValues) {
+				index.clear()
+				return
+			}
+
+			index.add(value, rowIndex)
+		}
+	}
+}
+
+// Returns the positions of the rows that need checking against the where clause, in the order they are held in the table
+// ** An index is used when the where clause can only match rows with certain values in an indexed column, otherwise every row is checked
+func (table *DBTable) candidateRowIndexes(where WhereExpr) []int {
+	if rows, isIndexed := table.indexedCandidates(where); isIndexed {
+		candidates := slices.Clone(rows)
+		slices.Sort(candidates)
+
+		return slices.Compact(candidates)
+	}
+
+	candidates := make([]int, len(table.RowValues))
+	for rowIndex := range candidates {
+		candidates[rowIndex] = rowIndex
+	}
+
+	return candidates
+}
+
+// Finds the rows that can match the where clause through an index, returning false if no index can narrow them down
+// ** Only a single comparison can use an index, either on its own or on one side of an AND
+func (table *DBTable) indexedCandidates(where WhereExpr) ([]int, bool) {
+	switch expr := where.(type) {
+	case *LogicalCondition:
+		if expr.Operator != "AND" {
+			return nil, false
+		}
+
+		if rows, isIndexed := table.indexedCandidates(expr.Left); isIndexed {
+			return rows, true
+		}
+
+		return table.indexedCandidates(expr.Right)
+
+	case *Condition:
+		return table.conditionCandidates(expr)
+	}
+
+	return nil, false
+}
+
+// Finds the rows that can match a single comparison between a column and literal values through an index
+func (table *DBTable) conditionCandidates(condition *Condition) ([]int, bool) {
+	operator := condition.Operator
+	column, operands := condition.Left, condition.Values
+
+	if operands == nil {
+		operands = []Operand{condition.Right}
+
+		// The column can be on either side of a comparison between two values
+		if _, isColumn := table.operandColumn(column); !isColumn {
+			swapped, canSwap := swappedOperators[operator]
+			if !canSwap {
+				return nil, false
+			}
+
+			operator = swapped
+			column, operands = condition.Right, []Operand{condition.Left}
+		}
+	}
+
+	config, isColumn := table.operandColumn(column)
+	if !isColumn {
+		return nil, false
+	}
+
+	values := []any{}
+	for _, operand := range operands {
+		value, isLiteral := table.operandLiteral(operand, config)
+		if !isLiteral {
+			return nil, false
+		}
+
+		values = append(values, value)
+	}
+
+	switch operator {
+	case "=", "IN":
+		return table.equalRows(config.ColumnName, values)
+	case "<":
+		return table.rangeRows(config.ColumnName, nil, &indexBound{value: values[0]})
+	case "<=":
+		return table.rangeRows(config.ColumnName, nil, &indexBound{value: values[0], inclusive: true})
+	case ">":
+		return table.rangeRows(config.ColumnName, &indexBound{value: values[0]}, nil)
+	case ">=":
+		return table.rangeRows(config.ColumnName, &indexBound{value: values[0], inclusive: true}, nil)
+	case "BETWEEN":
+		return table.rangeRows(config.ColumnName, &indexBound{value: values[0], inclusive: true}, &indexBound{value: values[1], inclusive: true})
+	}
+
+	return nil, false
+}
+
+// Returns the config of the column an operand refers to, false if it isn't a column of the table
+func (table *DBTable) operandColumn(operand Operand) (ColumnConfig, bool) {
+	if !operand.isIdentifier() {
+		return ColumnConfig{}, false
+	}
+
+	config, columnErr := table.getColumnConfig(operand.Text)
+	return config, columnErr == nil
+}
+
+// Returns the literal value of an operand converted to the type of the column, false if it isn't a literal that can be converted
+func (table *DBTable) operandLiteral(operand Operand, config ColumnConfig) (any, bool) {
+	value, valueType, resolveErr := table.resolveArgument(RowValue{}, operand.argumentValue())
+	if resolveErr != nil || valueType != "" || value == nil {
+		return nil, false
+	}
+
+	typedValue, typeErr := coerceValue(value, config.ColumnType)
+	if typeErr != nil {
+		return nil, false
+	}
+
+	return typedValue, true
+}
+
+// Finds the rows holding any of the values in a column through the primary key or a secondary index
+func (table *DBTable) equalRows(columnName string, values []any) ([]int, bool) {
+	rows := []int{}
+
+	if columnName == table.PrimaryKeyColumnName {
+		for _, value := range values {
+			rows = append(rows, table.primaryKeyIndex()[indexKey(value)]...)
+		}
+
+		return rows, true
+	}
+
+	index := table.findIndex(columnName, false)
+	if index == nil {
+		return nil, false
+	}
+
+	table.builtIndex(index)
+	for _, value := range values {
+		if index.Kind == "ORDERED" {
+			rows = append(rows, index.ordered.rowsBetween(&indexBound{value: value, inclusive: true}, &indexBound{value: value, inclusive: true})...)
+		} else {
+			rows = append(rows, index.hash[indexKey(value)]...)
+		}
+	}
+
+	return rows, true
+}
+
+// Finds the rows holding a value between the bounds of a column through an ORDERED index
+func (table *DBTable) rangeRows(columnName string, lower *indexBound, upper *indexBound) ([]int, bool) {
+	index := table.findIndex(columnName, true)
+	if index == nil {
+		return nil, false
+	}
+
+	return table.builtIndex(index).ordered.rowsBetween(lower, upper), true
+}
+
+// Returns the position of every row in the order given by SORT BY, using an ORDERED index on the column
+// ** Only a single sort column can use an index, null values come first in the same way as sortRows
+func (table *DBTable) indexedSortOrder(sortItems []SortItem) ([]int, bool) {
+	if len(sortItems) != 1 {
+		return nil, false
+	}
+
+	config, columnErr := table.getColumnConfig(sortItems[0].Column)
+	if columnErr != nil {
+		return nil, false
+	}
+
+	index := table.findIndex(config.ColumnName, true)
+	if index == nil {
+		return nil, false
+	}
+
+	nullRows := []int{}
+	for rowIndex, row := range table.RowValues {
+		if row.ColumnValues[config.ColumnName] == nil {
+			nullRows = append(nullRows, rowIndex)
+		}
+	}
+
+	entries := table.indexEntries(index)
+	if !sortItems[0].Descending {
+		order := nullRows
+		for _, entry := range entries {
+			order = append(order, entry.Rows...)
+		}
+
+		return order, true
+	}
+
+	// Rows holding the same value keep their table order when sorted in reverse, as sortRows is a stable sort
+	order := []int{}
+	for entryIndex := len(entries) - 1; entryIndex >= 0; entryIndex-- {
+		order = append(order, entries[entryIndex].Rows...)
+	}
+
+	return append(order, nullRows...), true
+}
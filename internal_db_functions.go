@@ -2,10 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
+	"maps"
 	"os"
 	"reflect"
+	"slices"
 	"strings"
 )
 
@@ -13,24 +17,56 @@ import (
 const keyPath = "keys/main.dat"
 
 type DB struct {
-	Name string
+	Name string							// the database the tables are stored in, the default database when empty
 	Tables []DBTable
+	System *SystemDB					// when set, every query is checked against the permissions of User
+	User PublicAccessUser
+	tx *Tx								// the transaction in progress, if there is one
+	actor string						// User, once confirmQueryAccess has confirmed their auth token for the query being run
 }
 
 type DBTable struct {
 	Name string
 	ColumnConfig []ColumnConfig
 	PrimaryKeyColumnName string
-	ForeignKeyColumnName *string
+	ForeignKeys []ForeignKey `json:",omitempty"`
+	ReferencedBy []string `json:",omitempty"`		// names of the tables with a foreign key to this table
 	AutoIncrementPrimary bool
 	NextID int
 	RowValues []RowValue
+	LastSequence int					// sequence of the last logged change included in the table
+	wal *tableLog						// nil until the table has been saved to or loaded from file
+	tx *Tx								// set while the table has changes held by a transaction
+	primaryIndex keyIndex				// rows by primary key, nil until it is built
+	indexes []*tableIndex				// indexes added with CREATE INDEX, saved next to the table in a .idx file
+	uniqueIndexes map[string]keyIndex	// rows by the value of each UNIQUE column, built as each one is needed
+	db *DB								// the database the table is attached to, used to find the tables its foreign keys use
+	database string						// name of the database the table is stored in
+	dataKey dataKey						// the key the files of the table are sealed with, nil until the table is first saved
+	generation uint64					// number of times the table has been saved, so an older copy of its .dat file isn't loaded
 }
 
 type ColumnConfig struct {
 	ColumnName string
 	ColumnType string
 	Nullable bool
+	Unique bool `json:",omitempty"`
+	Default *ColumnDefault `json:",omitempty"`		// value given to the column when a PUSH leaves it out
+	Check string `json:",omitempty"`				// expression every value has to pass, written the same way as a WHERE clause
+}
+
+// A column holding values of a column in another table, OnDelete is what happens to the rows when the row they reference is deleted
+type ForeignKey struct {
+	Column string
+	ReferencedTable string
+	ReferencedColumn string
+	OnDelete string						// RESTRICT, CASCADE or SET NULL
+}
+
+// The value given to a column left out of a PUSH, either a fixed value or one of the functions now() and uuid()
+type ColumnDefault struct {
+	Function string `json:",omitempty"`
+	Value any `json:",omitempty"`
 }
 
 // This could probably be removed, actually
@@ -40,91 +76,179 @@ type RowValue struct {
 
 type DBQuery struct {
 	TableName string					// `json:"tableName"`
+	TableAlias string
+	Joins []JoinClause
 	ColumnNames []string				// `json:"columnNames"`
 	Operation string					// `json:"operation"`
-	ArgumentClause []map[string]any 	// `json:"arugmentClause"`
+	WhereClause WhereExpr				// nil when the query has no WHERE clause
 	OptionsClause map[string]any
+	SortClause []SortItem
+	Limit *int
+	Offset *int
+	Aggregates []AggregateCall			// every aggregate used in the column list, HAVING and SORT BY
+	GroupBy []string
+	HavingClause WhereExpr
+	Definition *TableDefinition			// only set for CREATE and ALTER
+	Index *IndexDefinition				// only set for CREATE INDEX and DROP INDEX
+	Database string						// only set for CREATE DATABASE and USE
+	ShowTarget string					// DATABASES or TABLES, only set for SHOW
 }
 
-// This should be used for the Argument Clause of a query
-type ArgumentClause struct {
-	Left string
-	Operator string
-	Right string
+// The table layout given to CREATE TABLE, or the single column change made by ALTER TABLE
+type TableDefinition struct {
+	Columns []ColumnConfig
+	PrimaryKey string
+	AutoIncrement bool
+	ForeignKeys []ForeignKey
+	AlterAction string					// ADD, DROP or RENAME
+	NewColumnName string
+}
+
+// The index given to CREATE INDEX, only the name is set for DROP INDEX
+type IndexDefinition struct {
+	Name string
+	Column string
+	Kind string							// HASH or ORDERED
 }
 
 // Wraps up the database, saves it to file and wipes the memory
-func (db *DB) Close() {
-	db.saveTables()
+// ** Saving would write the uncommitted changes of a transaction to file, so the transaction has to be finished first
+func (db *DB) Close() (error) {
+	if db.tx != nil {
+		return fmt.Errorf("the database cannot be closed while a transaction is in progress, commit or roll it back first")
+	}
+
+	saveErr := db.saveTables()
+
+	closeErrs := []error{saveErr}
+	for index := range db.Tables {
+		closeErrs = append(closeErrs, db.Tables[index].closeLog())
+	}
+
 	db = nil
+
+	return errors.Join(closeErrs...)
 }
 
 // Create a table within a DB
 func (db *DB) attachTable(table DBTable) {
 	// ** Enter some validation in here maybe
+	table.db = db
+	table.database = db.databaseName()
 	db.Tables = append(db.Tables, table)
 }
 
-// Save the DB tables to .DAT
-func (db *DB) saveTables() {
-	for _, value := range db.Tables {
-		content, err := json.Marshal(value)
-		if err != nil {
-			fmt.Println(err)
-		}
+// Save the DB tables to .DAT, emptying their write-ahead logs
+// ** Each table is still saved if another one fails, every failure is returned together
+func (db *DB) saveTables() (error) {
+	saveErrs := []error{}
 
-		file, err := os.OpenFile(fmt.Sprintf("stores/%v.dat", value.Name), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
-		if err != nil {
-			fmt.Println(err)
+	for index := range db.Tables {
+		checkpointErr := db.Tables[index].checkpoint()
+		if checkpointErr != nil {
+			saveErrs = append(saveErrs, checkpointErr)
 		}
+	}
 
-		ekerr := generateEncryptionKey(keyPath)
-		if ekerr != nil {
-			fmt.Println(ekerr)
-			return
-		}
+	return errors.Join(saveErrs...)
+}
 
-		encryptedContent, EncryptErr := encrpytData([]byte(os.Getenv("EK")), content)
-		if EncryptErr != nil {
-			fmt.Println(EncryptErr)
-			return
-		}
+// Load table to DB from .DAT
+// ** This could probably be improved to only load specific data as needed, or allow for concurrency
+func (db *DB) loadTable(tableName string) (error) {
+	// The table is already in memory, loading it again would hide any changes made since
+	if _, existingErr := db.getTable(tableName); existingErr == nil {
+		return nil
+	}
 
-		_, fileWriteErr := file.Write(encryptedContent)
-		if err != nil {
-			fmt.Println(fileWriteErr)
-		}
+	// Finish writing any transaction that was committed before the process stopped, it may have changed this table
+	recoverErr := db.recoverTransaction()
+	if recoverErr != nil {
+		return recoverErr
+	}
 
-		defer file.Close()
+	if _, existingErr := db.getTable(tableName); existingErr == nil {
+		return nil
 	}
+
+	return db.readTable(tableName)
 }
 
-// Load table to DB from .DAT
-// ** This could probably be improved to only load specific data as needed, or allow for concurrency
-func (db *DB) loadTable(tableName string) (error) {
-	content, err := os.ReadFile(fmt.Sprintf("stores/%v.dat", tableName))
+// Reads a table from its snapshot and log, then attaches it to the DB
+func (db *DB) readTable(tableName string) (error) {
+	data, err := readTableFile(db.databaseName(), tableName)
 	if err != nil {
 		return err
 	}
 
-	ekerr := generateEncryptionKey(keyPath)
-	if ekerr != nil {
-		return ekerr
+	normaliseErr := data.normaliseRowValues()
+	if normaliseErr != nil {
+		return normaliseErr
+	}
+
+	data.buildPrimaryIndex()
+
+	// A table saved before tables had their own data key is given one, its log is still read with the master key. Tables saved
+	// before their content was bound to the table are saved again too
+	isUpgrade := data.generation == 0
+	if _, keyErr := data.sealingKey(); keyErr != nil {
+		return keyErr
+	}
+
+	// Bring the table up to date with any changes made since it was last saved
+	logErr := data.openLog()
+	if logErr != nil {
+		return logErr
+	}
+
+	indexErr := data.loadIndexes()
+	if indexErr != nil {
+		data.closeLog()
+		return indexErr
 	}
 
-	decryptedData, decryptErr := decryptData([]byte(os.Getenv("EK")), content)
+	// ** The table is saved with its new data key straight away, so no change is logged with a key that hasn't been saved
+	if isUpgrade {
+		upgradeErr := data.checkpoint()
+		if upgradeErr != nil {
+			data.closeLog()
+			return upgradeErr
+		}
+	}
+
+	db.attachTable(data)
+	return nil
+}
+
+// Reads the snapshot of a table from its .dat file, without the changes held in its log
+func readTableFile(databaseName string, tableName string) (DBTable, error) {
+	content, err := os.ReadFile(storePath(databaseName, tableName, "dat"))
+	if err != nil {
+		return DBTable{}, err
+	}
+
+	identity := tableIdentity(databaseName, tableName)
+	decryptedData, key, generation, decryptErr := openEnvelope(content, identity)
 	if decryptErr != nil {
-		return decryptErr
+		return DBTable{}, decryptErr
+	}
+
+	identity.Generation = generation
+	generationErr := confirmGeneration(identity)
+	if generationErr != nil {
+		return DBTable{}, generationErr
 	}
 
 	data := DBTable{}
 	err = json.Unmarshal(decryptedData, &data)
 	if err != nil {
-		return err
+		return DBTable{}, err
 	}
 
-	db.attachTable(data)
-	return nil
+	data.database = databaseName
+	data.dataKey = key
+	data.generation = generation
+	return data, nil
 }
 
 // Get table from DB
@@ -139,7 +263,7 @@ func (db *DB) getTable(tableName string) (int, error) {
 }
 
 // Creates a new table for the store
-// ** Want to create something that automatically generates a table based on a struct
+// ** CreateTableFromStruct builds the columns from the fields of a struct instead
 func (db *DB) createTable(tableName string, columnConfig []map[string]any, PrimaryKeyColumnName string, autoIncrementPrimary bool) {
 	configItems := []ColumnConfig{}
 
@@ -200,24 +324,160 @@ func (db *DB) createTableFromMap(tableName string, primaryColumnName string, aut
 	return nil
 }
 
+// Loads a table used by a query, converting load errors into an error that can be shown to the user
+func (db *DB) loadQueryTable(tableName string) (error) {
+	loadTableErr := db.loadTable(tableName)
+
+	if loadTableErr != nil {
+		log.Println("Load Table Error: ", loadTableErr)
+		if errors.Is(loadTableErr, fs.ErrNotExist) {
+			return fmt.Errorf("database could not be found with the name: %v", tableName)
+		} else {
+			return fmt.Errorf("failed to load table data into the database")
+		}
+	}
+
+	return nil
+}
+
+// Checks the user of the database has permission to run the query on each of its tables
+// ** Queries aren't checked when the database has no system database attached
+func (db *DB) confirmQueryAccess(query DBQuery) (error) {
+	db.actor = ""
+	if db.System == nil {
+		return nil
+	}
+
+	authErr := db.System.authenticate(db.User)
+	if authErr != nil {
+		return authErr
+	}
+	db.actor = db.User.Username
+
+	// ** USE and SHOW only read the names in the catalog, so don't need a permission
+	switch {
+	case query.Operation == "USE" || query.Operation == "SHOW":
+		return nil
+	case query.Database != "":
+		if !db.System.confirmUserPermission(db.User, query.Database, query.Operation) {
+			return fmt.Errorf("user %v does not have the %v permission for database %v", db.User.Username, query.Operation, query.Database)
+		}
+
+		return nil
+	}
+
+	// Describing a table shows its layout, so needs the same permission as reading it
+	permission := query.Operation
+	if permission == "DESCRIBE" {
+		permission = "PULL"
+	}
+
+	for _, tableName := range query.tableNames() {
+		if !db.System.confirmUserPermission(db.User, db.scopeName(tableName), permission) {
+			return fmt.Errorf("user %v does not have the %v permission for table %v", db.User.Username, permission, tableName)
+		}
+	}
+
+	return nil
+}
+
 // Runs a query, breaks it down and calls the appropriate function as needed
-func (db *DB) runQuery(queryStr string) (error) {
+func (db *DB) runQuery(queryStr string) (ResultSet, error) {
 	// Breakdown the query into elements
 	query, err := queryBreakdown(queryStr)
 	if err != nil {
 		log.Println("Query Breakdown Error: ", err)
-		return fmt.Errorf("failed to parse database query")
+		return ResultSet{}, fmt.Errorf("failed to parse database query: %w", err)
 	}
 
-	// Load the table needed for the query
-	loadTableErr := db.loadTable(query.TableName)
+	return db.runParsedQuery(query, queryStr)
+}
 
-	if loadTableErr != nil {
-		log.Println("Load Table Error: ", loadTableErr)
-		if strings.Contains(loadTableErr.Error(), "cannot find the file") {
-			return fmt.Errorf("database could not be found with the name: %v", query.TableName)
-		} else {
-			return fmt.Errorf("failed to load table data into the database")
+// Runs a query that has already been broken down, queryStr is how the query is shown in the audit log
+// ** Every query is added to the audit log, including those that fail or are denied. A query that can change the stores is
+// added before it is run, so no change is made without an entry, and a second entry holds its error if it then fails
+func (db *DB) runParsedQuery(query DBQuery, queryStr string) (ResultSet, error) {
+	// Make sure the user is allowed to run the query against every table it uses
+	// ** Transaction control doesn't use a table, so only the auth token of the user is confirmed for it
+	accessErr := db.confirmQueryAccess(query)
+	if accessErr != nil {
+		return ResultSet{}, db.auditFailure(query, queryStr, accessErr)
+	}
+
+	if query.changesStores() {
+		auditErr := db.auditQuery(query, queryStr, nil)
+		if auditErr != nil {
+			return ResultSet{}, fmt.Errorf("the query was not run, as it could not be added to the audit log: %w", auditErr)
+		}
+
+		result, queryErr := db.executeQuery(query)
+		if queryErr != nil {
+			return ResultSet{}, db.auditFailure(query, queryStr, queryErr)
+		}
+
+		return result, nil
+	}
+
+	result, queryErr := db.executeQuery(query)
+	if queryErr != nil {
+		return ResultSet{}, db.auditFailure(query, queryStr, queryErr)
+	}
+
+	auditErr := db.auditQuery(query, queryStr, nil)
+	if auditErr != nil {
+		return ResultSet{}, fmt.Errorf("failed to add the query to the audit log: %w", auditErr)
+	}
+
+	return result, nil
+}
+
+// Adds a query that failed to the audit log, returning the error it failed with along with any error adding it
+func (db *DB) auditFailure(query DBQuery, queryStr string, queryErr error) (error) {
+	auditErr := db.auditQuery(query, queryStr, queryErr)
+	if auditErr != nil {
+		return errors.Join(queryErr, fmt.Errorf("failed to add the query to the audit log: %w", auditErr))
+	}
+
+	return queryErr
+}
+
+// Runs a query that has been broken down against the tables it uses, once confirmQueryAccess has allowed it
+func (db *DB) executeQuery(query DBQuery) (ResultSet, error) {
+	switch query.Operation {
+	case "BEGIN", "COMMIT", "ROLLBACK":
+		return db.runTransactionQuery(query)
+	}
+
+	// Table definitions are changed separately, as the table might not exist yet
+	switch query.Operation {
+	case "CREATE", "DROP", "ALTER":
+		// ** Table definitions are written to file straight away, so can't be undone by a rollback
+		if db.tx != nil {
+			return ResultSet{}, fmt.Errorf("%v cannot be used within a transaction", query.Operation)
+		}
+
+		if query.Database != "" {
+			return db.runCatalogQuery(query)
+		}
+
+		return db.runSchemaQuery(query)
+	case "USE", "SHOW", "DESCRIBE":
+		return db.runCatalogQuery(query)
+	}
+
+	// Load every table needed for the query
+	for _, tableName := range query.tableNames() {
+		loadTableErr := db.loadQueryTable(tableName)
+		if loadTableErr != nil {
+			return ResultSet{}, loadTableErr
+		}
+	}
+
+	// Tables linked by foreign keys are loaded before any are used, as loading a table moves the tables already in memory
+	if query.Operation != "PULL" {
+		relatedErr := db.loadRelatedTables(query.TableName)
+		if relatedErr != nil {
+			return ResultSet{}, relatedErr
 		}
 	}
 
@@ -225,354 +485,595 @@ func (db *DB) runQuery(queryStr string) (error) {
 	tableIndex, queryTableErr := db.getTable(query.TableName)
 	if queryTableErr != nil {
 		log.Println("Get Queried Table Error: ", queryTableErr)
+		return ResultSet{}, queryTableErr
+	}
+
+	table := &db.Tables[tableIndex]
+	result := ResultSet{Operation: query.Operation}
+
+	// Keep a copy of the table before a transaction changes it, so the change can be rolled back
+	if db.tx != nil && query.Operation != "PULL" {
+		db.tx.track(table)
 	}
 
 	switch query.Operation {
 	case "PULL":
-		// ** Needs more logic to actually return object(s)
-		// ** Needs more logic to properly display things in terminal as a data table
-		printTableOutput(db.Tables[tableIndex], query)
+		if !query.isJoined() {
+			return table.pullResultSet(query)
+		}
+
+		joinedTable, joinErr := db.joinTables(query)
+		if joinErr != nil {
+			return ResultSet{}, joinErr
+		}
+
+		return joinedTable.pullResultSet(query)
 	case "PUSH":
-		addTableRowErr := db.Tables[tableIndex].addTableRow(query.OptionsClause)
+		addTableRowErr := table.addTableRow(query.OptionsClause)
 		if addTableRowErr != nil {
-			return addTableRowErr
+			return ResultSet{}, addTableRowErr
 		}
 
-		log.Println("Added table row successfully.")
+		result.RowsAffected = 1
+		result.LastInsertID = table.RowValues[len(table.RowValues)-1].ColumnValues[table.PrimaryKeyColumnName]
 	case "PUT" :
-		updateErr := db.Tables[tableIndex].updateTableRow(query)
+		updatedRows, updateErr := table.updateTableRow(query)
 		if updateErr != nil {
-			return updateErr
+			return ResultSet{}, updateErr
 		}
 
-		log.Println("Updated table row successfully.")
+		result.RowsAffected = updatedRows
 	case "DELETE":
-		removeErr := db.Tables[tableIndex].removeTableRow(query)
+		removedRows, removeErr := table.removeTableRow(query)
 
 		if removeErr != nil {
-			return removeErr
+			return ResultSet{}, removeErr
 		}
 
-		log.Println("Removed table row successfully.")
+		result.RowsAffected = removedRows
 	default :
-		return fmt.Errorf("%v is an unsupported operation type", query.Operation)
+		return ResultSet{}, fmt.Errorf("%v is an unsupported operation type", query.Operation)
 	}
 
-	return nil
+	return result, nil
 }
 
-// Gets the values for a row
-// ** This might need to be fleshed out to return a map, to allow for better data return accuracy
+// Gets the values for a row, in the same order as the column names provided
+// ** A wildcard returns every value in the row, ordered by column name, use getColumnHeaders to follow the table's column order
 func (r *RowValue) getRowValue(columnNamesToInclude []string) (map[string]any, []any) {
-	filteredColumns := []string{}
-
-	row := []any{}
+	filteredColumns := columnNamesToInclude
 
 	// Allow for wildcard in the included columns
-	if !(Contains(columnNamesToInclude, "*")) {
-		filteredColumns = columnNamesToInclude
+	if Contains(columnNamesToInclude, "*") {
+		filteredColumns = []string{}
+		for name := range r.ColumnValues {
+			filteredColumns = append(filteredColumns, name)
+		}
+		slices.Sort(filteredColumns)
 	}
 
+	rowMap := map[string]any{}
+	row := []any{}
+
 	// Filter through the row values
-	for name, value := range r.ColumnValues {
-		if (len(filteredColumns) > 0 && Contains(filteredColumns, name)) || len(filteredColumns) == 0 {
-			row = append(row, value)
-		}
+	for _, name := range filteredColumns {
+		rowMap[name] = r.ColumnValues[name]
+		row = append(row, r.ColumnValues[name])
 	}
 
-	return nil, row
+	return rowMap, row
 }
 
 // Returns a list of the names of the columns to include based on the options argument in a query
-func (t *DBTable) getColumnHeaders(columnNamesToInclude []string) ([]string) {
+// ** Columns are returned in the order they were requested, or in the table's column order for a wildcard
+func (t *DBTable) getColumnHeaders(columnNamesToInclude []string) ([]string, error) {
 	headers := []string{}
-	filteredColumns := []string{}
 
-	// Allow for wildcard in the included columns
-	if !(Contains(columnNamesToInclude, "*")) {
-		filteredColumns = columnNamesToInclude
-	}
+	for _, name := range columnNamesToInclude {
+		// Expand the wildcard into every column of the table, or every column of a single joined table for <table>.*
+		if name == "*" || strings.HasSuffix(name, ".*") {
+			prefix := strings.TrimSuffix(name, "*")
+			matched := false
+
+			for _, value := range t.ColumnConfig {
+				if name == "*" || strings.HasPrefix(value.ColumnName, prefix) {
+					headers = append(headers, value.ColumnName)
+					matched = true
+				}
+			}
+
+			if !matched {
+				return nil, fmt.Errorf("no columns were found matching %v in table %v", name, t.Name)
+			}
+			continue
+		}
 
-	// Check for column names to include
-	for _, value := range t.ColumnConfig {
-		if (len(filteredColumns) > 0 && Contains(filteredColumns, value.ColumnName)) || (len(filteredColumns) == 0) {
-			headers = append(headers, value.ColumnName)
+		config, columnErr := t.getColumnConfig(name)
+		if columnErr != nil {
+			return nil, columnErr
 		}
+
+		headers = append(headers, config.ColumnName)
 	}
 
-	return headers
+	return headers, nil
 }
 
 // Adds a new table row to the table
-// ** This might be able to be improved by only writing bytes at a certain location, instead of parsing the whole file
 func (table *DBTable) addTableRow(cv map[string]any) (error) {
 	newRow := RowValue{
 		ColumnValues: map[string]any{},
 	}
 
+	// ** The auto increment is only moved on once the row is added, so a row that fails a check doesn't use up an ID
+	nextID := table.NextID
+
+	// Reject any values for columns the table doesn't have
+	for name := range cv {
+		if _, columnErr := table.getColumnConfig(name); columnErr != nil {
+			return columnErr
+		}
+	}
+
 	// Check if all columns are accounted for
 	// Check for Nullable values
-	// ** Needs to account for type setting on the columns
 	for _, value := range table.ColumnConfig {
-		if value.ColumnName == table.PrimaryKeyColumnName && cv[value.ColumnName] == nil && !value.Nullable && table.AutoIncrementPrimary {
-			newRow.ColumnValues[value.ColumnName] = table.NextID
-			table.NextID = table.NextID + 1
-		} else if cv[value.ColumnName] == nil && !value.Nullable {
+		columnValue, isGiven := cv[value.ColumnName]
+
+		// Columns left out of the query take their default, a column set to null is left null
+		if !isGiven {
+			defaultValue, defaultErr := value.defaultValue()
+			if defaultErr != nil {
+				return defaultErr
+			}
+
+			columnValue = defaultValue
+		}
+
+		if value.ColumnName == table.PrimaryKeyColumnName && columnValue == nil && !value.Nullable && table.AutoIncrementPrimary {
+			newRow.ColumnValues[value.ColumnName] = nextID
+			nextID = nextID + 1
+		} else if columnValue == nil && !value.Nullable {
 			return fmt.Errorf("%v column was excluded from the query and should not be null", value.ColumnName)
-		} else if cv[value.ColumnName] == nil && value.Nullable {
+		} else if columnValue == nil && value.Nullable {
 			newRow.ColumnValues[value.ColumnName] = nil
 		} else {
-			newRow.ColumnValues[value.ColumnName] = cv[value.ColumnName]
+			typedValue, typeErr := coerceValue(columnValue, value.ColumnType)
+			if typeErr != nil {
+				return fmt.Errorf("invalid value for column %v: %w", value.ColumnName, typeErr)
+			}
+
+			newRow.ColumnValues[value.ColumnName] = typedValue
 		}
 	}
 
-	// Append the row to the row values for the table
-	table.RowValues = append(table.RowValues, newRow)
+	// Every row needs its own primary key, so it can be found again
+	primaryKeyErr := table.confirmPrimaryKeyFree(newRow.ColumnValues[table.PrimaryKeyColumnName], -1)
+	if primaryKeyErr != nil {
+		return primaryKeyErr
+	}
+
+	constraintErr := table.confirmConstraints(newRow.ColumnValues, newRow.ColumnValues, -1)
+	if constraintErr != nil {
+		return constraintErr
+	}
 
-	return nil
+	foreignKeyErr := table.confirmForeignKeys(newRow.ColumnValues)
+	if foreignKeyErr != nil {
+		return foreignKeyErr
+	}
+
+	// Keep the auto increment ahead of any primary key that was set manually
+	if primaryValue, isInt := newRow.ColumnValues[table.PrimaryKeyColumnName].(int); isInt && table.AutoIncrementPrimary && primaryValue >= nextID {
+		nextID = primaryValue + 1
+	}
+
+	// Log the new row, then append it to the row values for the table, which moves the auto increment on to nextID
+	return table.commitChange(walRecord{Operation: "PUSH", Row: newRow.ColumnValues, NextID: nextID})
 }
 
 // Updates table row based on values
-// ** This could probably be optimised quite a lot, given how many loops this relies on
 // ** This might need more error handling included
-func (table *DBTable) updateTableRow(query DBQuery) (error) {
-	modifiedValues := 0;
-
-	for _, rowValue := range table.RowValues {
-
-		for _, argumentValue := range query.ArgumentClause {
-			if rowValue.ColumnValues[argumentValue["Left"].(string)] != nil {
-				switch argumentValue["Operator"] {
-				case "=":
-					if rowValue.ColumnValues[argumentValue["Left"].(string)] == argumentValue["Right"] {
-						for optionName, optionValue := range query.OptionsClause {
-							if rowValue.ColumnValues[optionName] != nil {
-								rowValue.ColumnValues[optionName] = optionValue
-								modifiedValues = modifiedValues + 1
-							}
-
-							if modifiedValues >= len(query.OptionsClause) {
-								break;
-							}
-						}
-					}
-				default :
-					return fmt.Errorf("invalid operator was supplied to update table row: %v", argumentValue["Operator"])
-				}
-			}
+func (table *DBTable) updateTableRow(query DBQuery) (int, error) {
+	// Check the new values against the column types before touching any of the rows
+	updatedValues := map[string]any{}
+	for optionName, optionValue := range query.OptionsClause {
+		config, columnErr := table.getColumnConfig(optionName)
+		if columnErr != nil {
+			return 0, columnErr
+		}
 
-			if rowValue.ColumnValues[argumentValue["Right"].(string)] != nil {
-				switch argumentValue["Operator"] {
-				case "=":
-					if rowValue.ColumnValues[argumentValue["Right"].(string)] == argumentValue["Left"] {
-						for optionName, optionValue := range query.OptionsClause {
-							if rowValue.ColumnValues[optionName] != nil {
-								rowValue.ColumnValues[optionName] = optionValue
-								modifiedValues = modifiedValues + 1
-							}
-
-							if modifiedValues >= len(query.OptionsClause) {
-								break;
-							}
-						}
-					}
-				default :
-					return fmt.Errorf("invalid operator was supplied to update table row: %v", argumentValue["Operator"])
-				}
-			}
+		if optionValue == nil && !config.Nullable {
+			return 0, fmt.Errorf("%v column should not be null", optionName)
+		}
 
-			if modifiedValues >= len(query.OptionsClause) {
-				break;
-			}
+		typedValue, typeErr := coerceValue(optionValue, config.ColumnType)
+		if typeErr != nil {
+			return 0, fmt.Errorf("invalid value for column %v: %w", optionName, typeErr)
 		}
 
-		if modifiedValues >= len(query.OptionsClause) {
-			break;
+		updatedValues[config.ColumnName] = typedValue
+	}
+
+	// Find every matching row first, so a failed comparison doesn't leave the table half updated
+	matchingRows, matchErr := table.matchingRowIndexes(query.WhereClause)
+	if matchErr != nil {
+		return 0, matchErr
+	}
+
+	if len(matchingRows) == 0 {
+		return 0, nil
+	}
+
+	// A new primary key can only be given to a single row, and can't already belong to another row
+	if primaryValue, setsPrimary := updatedValues[table.PrimaryKeyColumnName]; setsPrimary {
+		if len(matchingRows) > 1 {
+			return 0, fmt.Errorf("the primary key %v can't be set on %v rows at once, as each row needs its own primary key", table.PrimaryKeyColumnName, len(matchingRows))
+		}
+
+		primaryKeyErr := table.confirmPrimaryKeyFree(primaryValue, matchingRows[0])
+		if primaryKeyErr != nil {
+			return 0, primaryKeyErr
 		}
 	}
 
-	return nil
+	// Check every row as it would be after the update, so a failed constraint doesn't leave the table half updated
+	for _, rowIndex := range matchingRows {
+		updatedRow := maps.Clone(table.RowValues[rowIndex].ColumnValues)
+		maps.Copy(updatedRow, updatedValues)
+
+		constraintErr := table.confirmConstraints(updatedRow, updatedValues, rowIndex)
+		if constraintErr != nil {
+			return 0, constraintErr
+		}
+	}
+
+	// A UNIQUE column can only be given a value on a single row
+	for _, config := range table.ColumnConfig {
+		if config.Unique && updatedValues[config.ColumnName] != nil && len(matchingRows) > 1 {
+			return 0, fmt.Errorf("the UNIQUE column %v can't be set to the same value on %v rows", config.ColumnName, len(matchingRows))
+		}
+	}
+
+	foreignKeyErr := table.confirmForeignKeys(updatedValues)
+	if foreignKeyErr != nil {
+		return 0, foreignKeyErr
+	}
+
+	referenceErr := table.confirmReferencesKept(matchingRows, updatedValues)
+	if referenceErr != nil {
+		return 0, referenceErr
+	}
+
+	commitErr := table.commitChange(walRecord{Operation: "PUT", Rows: matchingRows, Values: updatedValues})
+	if commitErr != nil {
+		return 0, commitErr
+	}
+
+	return len(matchingRows), nil
 }
 
 // Remove a table row based on arguments
-// ** This could probably be optimised quite a lot, it relies on a few loops
 // ** This might need more error handling included
-func (table *DBTable) removeTableRow(query DBQuery) (error) {
-	modifiedValues := 0;
-
-	for rowIndex, rowValue := range table.RowValues {
-
-		for _, argumentValue := range query.ArgumentClause {
-			if rowValue.ColumnValues[argumentValue["Left"].(string)] != nil {
-				switch argumentValue["Operator"] {
-				case "=":
-					if rowValue.ColumnValues[argumentValue["Left"].(string)] == argumentValue["Right"] {
-						table.RowValues = append(table.RowValues[:rowIndex], table.RowValues[rowIndex+1:]...)
-						modifiedValues = modifiedValues + 1
-						break;
-					}
-				default :
-					return fmt.Errorf("invalid operator was supplied to update table row: %v", argumentValue["Operator"])
-				}
-			}
+func (table *DBTable) removeTableRow(query DBQuery) (int, error) {
+	matchingRows, matchErr := table.matchingRowIndexes(query.WhereClause)
+	if matchErr != nil {
+		return 0, matchErr
+	}
 
-			if rowValue.ColumnValues[argumentValue["Right"].(string)] != nil {
-				switch argumentValue["Operator"] {
-				case "=":
-					if rowValue.ColumnValues[argumentValue["Right"].(string)] == argumentValue["Left"] {
-						table.RowValues = append(table.RowValues[:rowIndex], table.RowValues[rowIndex+1:]...)
-						modifiedValues = modifiedValues + 1
-						break;
-					}
-				default :
-					return fmt.Errorf("invalid operator was supplied to update table row: %v", argumentValue["Operator"])
-				}
-			}
+	if len(matchingRows) == 0 {
+		return 0, fmt.Errorf("matching rows could not be found - no rows were deleted")
+	}
 
-			if modifiedValues >= len(query.OptionsClause) {
-				break;
-			}
+	// Rows in other tables referencing the deleted rows are restricted, deleted or set to null along with them
+	if len(table.ReferencedBy) > 0 {
+		deleteErr := table.db.deleteWithReferences(table.Name, matchingRows)
+		if deleteErr != nil {
+			return 0, deleteErr
 		}
 
-		if modifiedValues >= len(query.OptionsClause) {
-			break;
+		return len(matchingRows), nil
+	}
+
+	commitErr := table.commitChange(walRecord{Operation: "DELETE", Rows: matchingRows})
+	if commitErr != nil {
+		return 0, commitErr
+	}
+
+	return len(matchingRows), nil
+}
+
+// Finds the position of every row matching the where clause
+func (table *DBTable) matchingRowIndexes(where WhereExpr) ([]int, error) {
+	matchingRows := []int{}
+
+	for _, rowIndex := range table.candidateRowIndexes(where) {
+		isMatch, matchErr := table.rowMatches(table.RowValues[rowIndex], where)
+		if matchErr != nil {
+			return nil, matchErr
+		}
+
+		if isMatch {
+			matchingRows = append(matchingRows, rowIndex)
 		}
 	}
 
-	if modifiedValues == 0 {
-		return fmt.Errorf("matching rows could not be found - no rows were deleted")
-	} else {
-		return nil
-	}	
+	return matchingRows, nil
 }
 
-// Break down a query string into its base elements for re-use later
-// ** Need to factor in table joining
-func queryBreakdown(query string) (DBQuery, error) {
-	queryArr := strings.Split(query, " ")
-	columns := []string{}
+// Gets the config for a column by its name
+// ** Joined tables name their columns <table>.<column>, an unqualified name is matched if only one table has the column
+func (table *DBTable) getColumnConfig(columnName string) (ColumnConfig, error) {
+	matches := []ColumnConfig{}
 
-	currentOperation := ""
-	targetTable := ""
-	argumentClause := []map[string]any{}
-	optionsClause := make(map[string]any)
+	for _, value := range table.ColumnConfig {
+		if value.ColumnName == columnName {
+			return value, nil
+		}
 
-	// specify different operation types and check that the query contains them
-	// check the required clauses for the type, throw errors if needed
-	operationTypes := []string{"PULL", "PUSH", "PUT", "DELETE"}
-	
-	if Contains(operationTypes, queryArr[0]) {
-		currentOperation = queryArr[0]
-		requiredStatements := []string{}
-
-		// Set the required fields for each of the operation types
-		switch currentOperation {
-		case "PULL":
-			requiredStatements = []string{"FROM"}
-		case "PUSH":
-			requiredStatements = []string{"TO"}
-		case "PUT":
-			requiredStatements = []string{"TO", "WHERE"}
-		case "DELETE":
-			requiredStatements = []string{"FROM"}
-		default :
-			return DBQuery{}, fmt.Errorf("%v is not a valid operation type", currentOperation)
-		}
-
-		// Filter through required statements, throw an error if some are missing
-		for _, requiredStatementValue := range requiredStatements {
-			if !(strings.Contains(query, requiredStatementValue)) {
-				return DBQuery{}, fmt.Errorf("no %v statement was included in the query", requiredStatementValue)
-			}
+		if !strings.Contains(columnName, ".") && strings.HasSuffix(value.ColumnName, "."+columnName) {
+			matches = append(matches, value)
 		}
-	} else {
-		return DBQuery{}, fmt.Errorf("no valid operation was included in the query, please include either of: %v", operationTypes)
 	}
 
-	// Filter through the query array, find all the terms and group them for use into a DBQuery object
-	for index, value := range queryArr {
-		switch value {
-		case "PULL":
-			for i := index + 1; i < len(queryArr); i++ {
-				if Contains([]string{"FROM"}, queryArr[i]) {
-					break
-				} else {
-					cleanString := strings.Replace(queryArr[i], ",", "", -1) 
-					columns = append(columns, cleanString)
-				}
+	// Allow a column to be qualified with the name of its own table
+	if len(matches) == 0 && strings.HasPrefix(columnName, table.Name+".") {
+		return table.getColumnConfig(strings.TrimPrefix(columnName, table.Name+"."))
+	}
+
+	switch len(matches) {
+	case 0:
+		return ColumnConfig{}, fmt.Errorf("no column was found with the name %v in table %v", columnName, table.Name)
+	case 1:
+		return matches[0], nil
+	}
+
+	return ColumnConfig{}, &AmbiguousColumnError{ColumnName: columnName}
+}
+
+// Checks a row against the WHERE clause of a query, a missing WHERE clause matches every row
+func (table *DBTable) rowMatches(row RowValue, where WhereExpr) (bool, error) {
+	switch expr := where.(type) {
+	case nil:
+		return true, nil
+
+	case *LogicalCondition:
+		leftMatch, leftErr := table.rowMatches(row, expr.Left)
+		if leftErr != nil {
+			return false, leftErr
+		}
+
+		// Skip the right hand side when the left already decides the result
+		if (expr.Operator == "AND" && !leftMatch) || (expr.Operator == "OR" && leftMatch) {
+			return leftMatch, nil
+		}
+
+		return table.rowMatches(row, expr.Right)
+
+	case *NotCondition:
+		isMatch, matchErr := table.rowMatches(row, expr.Expr)
+		return !isMatch, matchErr
+
+	case *Condition:
+		return table.conditionMatches(row, expr)
+	}
+
+	return false, fmt.Errorf("unsupported expression within the where clause: %v", where)
+}
+
+// Checks a row against a single comparison
+func (table *DBTable) conditionMatches(row RowValue, condition *Condition) (bool, error) {
+	operator := condition.Operator
+	left, leftType, leftErr := table.resolveArgument(row, condition.Left.argumentValue())
+	if leftErr != nil {
+		return false, leftErr
+	}
+
+	var right any
+	rightType := ""
+
+	// IN and BETWEEN hold a list of values on the right hand side
+	if condition.Values != nil {
+		resolvedValues := []any{}
+		for _, value := range condition.Values {
+			resolvedValue, _, resolveErr := table.resolveArgument(row, value.argumentValue())
+			if resolveErr != nil {
+				return false, resolveErr
 			}
-		
-		case "PUSH", "PUT":
-			for i := index + 1; i < len(queryArr); i++ {
-				if Contains([]string{"TO"}, queryArr[i]) {
-					break
-				}
 
-				// Filter the table insert options
-				/// Should be in the format of <name> = <value> for each of the columns
-				/// Edge cases included for <name>= <value> and <name> =<value>
-				/// *** More edge cases should be included
-				if strings.Contains(queryArr[i], "=") && len(queryArr[i]) > 1 && !(strings.Contains(queryArr[i], ",")) {
-					optionsClause[strings.Replace(queryArr[i], "=", "", -1)] = queryArr[i + 1]
-				} else if strings.Contains(queryArr[i], "=") && len(queryArr[i]) > 1 && (strings.Contains(queryArr[i], ",")) {
-					optionsClause[queryArr[i - 1]] = strings.Replace(strings.Replace(queryArr[i], "=", "", -1), ",", "", -1)
-				} else if queryArr[i + 1] == "=" {
-					optionsClause[queryArr[i]] = strings.Replace(queryArr[i + 2], ",", "", -1)
-				}
+			resolvedValues = append(resolvedValues, coerceLiteral(resolvedValue, leftType))
+		}
+		right = resolvedValues
+	} else if condition.Right.Kind != tokenEOF {
+		var rightErr error
+		right, rightType, rightErr = table.resolveArgument(row, condition.Right.argumentValue())
+		if rightErr != nil {
+			return false, rightErr
+		}
+	}
+
+	// Literals are compared using the type of the column on the other side, patterns are always strings
+	if !Contains([]string{"LIKE", "NOT LIKE", "~"}, operator) {
+		if leftType != "" && rightType == "" {
+			right = coerceLiteral(right, leftType)
+		} else if rightType != "" && leftType == "" {
+			left = coerceLiteral(left, rightType)
+		}
+	}
+
+	return evaluateCondition(operator, left, right)
+}
+
+// Converts a literal to a column type for comparison, leaving it as it was if it can't be converted
+func coerceLiteral(value any, columnType string) (any) {
+	if columnType == "" {
+		return value
+	}
+
+	typedValue, typeErr := coerceValue(value, columnType)
+	if typeErr != nil {
+		return value
+	}
+
+	return typedValue
+}
+
+// Resolves one side of an argument to its value, returning the column type when it refers to a column
+func (table *DBTable) resolveArgument(row RowValue, argument any) (any, string, error) {
+	reference, isReference := argument.(ColumnReference)
+	if !isReference {
+		return argument, "", nil
+	}
+
+	config, columnErr := table.getColumnConfig(string(reference))
+	if _, isAmbiguous := columnErr.(*AmbiguousColumnError); isAmbiguous {
+		return nil, "", columnErr
+	} else if columnErr != nil {
+		// Bare words that aren't a column are treated as a string
+		return string(reference), "", nil
+	}
+
+	return row.ColumnValues[config.ColumnName], config.ColumnType, nil
+}
+
+// Converts the values loaded from file back into the types set for each column
+func (table *DBTable) normaliseRowValues() (error) {
+	for index, config := range table.ColumnConfig {
+		if config.Default == nil || config.Default.Value == nil {
+			continue
+		}
+
+		typedDefault, typeErr := normaliseStoredValue(config.Default.Value, config.ColumnType)
+		if typeErr != nil {
+			return fmt.Errorf("stored default for column %v in table %v is invalid: %w", config.ColumnName, table.Name, typeErr)
+		}
+
+		table.ColumnConfig[index].Default.Value = typedDefault
+	}
+
+	for _, row := range table.RowValues {
+		for _, config := range table.ColumnConfig {
+			value, exists := row.ColumnValues[config.ColumnName]
+			if !exists || value == nil {
+				continue
 			}
-		
-		case "FROM", "TO": 
-			targetTable = queryArr[(index + 1)]
-		
-		case "WHERE":
-			for i := index + 1; i < len(queryArr); i++ {
-				if Contains([]string{"SORT"}, queryArr[i]) {
-					break
-				} else {
-					// Handle an argument clause, doesn't matter if this includes AND or , between them, as it tracks it from the operator itself
-					newClause := make(map[string]any)
-					cleanString := strings.Replace(queryArr[i], ",", "", -1)
-
-					if Contains([]string{"=", "%"}, cleanString) && strings.Replace(queryArr[i - 1], ",", "", -1) != "WHERE" {
-						newClause["Left"] = strings.Replace(queryArr[i - 1], ",", "", -1)
-						newClause["Operator"] = cleanString
-						newClause["Right"] = strings.Replace(queryArr[i + 1], ",", "", -1)
-						argumentClause = append(argumentClause, newClause)
-					}
-				}
+
+			typedValue, typeErr := normaliseStoredValue(value, config.ColumnType)
+			if typeErr != nil {
+				return fmt.Errorf("stored value for column %v in table %v is invalid: %w", config.ColumnName, table.Name, typeErr)
 			}
+
+			row.ColumnValues[config.ColumnName] = typedValue
 		}
 	}
 
-	return DBQuery{TableName: targetTable, ColumnNames: columns, Operation: currentOperation, OptionsClause: optionsClause, ArgumentClause: argumentClause}, nil
+	return nil
 }
 
-// returns joined string with an array of any input, gets around the strict parsing that strings.Join() has
-func getJoinedString(arr []any, joiner string) (string) {
-	str := ""
+// Break down a query string into its base elements for re-use later
+func queryBreakdown(query string) (DBQuery, error) {
+	statement, err := parseQuery(query)
+	if err != nil {
+		return DBQuery{}, err
+	}
 
-	for index, value := range arr {
-		if index == 0 {
-			str = fmt.Sprintf("%v", value)
-		} else {
-			str = fmt.Sprintf("%v%v%v", str, joiner, value)
+	return buildDBQuery(statement), nil
+}
+
+// Gets the rows matching a PULL query, sorted and paginated using the SORT BY, LIMIT and OFFSET clauses
+func (table *DBTable) selectRows(query DBQuery) ([]RowValue, error) {
+	rows := []RowValue{}
+	candidates := table.candidateRowIndexes(query.WhereClause)
+
+	// An ORDERED index on the sort column gives the rows in order already, so only the candidates need picking out of it
+	sortOrder, isIndexSorted := table.indexedSortOrder(query.SortClause)
+	if isIndexSorted {
+		isCandidate := make([]bool, len(table.RowValues))
+		for _, rowIndex := range candidates {
+			isCandidate[rowIndex] = true
 		}
+
+		candidates = slices.DeleteFunc(sortOrder, func(rowIndex int) bool { return !isCandidate[rowIndex] })
 	}
 
-	return str
+	for _, rowIndex := range candidates {
+		isMatch, matchErr := table.rowMatches(table.RowValues[rowIndex], query.WhereClause)
+		if matchErr != nil {
+			return nil, matchErr
+		}
+
+		if isMatch {
+			rows = append(rows, table.RowValues[rowIndex])
+		}
+	}
+
+	if !isIndexSorted {
+		sortErr := table.sortRows(rows, query.SortClause)
+		if sortErr != nil {
+			return nil, sortErr
+		}
+	}
+
+	if query.Offset != nil {
+		rows = rows[min(*query.Offset, len(rows)):]
+	}
+
+	if query.Limit != nil {
+		rows = rows[:min(*query.Limit, len(rows))]
+	}
+
+	return rows, nil
 }
 
-// This needs some work for filtering the right data being output, max length of strings
-func printTableOutput(table DBTable, query DBQuery) {
-	for index, value := range table.RowValues {
-		if index == 0 {
-			log.Println(strings.Join(table.getColumnHeaders(query.ColumnNames), " | "))
-			log.Println("--------------------------------------------------")
+// Sorts rows by the columns specified, rows that are equal keep their original order
+// ** Null values are sorted before any other value
+func (table *DBTable) sortRows(rows []RowValue, sortItems []SortItem) (error) {
+	sortColumns := []string{}
+	for _, item := range sortItems {
+		config, columnErr := table.getColumnConfig(item.Column)
+		if columnErr != nil {
+			return columnErr
 		}
 
-		_, rowValues := value.getRowValue(query.ColumnNames)
-		log.Println(getJoinedString(rowValues, " | "))
+		sortColumns = append(sortColumns, config.ColumnName)
 	}
+
+	var sortErr error
+
+	slices.SortStableFunc(rows, func(a RowValue, b RowValue) int {
+		for index, item := range sortItems {
+			left := a.ColumnValues[sortColumns[index]]
+			right := b.ColumnValues[sortColumns[index]]
+			comparison := 0
+
+			switch {
+			case left == nil && right == nil:
+				comparison = 0
+			case left == nil:
+				comparison = -1
+			case right == nil:
+				comparison = 1
+			default:
+				var compareErr error
+				comparison, compareErr = compareValues(left, right)
+				if compareErr != nil && sortErr == nil {
+					sortErr = fmt.Errorf("failed to sort by column %v: %w", item.Column, compareErr)
+				}
+			}
+
+			if item.Descending {
+				comparison = -comparison
+			}
+
+			if comparison != 0 {
+				return comparison
+			}
+		}
+
+		return 0
+	})
+
+	return sortErr
 }
 
 // Simple contains function for the array string type
@@ -0,0 +1,1632 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// moves the test into an empty store with its own encryption key, so the real store files are never touched
+func useTestStore(t *testing.T) {
+	directory := t.TempDir()
+	for _, folder := range []string{"stores", "keys", "system"} {
+		if mkdirErr := os.Mkdir(filepath.Join(directory, folder), 0755); mkdirErr != nil {
+			t.Fatalf("failed to create the test store: %v", mkdirErr)
+		}
+	}
+
+	if writeErr := os.WriteFile(filepath.Join(directory, keyPath), []byte("abcdefghijklmnopqrstuvwxyzABCDEF"), 0600); writeErr != nil {
+		t.Fatalf("failed to create the test key: %v", writeErr)
+	}
+
+	workingDirectory, _ := os.Getwd()
+	if chdirErr := os.Chdir(directory); chdirErr != nil {
+		t.Fatalf("failed to move into the test store: %v", chdirErr)
+	}
+
+	t.Cleanup(func() { os.Chdir(workingDirectory) })
+}
+
+// builds an in memory table for testing, without touching the stores folder
+func newTestTable() DBTable {
+	db := DB{}
+	db.createTable("Accounts", []map[string]any{
+		{"ColumnName": "Account_ID", "ColumnType": "int", "Nullable": false},
+		{"ColumnName": "Owner", "ColumnType": "string", "Nullable": false},
+		{"ColumnName": "Balance", "ColumnType": "float64", "Nullable": true},
+		{"ColumnName": "Active", "ColumnType": "bool", "Nullable": true},
+		{"ColumnName": "Opened", "ColumnType": "time", "Nullable": true},
+	}, "Account_ID", true)
+
+	return db.Tables[0]
+}
+
+// test the type enforcement within addTableRow
+func Test_addTableRow(t *testing.T) {
+	testTemplates := []TestTemplate{
+		{
+			TestName: "Test values are coerced to the column type",
+			Inputs: map[string]any{
+				"row": map[string]any{"Owner": "Admin", "Balance": 5, "Opened": "2024-01-02"},
+			},
+		},
+		{
+			TestName: "Test mismatched type is rejected",
+			IsError:  true,
+			Inputs: map[string]any{
+				"row": map[string]any{"Owner": 5},
+			},
+			ExpectedOutput: "invalid value for column Owner: value 5 of type int does not match column type string",
+		},
+		{
+			TestName: "Test unknown column is rejected",
+			IsError:  true,
+			Inputs: map[string]any{
+				"row": map[string]any{"Owner": "Admin", "Nickname": "Ad"},
+			},
+			ExpectedOutput: "no column was found with the name Nickname in table Accounts",
+		},
+		{
+			TestName: "Test missing non nullable column",
+			IsError:  true,
+			Inputs: map[string]any{
+				"row": map[string]any{"Balance": 1.5},
+			},
+			ExpectedOutput: "Owner column was excluded from the query and should not be null",
+		},
+	}
+
+	for _, test := range testTemplates {
+		t.Run(test.TestName, func(t *testing.T) {
+			table := newTestTable()
+			err := table.addTableRow(test.Inputs["row"].(map[string]any))
+
+			if test.IsError {
+				if err == nil || err.Error() != test.ExpectedOutput.(string) {
+					t.Fatalf("error result was incorrect, got: %v, expected: %v", err, test.ExpectedOutput)
+				}
+
+				// a rejected row doesn't use up an auto increment ID
+				if table.NextID != 1 {
+					t.Fatalf("next id was incorrect, got: %v, expected: 1", table.NextID)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			row := table.RowValues[0].ColumnValues
+			if row["Account_ID"] != 1 || row["Balance"] != 5.0 || row["Opened"] != time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC) {
+				t.Fatalf("result was incorrect, got: %v", row)
+			}
+		})
+	}
+}
+
+// test that updates match typed values from a query
+func Test_updateTableRow(t *testing.T) {
+	table := newTestTable()
+	table.addTableRow(map[string]any{"Owner": "Admin", "Balance": 5.0})
+	table.addTableRow(map[string]any{"Owner": "Guest", "Balance": 1.0})
+
+	query, _ := queryBreakdown("PUT Balance = 10 TO Accounts WHERE Account_ID = 2")
+
+	updatedRows, updateErr := table.updateTableRow(query)
+	if updateErr != nil || updatedRows != 1 {
+		t.Fatalf("unexpected result: %v, %v", updatedRows, updateErr)
+	}
+
+	if table.RowValues[0].ColumnValues["Balance"] != 5.0 || table.RowValues[1].ColumnValues["Balance"] != 10.0 {
+		t.Fatalf("result was incorrect, got: %v", table.RowValues)
+	}
+
+	query, _ = queryBreakdown("PUT Active = 'yes' TO Accounts WHERE Account_ID = 2")
+
+	_, updateErr = table.updateTableRow(query)
+	if updateErr == nil || updateErr.Error() != "invalid value for column Active: value yes of type string does not match column type bool" {
+		t.Fatalf("error result was incorrect, got: %v", updateErr)
+	}
+}
+
+// test the primary key index is kept up to date, and rejects duplicate primary keys
+func Test_primaryKeyIndex(t *testing.T) {
+	table := newTestTable()
+	for _, row := range []map[string]any{
+		{"Owner": "Admin", "Balance": 5.0},
+		{"Owner": "Guest", "Balance": 1.0},
+		{"Account_ID": 7, "Owner": "Auditor"},
+	} {
+		if addErr := table.addTableRow(row); addErr != nil {
+			t.Fatalf("unexpected error: %v", addErr)
+		}
+	}
+
+	// finds the owners of the rows matching a where clause, along with the rows checked to find them
+	findOwners := func(t *testing.T, where string) ([]any, []int) {
+		query, queryErr := queryBreakdown("PULL Owner FROM Accounts WHERE " + where)
+		if queryErr != nil {
+			t.Fatalf("unexpected error: %v", queryErr)
+		}
+
+		rows, selectErr := table.selectRows(query)
+		if selectErr != nil {
+			t.Fatalf("unexpected error: %v", selectErr)
+		}
+
+		owners := []any{}
+		for _, row := range rows {
+			owners = append(owners, row.ColumnValues["Owner"])
+		}
+
+		return owners, table.candidateRowIndexes(query.WhereClause)
+	}
+
+	testTemplates := []TestTemplate{
+		{
+			TestName: "Test primary key lookup",
+			Inputs: map[string]any{
+				"where":      "Account_ID = 2",
+				"candidates": []int{1},
+			},
+			ExpectedOutput: []any{"Guest"},
+		},
+		{
+			TestName: "Test primary key lookup within AND",
+			Inputs: map[string]any{
+				"where":      "Owner = Admin AND 7 = Account_ID",
+				"candidates": []int{2},
+			},
+			ExpectedOutput: []any{},
+		},
+		{
+			TestName: "Test primary key IN lookup",
+			Inputs: map[string]any{
+				"where":      "Account_ID IN (7, 1, 99)",
+				"candidates": []int{0, 2},
+			},
+			ExpectedOutput: []any{"Admin", "Auditor"},
+		},
+		{
+			TestName: "Test OR checks every row",
+			Inputs: map[string]any{
+				"where":      "Account_ID = 1 OR Owner = Guest",
+				"candidates": []int{0, 1, 2},
+			},
+			ExpectedOutput: []any{"Admin", "Guest"},
+		},
+	}
+
+	for _, test := range testTemplates {
+		t.Run(test.TestName, func(t *testing.T) {
+			owners, candidates := findOwners(t, test.Inputs["where"].(string))
+
+			if !reflect.DeepEqual(owners, test.ExpectedOutput) {
+				t.Fatalf("result was incorrect, got: %v, expected: %v", owners, test.ExpectedOutput)
+			}
+
+			if !reflect.DeepEqual(candidates, test.Inputs["candidates"]) {
+				t.Fatalf("rows checked were incorrect, got: %v, expected: %v", candidates, test.Inputs["candidates"])
+			}
+		})
+	}
+
+	t.Run("Test duplicate primary keys are rejected", func(t *testing.T) {
+		addErr := table.addTableRow(map[string]any{"Account_ID": 2, "Owner": "Copy"})
+		if addErr == nil || addErr.Error() != "a row already exists in table Accounts with the primary key Account_ID = 2" {
+			t.Fatalf("error result was incorrect, got: %v", addErr)
+		}
+
+		query, _ := queryBreakdown("PUT Account_ID = 7 TO Accounts WHERE Owner = Admin")
+		if _, updateErr := table.updateTableRow(query); updateErr == nil {
+			t.Fatalf("expected an error for a PUT onto an existing primary key")
+		}
+
+		query, _ = queryBreakdown("PUT Account_ID = 50 TO Accounts WHERE Balance IS NOT NULL")
+		if _, updateErr := table.updateTableRow(query); updateErr == nil {
+			t.Fatalf("expected an error for a PUT giving several rows the same primary key")
+		}
+	})
+
+	t.Run("Test the index follows updates and deletes", func(t *testing.T) {
+		query, _ := queryBreakdown("PUT Account_ID = 3 TO Accounts WHERE Owner = Auditor")
+		if _, updateErr := table.updateTableRow(query); updateErr != nil {
+			t.Fatalf("unexpected error: %v", updateErr)
+		}
+
+		query, _ = queryBreakdown("DELETE FROM Accounts WHERE Account_ID = 1")
+		if _, removeErr := table.removeTableRow(query); removeErr != nil {
+			t.Fatalf("unexpected error: %v", removeErr)
+		}
+
+		if owners, _ := findOwners(t, "Account_ID = 3"); !reflect.DeepEqual(owners, []any{"Auditor"}) {
+			t.Fatalf("result was incorrect, got: %v", owners)
+		}
+
+		if owners, _ := findOwners(t, "Account_ID = 7"); !reflect.DeepEqual(owners, []any{}) {
+			t.Fatalf("result was incorrect, got: %v", owners)
+		}
+	})
+}
+
+// test the comparison operators available to a WHERE clause
+func Test_rowMatches(t *testing.T) {
+	table := newTestTable()
+	table.addTableRow(map[string]any{"Owner": "Admin", "Balance": 5.0, "Active": true, "Opened": "2024-01-02"})
+	table.addTableRow(map[string]any{"Owner": "Guest", "Balance": 1.5})
+	table.addTableRow(map[string]any{"Owner": "guest_2", "Active": false, "Opened": "2024-03-01"})
+
+	testTemplates := []TestTemplate{
+		{TestName: "Test equals", Inputs: map[string]any{"where": "Owner = Admin"}, ExpectedOutput: []int{1}},
+		{TestName: "Test not equals", Inputs: map[string]any{"where": "Owner != Admin"}, ExpectedOutput: []int{2, 3}},
+		{TestName: "Test less than", Inputs: map[string]any{"where": "Balance < 5"}, ExpectedOutput: []int{2}},
+		{TestName: "Test greater or equal", Inputs: map[string]any{"where": "Balance >= 1.5"}, ExpectedOutput: []int{1, 2}},
+		{TestName: "Test like", Inputs: map[string]any{"where": "Owner LIKE 'G%'"}, ExpectedOutput: []int{2}},
+		{TestName: "Test like single character", Inputs: map[string]any{"where": "Owner LIKE 'guest__'"}, ExpectedOutput: []int{3}},
+		{TestName: "Test not like", Inputs: map[string]any{"where": "Owner NOT LIKE '%uest%'"}, ExpectedOutput: []int{1}},
+		{TestName: "Test in", Inputs: map[string]any{"where": "Account_ID IN (1, 3, 4)"}, ExpectedOutput: []int{1, 3}},
+		{TestName: "Test not in", Inputs: map[string]any{"where": "Owner NOT IN (Admin, Guest)"}, ExpectedOutput: []int{3}},
+		{TestName: "Test between", Inputs: map[string]any{"where": "Opened BETWEEN '2024-01-01' AND '2024-02-01'"}, ExpectedOutput: []int{1}},
+		{TestName: "Test between joined with and", Inputs: map[string]any{"where": "Account_ID BETWEEN 1 AND 3 AND Active = false"}, ExpectedOutput: []int{3}},
+		{TestName: "Test is null", Inputs: map[string]any{"where": "Active IS NULL"}, ExpectedOutput: []int{2}},
+		{TestName: "Test is not null", Inputs: map[string]any{"where": "Balance IS NOT NULL"}, ExpectedOutput: []int{1, 2}},
+		{TestName: "Test regex", Inputs: map[string]any{"where": "Owner MATCHES '^[a-z]+_[0-9]$'"}, ExpectedOutput: []int{3}},
+		{TestName: "Test regex operator", Inputs: map[string]any{"where": "Owner ~ '^(?i)guest'"}, ExpectedOutput: []int{2, 3}},
+		{TestName: "Test or", Inputs: map[string]any{"where": "Owner = Admin OR Owner = Guest"}, ExpectedOutput: []int{1, 2}},
+		{TestName: "Test and binds tighter than or", Inputs: map[string]any{"where": "Owner = Admin OR Owner = Guest AND Balance > 2"}, ExpectedOutput: []int{1}},
+		{TestName: "Test brackets", Inputs: map[string]any{"where": "(Owner = Admin OR Owner = Guest) AND Balance < 2"}, ExpectedOutput: []int{2}},
+		{TestName: "Test not", Inputs: map[string]any{"where": "NOT (Owner = Admin OR Active = false)"}, ExpectedOutput: []int{2}},
+		{TestName: "Test mismatched comparison", IsError: true, Inputs: map[string]any{"where": "Active > 'abc'"}, ExpectedOutput: "cannot compare true (bool) with abc (string)"},
+	}
+
+	for _, test := range testTemplates {
+		t.Run(test.TestName, func(t *testing.T) {
+			query, queryErr := queryBreakdown("PULL * FROM Accounts WHERE " + test.Inputs["where"].(string))
+			if queryErr != nil {
+				t.Fatalf("unexpected error: %v", queryErr)
+			}
+
+			matches := []int{}
+			for _, row := range table.RowValues {
+				isMatch, matchErr := table.rowMatches(row, query.WhereClause)
+				if matchErr != nil {
+					if test.IsError && matchErr.Error() == test.ExpectedOutput.(string) {
+						return
+					}
+					t.Fatalf("unexpected error: %v", matchErr)
+				}
+
+				if isMatch {
+					matches = append(matches, row.ColumnValues["Account_ID"].(int))
+				}
+			}
+
+			if test.IsError || !reflect.DeepEqual(matches, test.ExpectedOutput) {
+				t.Fatalf("result was incorrect, got: %v, expected: %v", matches, test.ExpectedOutput)
+			}
+		})
+	}
+}
+
+// test the compiled patterns are capped, dropping the least recently used
+func Test_patternCache(t *testing.T) {
+	cache := newPatternCache(2)
+	for _, pattern := range []string{"a", "b", "a", "c"} {
+		expression, _ := regexp.Compile(pattern)
+		cache.store(pattern, expression)
+	}
+
+	// b was used least recently when c was added
+	for pattern, isExpected := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, exists := cache.load(pattern); exists != isExpected {
+			t.Fatalf("result was incorrect for %v, got: %v, expected: %v", pattern, exists, isExpected)
+		}
+	}
+
+	if cache.order.Len() != 2 || len(cache.entries) != 2 {
+		t.Fatalf("expected 2 patterns in the cache, got: %v", cache.order.Len())
+	}
+}
+
+// test the sorting and pagination of PULL queries
+func Test_selectRows(t *testing.T) {
+	table := newTestTable()
+	table.addTableRow(map[string]any{"Owner": "Carol", "Balance": 5.0})
+	table.addTableRow(map[string]any{"Owner": "Alice", "Balance": 1.5})
+	table.addTableRow(map[string]any{"Owner": "Bob"})
+	table.addTableRow(map[string]any{"Owner": "Alice", "Balance": 3.0})
+
+	testTemplates := []TestTemplate{
+		{TestName: "Test default order", Inputs: map[string]any{"clauses": ""}, ExpectedOutput: []int{1, 2, 3, 4}},
+		{TestName: "Test sort ascending", Inputs: map[string]any{"clauses": "SORT BY Balance"}, ExpectedOutput: []int{3, 2, 4, 1}},
+		{TestName: "Test sort descending", Inputs: map[string]any{"clauses": "SORT BY Balance DESC"}, ExpectedOutput: []int{1, 4, 2, 3}},
+		{TestName: "Test sort by multiple columns", Inputs: map[string]any{"clauses": "SORT BY Owner ASC, Balance DESC"}, ExpectedOutput: []int{4, 2, 3, 1}},
+		{TestName: "Test limit and offset", Inputs: map[string]any{"clauses": "SORT BY Owner LIMIT 2 OFFSET 1"}, ExpectedOutput: []int{4, 3}},
+		{TestName: "Test offset past the end", Inputs: map[string]any{"clauses": "LIMIT 2 OFFSET 10"}, ExpectedOutput: []int{}},
+		{TestName: "Test where with sort", Inputs: map[string]any{"clauses": "WHERE Balance IS NOT NULL SORT BY Balance LIMIT 2"}, ExpectedOutput: []int{2, 4}},
+		{TestName: "Test unknown sort column", IsError: true, Inputs: map[string]any{"clauses": "SORT BY Nickname"}, ExpectedOutput: "no column was found with the name Nickname in table Accounts"},
+	}
+
+	for _, test := range testTemplates {
+		t.Run(test.TestName, func(t *testing.T) {
+			query, queryErr := queryBreakdown("PULL * FROM Accounts " + test.Inputs["clauses"].(string))
+			if queryErr != nil {
+				t.Fatalf("unexpected error: %v", queryErr)
+			}
+
+			rows, selectErr := table.selectRows(query)
+			if test.IsError {
+				if selectErr == nil || selectErr.Error() != test.ExpectedOutput.(string) {
+					t.Fatalf("error result was incorrect, got: %v, expected: %v", selectErr, test.ExpectedOutput)
+				}
+				return
+			}
+
+			if selectErr != nil {
+				t.Fatalf("unexpected error: %v", selectErr)
+			}
+
+			ids := []int{}
+			for _, row := range rows {
+				ids = append(ids, row.ColumnValues["Account_ID"].(int))
+			}
+
+			if !reflect.DeepEqual(ids, test.ExpectedOutput) {
+				t.Fatalf("result was incorrect, got: %v, expected: %v", ids, test.ExpectedOutput)
+			}
+		})
+	}
+}
+
+// test that projected values line up with their headers
+func Test_getColumnHeaders(t *testing.T) {
+	table := newTestTable()
+	table.addTableRow(map[string]any{"Owner": "Admin", "Balance": 5.0, "Active": true})
+
+	testTemplates := []TestTemplate{
+		{
+			TestName:       "Test requested order is kept",
+			Inputs:         map[string]any{"columns": []string{"Balance", "Owner", "Account_ID"}},
+			ExpectedOutput: []any{5.0, "Admin", 1},
+		},
+		{
+			TestName:       "Test wildcard follows the column config",
+			Inputs:         map[string]any{"columns": []string{"*"}},
+			ExpectedOutput: []any{1, "Admin", 5.0, true, nil},
+		},
+		{
+			TestName:       "Test unknown column",
+			IsError:        true,
+			Inputs:         map[string]any{"columns": []string{"Owner", "Nickname"}},
+			ExpectedOutput: "no column was found with the name Nickname in table Accounts",
+		},
+	}
+
+	for _, test := range testTemplates {
+		t.Run(test.TestName, func(t *testing.T) {
+			headers, headerErr := table.getColumnHeaders(test.Inputs["columns"].([]string))
+			if test.IsError {
+				if headerErr == nil || headerErr.Error() != test.ExpectedOutput.(string) {
+					t.Fatalf("error result was incorrect, got: %v, expected: %v", headerErr, test.ExpectedOutput)
+				}
+				return
+			}
+
+			rowMap, rowValues := table.RowValues[0].getRowValue(headers)
+			if !reflect.DeepEqual(rowValues, test.ExpectedOutput) {
+				t.Fatalf("result was incorrect, got: %v, expected: %v", rowValues, test.ExpectedOutput)
+			}
+
+			for index, header := range headers {
+				if rowMap[header] != rowValues[index] {
+					t.Fatalf("row map did not match the row values, got: %v", rowMap)
+				}
+			}
+		})
+	}
+}
+
+// test the result set returned for a PULL query
+func Test_pullResultSet(t *testing.T) {
+	table := newTestTable()
+	table.addTableRow(map[string]any{"Owner": "Admin", "Balance": 5.0})
+	table.addTableRow(map[string]any{"Owner": "Guest"})
+
+	query, _ := queryBreakdown("PULL Owner, Balance FROM Accounts SORT BY Owner DESC")
+
+	result, resultErr := table.pullResultSet(query)
+	if resultErr != nil {
+		t.Fatalf("unexpected error: %v", resultErr)
+	}
+
+	expectedColumns := []ResultColumn{{Name: "Owner", Type: "string"}, {Name: "Balance", Type: "float64"}}
+	if !reflect.DeepEqual(result.Columns, expectedColumns) {
+		t.Fatalf("columns were incorrect, got: %v, expected: %v", result.Columns, expectedColumns)
+	}
+
+	expectedRows := [][]any{{"Guest", nil}, {"Admin", 5.0}}
+	if !reflect.DeepEqual(result.Rows, expectedRows) {
+		t.Fatalf("rows were incorrect, got: %v, expected: %v", result.Rows, expectedRows)
+	}
+
+	expectedOutput := "Owner | Balance\n------+--------\nGuest | NULL\nAdmin | 5\n(2 row(s))"
+	if result.format() != expectedOutput {
+		t.Fatalf("formatted output was incorrect, got:\n%v\nexpected:\n%v", result.format(), expectedOutput)
+	}
+}
+
+// test aggregate functions with GROUP BY and HAVING
+func Test_groupedResultSet(t *testing.T) {
+	db := DB{}
+	db.createTable("Orders", []map[string]any{
+		{"ColumnName": "Order_ID", "ColumnType": "int", "Nullable": false},
+		{"ColumnName": "Customer", "ColumnType": "string", "Nullable": false},
+		{"ColumnName": "Amount", "ColumnType": "int", "Nullable": true},
+	}, "Order_ID", true)
+	table := db.Tables[0]
+
+	for _, row := range []map[string]any{
+		{"Customer": "Alice", "Amount": 10},
+		{"Customer": "Bob", "Amount": 5},
+		{"Customer": "Alice", "Amount": 30},
+		{"Customer": "Alice"},
+		{"Customer": "Carol"},
+		{"Customer": "Bob", "Amount": 7},
+	} {
+		if addErr := table.addTableRow(row); addErr != nil {
+			t.Fatalf("failed to add test row: %v", addErr)
+		}
+	}
+
+	testTemplates := []TestTemplate{
+		{
+			TestName:       "Test aggregates without grouping",
+			Inputs:         map[string]any{"query": "PULL COUNT(*), COUNT(Amount), SUM(Amount), AVG(Amount), MIN(Amount), MAX(Amount) FROM Orders"},
+			ExpectedOutput: [][]any{{6, 4, 52, 13.0, 5, 30}},
+		},
+		{
+			TestName:       "Test aggregates over no rows",
+			Inputs:         map[string]any{"query": "PULL COUNT(*), SUM(Amount) FROM Orders WHERE Customer = Dave"},
+			ExpectedOutput: [][]any{{0, nil}},
+		},
+		{
+			TestName:       "Test group by",
+			Inputs:         map[string]any{"query": "PULL Customer, COUNT(*), SUM(Amount) FROM Orders GROUP BY Customer"},
+			ExpectedOutput: [][]any{{"Alice", 3, 40}, {"Bob", 2, 12}, {"Carol", 1, nil}},
+		},
+		{
+			TestName:       "Test having",
+			Inputs:         map[string]any{"query": "PULL Customer FROM Orders GROUP BY Customer HAVING COUNT(*) > 1 AND MAX(Amount) < 20"},
+			ExpectedOutput: [][]any{{"Bob"}},
+		},
+		{
+			TestName:       "Test sort by aggregate",
+			Inputs:         map[string]any{"query": "PULL Customer, SUM(Amount) FROM Orders GROUP BY Customer SORT BY SUM(Amount) DESC LIMIT 2"},
+			ExpectedOutput: [][]any{{"Alice", 40}, {"Bob", 12}},
+		},
+		{
+			TestName:       "Test ungrouped column",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "PULL Customer, Amount FROM Orders GROUP BY Customer"},
+			ExpectedOutput: "column Amount must be included in GROUP BY or used within an aggregate function",
+		},
+		{
+			TestName:       "Test sum of a string column",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "PULL SUM(Customer) FROM Orders"},
+			ExpectedOutput: "SUM cannot be used on column Customer of type string",
+		},
+	}
+
+	for _, test := range testTemplates {
+		t.Run(test.TestName, func(t *testing.T) {
+			query, queryErr := queryBreakdown(test.Inputs["query"].(string))
+			if queryErr != nil {
+				t.Fatalf("unexpected error: %v", queryErr)
+			}
+
+			result, resultErr := table.pullResultSet(query)
+			if test.IsError {
+				if resultErr == nil || resultErr.Error() != test.ExpectedOutput.(string) {
+					t.Fatalf("error result was incorrect, got: %v, expected: %v", resultErr, test.ExpectedOutput)
+				}
+				return
+			}
+
+			if resultErr != nil {
+				t.Fatalf("unexpected error: %v", resultErr)
+			}
+
+			if !reflect.DeepEqual(result.Rows, test.ExpectedOutput) {
+				t.Fatalf("result was incorrect, got: %v, expected: %v", result.Rows, test.ExpectedOutput)
+			}
+		})
+	}
+}
+
+// test joining tables together through joinTables
+func Test_joinTables(t *testing.T) {
+	db := DB{}
+	db.createTable("Customers", []map[string]any{
+		{"ColumnName": "Customer_ID", "ColumnType": "int", "Nullable": false},
+		{"ColumnName": "Name", "ColumnType": "string", "Nullable": false},
+	}, "Customer_ID", true)
+	db.createTable("Orders", []map[string]any{
+		{"ColumnName": "Order_ID", "ColumnType": "int", "Nullable": false},
+		{"ColumnName": "Customer_ID", "ColumnType": "int", "Nullable": false},
+		{"ColumnName": "Amount", "ColumnType": "int", "Nullable": false},
+	}, "Order_ID", true)
+
+	for _, name := range []string{"Alice", "Bob", "Carol"} {
+		if addErr := db.Tables[0].addTableRow(map[string]any{"Name": name}); addErr != nil {
+			t.Fatalf("failed to add test row: %v", addErr)
+		}
+	}
+
+	for _, row := range []map[string]any{
+		{"Customer_ID": 1, "Amount": 10},
+		{"Customer_ID": 2, "Amount": 5},
+		{"Customer_ID": 1, "Amount": 30},
+	} {
+		if addErr := db.Tables[1].addTableRow(row); addErr != nil {
+			t.Fatalf("failed to add test row: %v", addErr)
+		}
+	}
+
+	testTemplates := []TestTemplate{
+		{
+			TestName: "Test inner join",
+			Inputs:   map[string]any{"query": "PULL c.Name, o.Amount FROM Customers c JOIN Orders o ON c.Customer_ID = o.Customer_ID SORT BY o.Order_ID"},
+			ExpectedOutput: ResultSet{
+				Operation: "PULL",
+				Columns:   []ResultColumn{{Name: "c.Name", Type: "string"}, {Name: "o.Amount", Type: "int"}},
+				Rows:      [][]any{{"Alice", 10}, {"Bob", 5}, {"Alice", 30}},
+			},
+		},
+		{
+			TestName: "Test left join pads missing rows with null",
+			Inputs:   map[string]any{"query": "PULL Name, Amount FROM Customers AS c LEFT JOIN Orders AS o ON c.Customer_ID = o.Customer_ID WHERE c.Customer_ID > 1"},
+			ExpectedOutput: ResultSet{
+				Operation: "PULL",
+				Columns:   []ResultColumn{{Name: "c.Name", Type: "string"}, {Name: "o.Amount", Type: "int"}},
+				Rows:      [][]any{{"Bob", 5}, {"Carol", nil}},
+			},
+		},
+		{
+			TestName: "Test cross join without aliases",
+			Inputs:   map[string]any{"query": "PULL Customers.Name, COUNT(*) FROM Customers CROSS JOIN Orders GROUP BY Customers.Name"},
+			ExpectedOutput: ResultSet{
+				Operation: "PULL",
+				Columns:   []ResultColumn{{Name: "Customers.Name", Type: "string"}, {Name: "COUNT(*)", Type: "int"}},
+				Rows:      [][]any{{"Alice", 3}, {"Bob", 3}, {"Carol", 3}},
+			},
+		},
+		{
+			TestName: "Test wildcard for a single table",
+			Inputs:   map[string]any{"query": "PULL o.* FROM Customers c JOIN Orders o ON c.Customer_ID = o.Customer_ID WHERE c.Name = Bob"},
+			ExpectedOutput: ResultSet{
+				Operation: "PULL",
+				Columns:   []ResultColumn{{Name: "o.Order_ID", Type: "int"}, {Name: "o.Customer_ID", Type: "int"}, {Name: "o.Amount", Type: "int"}},
+				Rows:      [][]any{{2, 2, 5}},
+			},
+		},
+		{
+			TestName:       "Test ambiguous column",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "PULL Customer_ID FROM Customers c JOIN Orders o ON c.Customer_ID = o.Customer_ID"},
+			ExpectedOutput: "column name Customer_ID is ambiguous, qualify it with a table name or alias",
+		},
+		{
+			TestName:       "Test ambiguous column within the join condition",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "PULL c.Name FROM Customers c JOIN Orders o ON Customer_ID = 1"},
+			ExpectedOutput: "failed to join table Orders: column name Customer_ID is ambiguous, qualify it with a table name or alias",
+		},
+		{
+			TestName:       "Test duplicate alias",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "PULL * FROM Orders JOIN Orders ON Orders.Order_ID = Orders.Order_ID"},
+			ExpectedOutput: "table name or alias Orders is used more than once, give each table a different alias",
+		},
+	}
+
+	for _, test := range testTemplates {
+		t.Run(test.TestName, func(t *testing.T) {
+			query, queryErr := queryBreakdown(test.Inputs["query"].(string))
+			if queryErr != nil {
+				t.Fatalf("unexpected error: %v", queryErr)
+			}
+
+			joinedTable, joinErr := db.joinTables(query)
+			var result ResultSet
+			if joinErr == nil {
+				result, joinErr = joinedTable.pullResultSet(query)
+			}
+
+			if test.IsError {
+				if joinErr == nil || joinErr.Error() != test.ExpectedOutput.(string) {
+					t.Fatalf("error result was incorrect, got: %v, expected: %v", joinErr, test.ExpectedOutput)
+				}
+				return
+			}
+
+			if joinErr != nil {
+				t.Fatalf("unexpected error: %v", joinErr)
+			}
+
+			if !reflect.DeepEqual(result, test.ExpectedOutput) {
+				t.Fatalf("result was incorrect, got: %+v, expected: %+v", result, test.ExpectedOutput)
+			}
+		})
+	}
+}
+
+// test CREATE TABLE, ALTER TABLE and DROP TABLE through runQuery
+func Test_runSchemaQuery(t *testing.T) {
+	useTestStore(t)
+	db := DB{}
+
+	testTemplates := []TestTemplate{
+		{
+			TestName:       "Test create table",
+			Inputs:         map[string]any{"query": "CREATE TABLE SchemaTestOrders (Order_ID int, Customer string NOT NULL, Amount int, PRIMARY KEY Order_ID AUTO)"},
+			ExpectedOutput: []string{"Order_ID", "Customer", "Amount"},
+		},
+		{
+			TestName:       "Test duplicate table",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "CREATE TABLE SchemaTestOrders (Order_ID int, PRIMARY KEY Order_ID)"},
+			ExpectedOutput: "a table already exists with the name: SchemaTestOrders",
+		},
+		{
+			TestName:       "Test push into the new table",
+			Inputs:         map[string]any{"query": "PUSH Customer = Alice, Amount = 10 TO SchemaTestOrders"},
+			ExpectedOutput: []string{"Order_ID", "Customer", "Amount"},
+		},
+		{
+			TestName:       "Test add column",
+			Inputs:         map[string]any{"query": "ALTER TABLE SchemaTestOrders ADD COLUMN Notes string"},
+			ExpectedOutput: []string{"Order_ID", "Customer", "Amount", "Notes"},
+		},
+		{
+			TestName:       "Test add not null column to a table with rows",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "ALTER TABLE SchemaTestOrders ADD Region string NOT NULL"},
+			ExpectedOutput: "column Region cannot be added as NOT NULL without a DEFAULT, as table SchemaTestOrders already has rows",
+		},
+		{
+			TestName:       "Test add not null column with a default to a table with rows",
+			Inputs:         map[string]any{"query": "ALTER TABLE SchemaTestOrders ADD Region string NOT NULL DEFAULT 'North'"},
+			ExpectedOutput: []string{"Order_ID", "Customer", "Amount", "Notes", "Region"},
+		},
+		{
+			TestName:       "Test rename column",
+			Inputs:         map[string]any{"query": "ALTER TABLE SchemaTestOrders RENAME Amount TO Total"},
+			ExpectedOutput: []string{"Order_ID", "Customer", "Total", "Notes", "Region"},
+		},
+		{
+			TestName:       "Test drop column",
+			Inputs:         map[string]any{"query": "ALTER TABLE SchemaTestOrders DROP COLUMN Notes"},
+			ExpectedOutput: []string{"Order_ID", "Customer", "Total", "Region"},
+		},
+		{
+			TestName:       "Test drop primary key column",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "ALTER TABLE SchemaTestOrders DROP Order_ID"},
+			ExpectedOutput: "the primary key column Order_ID cannot be dropped",
+		},
+		{
+			TestName:       "Test drop table",
+			Inputs:         map[string]any{"query": "DROP TABLE SchemaTestOrders"},
+			ExpectedOutput: []string(nil),
+		},
+	}
+
+	for _, test := range testTemplates {
+		t.Run(test.TestName, func(t *testing.T) {
+			_, queryErr := db.runQuery(test.Inputs["query"].(string))
+			if test.IsError {
+				if queryErr == nil || queryErr.Error() != test.ExpectedOutput.(string) {
+					t.Fatalf("error result was incorrect, got: %v, expected: %v", queryErr, test.ExpectedOutput)
+				}
+				return
+			}
+
+			if queryErr != nil {
+				t.Fatalf("unexpected error: %v", queryErr)
+			}
+
+			// Check the columns of the table and that every row was rewritten to match them
+			var columns []string
+			if tableIndex, tableErr := db.getTable("SchemaTestOrders"); tableErr == nil {
+				table := db.Tables[tableIndex]
+				for _, config := range table.ColumnConfig {
+					columns = append(columns, config.ColumnName)
+				}
+
+				for _, row := range table.RowValues {
+					if len(row.ColumnValues) != len(columns) {
+						t.Fatalf("row was not rewritten, got: %v, expected columns: %v", row.ColumnValues, columns)
+					}
+				}
+			}
+
+			if !reflect.DeepEqual(columns, test.ExpectedOutput) {
+				t.Fatalf("result was incorrect, got: %v, expected: %v", columns, test.ExpectedOutput)
+			}
+		})
+	}
+}
+
+// test the UNIQUE, CHECK, DEFAULT and NOT NULL constraints on PUSH and PUT, and that they are kept when the table is loaded again
+func Test_constraints(t *testing.T) {
+	useTestStore(t)
+
+	db := DB{}
+	for _, query := range []string{
+		"CREATE TABLE Members (Member_ID int, Email string NOT NULL UNIQUE, Age int CHECK (Age >= 18 AND Age < 150), Tier string NOT NULL DEFAULT 'Basic', Token string UNIQUE DEFAULT uuid(), Joined time DEFAULT now(), Retired bool CHECK (Retired = false OR Age >= 55), PRIMARY KEY Member_ID AUTO)",
+		"PUSH Email = 'ada@example.com', Age = 36 TO Members",
+		"PUSH Email = 'alan@example.com', Tier = Gold, Token = null TO Members",
+	} {
+		if _, queryErr := db.runQuery(query); queryErr != nil {
+			t.Fatalf("unexpected error for %v: %v", query, queryErr)
+		}
+	}
+
+	testTemplates := []TestTemplate{
+		{
+			TestName:       "Test duplicate UNIQUE value on push",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "PUSH Email = 'ada@example.com', Age = 40 TO Members"},
+			ExpectedOutput: "a row already exists in table Members with the value ada@example.com for UNIQUE column Email",
+		},
+		{
+			TestName:       "Test failed CHECK on push",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "PUSH Email = 'kid@example.com', Age = 12 TO Members"},
+			ExpectedOutput: "value 12 for column Age does not pass its CHECK ((Age >= 18 AND Age < 150))",
+		},
+		{
+			TestName:       "Test NOT NULL column set to null",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "PUSH Email = 'grace@example.com', Tier = null TO Members"},
+			ExpectedOutput: "Tier column was excluded from the query and should not be null",
+		},
+		{
+			TestName:       "Test duplicate UNIQUE value on put",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "PUT Email = 'ada@example.com' TO Members WHERE Member_ID = 2"},
+			ExpectedOutput: "a row already exists in table Members with the value ada@example.com for UNIQUE column Email",
+		},
+		{
+			TestName:       "Test UNIQUE value put onto several rows",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "PUT Email = 'team@example.com' TO Members WHERE Member_ID > 0"},
+			ExpectedOutput: "the UNIQUE column Email can't be set to the same value on 2 rows",
+		},
+		{
+			TestName:       "Test failed CHECK on put",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "PUT Age = 200 TO Members WHERE Member_ID = 1"},
+			ExpectedOutput: "value 200 for column Age does not pass its CHECK ((Age >= 18 AND Age < 150))",
+		},
+		{
+			TestName:       "Test put keeping its own UNIQUE value",
+			Inputs:         map[string]any{"query": "PUT Email = 'ada@example.com', Age = 37 TO Members WHERE Member_ID = 1"},
+			ExpectedOutput: 1,
+		},
+		{
+			TestName:       "Test dropping a column used by a CHECK",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "ALTER TABLE Members DROP Age"},
+			ExpectedOutput: "column Age cannot be dropped, as it is used by the CHECK of column Retired",
+		},
+	}
+
+	for _, test := range testTemplates {
+		t.Run(test.TestName, func(t *testing.T) {
+			result, queryErr := db.runQuery(test.Inputs["query"].(string))
+			if test.IsError {
+				if queryErr == nil || queryErr.Error() != test.ExpectedOutput.(string) {
+					t.Fatalf("error result was incorrect, got: %v, expected: %v", queryErr, test.ExpectedOutput)
+				}
+				return
+			}
+
+			if queryErr != nil {
+				t.Fatalf("unexpected error: %v", queryErr)
+			}
+
+			if result.RowsAffected != test.ExpectedOutput {
+				t.Fatalf("result was incorrect, got: %v, expected: %v", result.RowsAffected, test.ExpectedOutput)
+			}
+		})
+	}
+
+	if closeErr := db.Close(); closeErr != nil {
+		t.Fatalf("unexpected error: %v", closeErr)
+	}
+
+	t.Run("Test defaults and constraints are kept when the table is loaded again", func(t *testing.T) {
+		reloaded := DB{}
+		defer reloaded.Close()
+
+		result, queryErr := reloaded.runQuery("PULL Tier, Token, Joined FROM Members SORT BY Member_ID")
+		if queryErr != nil {
+			t.Fatalf("unexpected error: %v", queryErr)
+		}
+
+		if result.Rows[0][0] != "Basic" || result.Rows[1][0] != "Gold" {
+			t.Fatalf("literal defaults were incorrect, got: %v", result.Rows)
+		}
+
+		if token, isString := result.Rows[0][1].(string); !isString || len(token) != 36 || result.Rows[1][1] != nil {
+			t.Fatalf("uuid default was incorrect, got: %v", result.Rows)
+		}
+
+		if joined, isTime := result.Rows[0][2].(time.Time); !isTime || time.Since(joined) > time.Minute {
+			t.Fatalf("now default was incorrect, got: %v", result.Rows)
+		}
+
+		_, pushErr := reloaded.runQuery("PUSH Email = 'ada@example.com' TO Members")
+		if pushErr == nil || pushErr.Error() != "a row already exists in table Members with the value ada@example.com for UNIQUE column Email" {
+			t.Fatalf("error result was incorrect, got: %v", pushErr)
+		}
+
+		if _, renameErr := reloaded.runQuery("ALTER TABLE Members RENAME Age TO Years"); renameErr != nil {
+			t.Fatalf("unexpected error: %v", renameErr)
+		}
+
+		_, pushErr = reloaded.runQuery("PUSH Email = 'kid@example.com', Years = 12 TO Members")
+		if pushErr == nil || pushErr.Error() != "value 12 for column Years does not pass its CHECK ((Years >= 18 AND Years < 150))" {
+			t.Fatalf("error result was incorrect, got: %v", pushErr)
+		}
+
+		_, pushErr = reloaded.runQuery("PUSH Email = 'early@example.com', Years = 40, Retired = true TO Members")
+		if pushErr == nil || pushErr.Error() != "value true for column Retired does not pass its CHECK ((Retired = false OR Years >= 55))" {
+			t.Fatalf("error result was incorrect, got: %v", pushErr)
+		}
+	})
+
+	t.Run("Test a CHECK pattern with escapes is enforced the same once stored", func(t *testing.T) {
+		setup := DB{}
+		for _, query := range []string{
+			`CREATE TABLE Handles (Handle_ID int, Handle string CHECK (Handle ~ '^\\w+(''s)?$'), PRIMARY KEY Handle_ID AUTO)`,
+			"PUSH Handle = ada TO Handles",
+		} {
+			if _, queryErr := setup.runQuery(query); queryErr != nil {
+				t.Fatalf("unexpected error for %v: %v", query, queryErr)
+			}
+		}
+		if closeErr := setup.Close(); closeErr != nil {
+			t.Fatalf("unexpected error: %v", closeErr)
+		}
+
+		reloaded := DB{}
+		defer reloaded.Close()
+
+		// the pattern is checked once after loading it from file and again after it is rewritten by a rename
+		for _, columnName := range []string{"Handle", "Name"} {
+			if columnName != "Handle" {
+				if _, renameErr := reloaded.runQuery("ALTER TABLE Handles RENAME Handle TO " + columnName); renameErr != nil {
+					t.Fatalf("unexpected error: %v", renameErr)
+				}
+			}
+
+			for value, isAllowed := range map[string]bool{"grace": true, "grace''s": true, "w": true, "grace hopper": false, `\\w`: false} {
+				_, pushErr := reloaded.runQuery(fmt.Sprintf("PUSH %v = '%v' TO Handles", columnName, value))
+				if (pushErr == nil) != isAllowed {
+					t.Fatalf("result was incorrect for %v in column %v, got: %v, expected allowed: %v", value, columnName, pushErr, isAllowed)
+				}
+			}
+		}
+
+		tableIndex, _ := reloaded.getTable("Handles")
+		config, _ := reloaded.Tables[tableIndex].getColumnConfig("Name")
+		if expectedCheck := `Name ~ '^\\w+(''s)?$'`; config.Check != expectedCheck {
+			t.Fatalf("stored CHECK was incorrect, got: %v, expected: %v", config.Check, expectedCheck)
+		}
+	})
+}
+
+// test foreign keys are checked on PUSH and PUT, and deletes follow ON DELETE across tables loaded from the stores
+func Test_foreignKeys(t *testing.T) {
+	useTestStore(t)
+
+	db := DB{}
+	for _, query := range []string{
+		"CREATE TABLE Customers (Customer_ID int, Email string UNIQUE, PRIMARY KEY Customer_ID AUTO)",
+		"CREATE TABLE Orders (Order_ID int, Customer_ID int NOT NULL, PRIMARY KEY Order_ID AUTO, FOREIGN KEY Customer_ID REFERENCES Customers (Customer_ID) ON DELETE CASCADE)",
+		"CREATE TABLE Shipments (Shipment_ID int, Order_ID int NOT NULL, PRIMARY KEY Shipment_ID AUTO, FOREIGN KEY Order_ID REFERENCES Orders (Order_ID))",
+		"CREATE TABLE Reviews (Review_ID int, Author string, PRIMARY KEY Review_ID AUTO, FOREIGN KEY Author REFERENCES Customers (Email) ON DELETE SET NULL)",
+		"PUSH Email = 'ada@example.com' TO Customers",
+		"PUSH Email = 'alan@example.com' TO Customers",
+		"PUSH Customer_ID = 1 TO Orders",
+		"PUSH Customer_ID = 2 TO Orders",
+		"PUSH Customer_ID = 2 TO Orders",
+		"PUSH Order_ID = 3 TO Shipments",
+		"PUSH Author = 'ada@example.com' TO Reviews",
+		"PUSH Author = 'alan@example.com' TO Reviews",
+	} {
+		if _, queryErr := db.runQuery(query); queryErr != nil {
+			t.Fatalf("unexpected error for %v: %v", query, queryErr)
+		}
+	}
+
+	// Start again from the stores, so the delete has to load the tables referencing the one it changes
+	if closeErr := db.Close(); closeErr != nil {
+		t.Fatalf("unexpected error: %v", closeErr)
+	}
+	db = DB{}
+
+	testTemplates := []TestTemplate{
+		{
+			TestName:       "Test push referencing a missing row",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "PUSH Customer_ID = 9 TO Orders"},
+			ExpectedOutput: "no row exists in table Customers with Customer_ID = 9, as referenced by column Customer_ID",
+		},
+		{
+			TestName:       "Test put referencing a missing row",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "PUT Author = 'grace@example.com' TO Reviews WHERE Review_ID = 1"},
+			ExpectedOutput: "no row exists in table Customers with Email = grace@example.com, as referenced by column Author",
+		},
+		{
+			TestName:       "Test put changing a referenced value",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "PUT Customer_ID = 5 TO Customers WHERE Customer_ID = 1"},
+			ExpectedOutput: "rows in table Orders reference Customer_ID = 1 through column Customer_ID, so it can't be changed",
+		},
+		{
+			TestName:       "Test delete restricted through a cascade",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "DELETE FROM Customers WHERE Customer_ID = 2"},
+			ExpectedOutput: "rows in table Shipments reference the rows being deleted from table Orders through column Order_ID",
+		},
+		{
+			TestName:       "Test dropping a referenced table",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "DROP TABLE Orders"},
+			ExpectedOutput: "table Orders cannot be dropped, as table Shipments has a foreign key to it",
+		},
+		{
+			TestName:       "Test dropping a referenced column",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "ALTER TABLE Customers DROP Email"},
+			ExpectedOutput: "column Email is referenced by a foreign key in table Reviews",
+		},
+		{
+			TestName:       "Test delete cascading and setting null",
+			Inputs:         map[string]any{"query": "DELETE FROM Customers WHERE Customer_ID = 1"},
+			ExpectedOutput: 1,
+		},
+	}
+
+	for _, test := range testTemplates {
+		t.Run(test.TestName, func(t *testing.T) {
+			result, queryErr := db.runQuery(test.Inputs["query"].(string))
+			if test.IsError {
+				if queryErr == nil || queryErr.Error() != test.ExpectedOutput.(string) {
+					t.Fatalf("error result was incorrect, got: %v, expected: %v", queryErr, test.ExpectedOutput)
+				}
+				return
+			}
+
+			if queryErr != nil {
+				t.Fatalf("unexpected error: %v", queryErr)
+			}
+
+			if result.RowsAffected != test.ExpectedOutput {
+				t.Fatalf("result was incorrect, got: %v, expected: %v", result.RowsAffected, test.ExpectedOutput)
+			}
+		})
+	}
+
+	t.Run("Test every table was changed by the delete", func(t *testing.T) {
+		if closeErr := db.Close(); closeErr != nil {
+			t.Fatalf("unexpected error: %v", closeErr)
+		}
+
+		reloaded := DB{}
+		defer reloaded.Close()
+
+		for query, expectedRows := range map[string][][]any{
+			"PULL Customer_ID FROM Customers":                       {{2}},
+			"PULL Order_ID, Customer_ID FROM Orders":                {{2, 2}, {3, 2}},
+			"PULL Review_ID, Author FROM Reviews SORT BY Review_ID": {{1, nil}, {2, "alan@example.com"}},
+		} {
+			result, queryErr := reloaded.runQuery(query)
+			if queryErr != nil {
+				t.Fatalf("unexpected error: %v", queryErr)
+			}
+
+			if !reflect.DeepEqual(result.Rows, expectedRows) {
+				t.Fatalf("result was incorrect for %v, got: %v, expected: %v", query, result.Rows, expectedRows)
+			}
+		}
+	})
+
+	t.Run("Test dropping the referencing table first", func(t *testing.T) {
+		reloaded := DB{}
+		defer reloaded.Close()
+
+		for _, query := range []string{"DROP TABLE Shipments", "DROP TABLE Orders"} {
+			if _, queryErr := reloaded.runQuery(query); queryErr != nil {
+				t.Fatalf("unexpected error for %v: %v", query, queryErr)
+			}
+		}
+
+		tableIndex, _ := reloaded.getTable("Customers")
+		if referencedBy := reloaded.Tables[tableIndex].ReferencedBy; !reflect.DeepEqual(referencedBy, []string{"Reviews"}) {
+			t.Fatalf("referenced by was incorrect, got: %v", referencedBy)
+		}
+	})
+
+	t.Run("Test delete cascading through several tables", func(t *testing.T) {
+		setup := DB{}
+		for _, query := range []string{
+			"CREATE TABLE Authors (Author_ID int, PRIMARY KEY Author_ID AUTO)",
+			"CREATE TABLE Books (Book_ID int, Author_ID int, PRIMARY KEY Book_ID AUTO, FOREIGN KEY Author_ID REFERENCES Authors (Author_ID) ON DELETE CASCADE)",
+			"CREATE TABLE Chapters (Chapter_ID int, Book_ID int, PRIMARY KEY Chapter_ID AUTO, FOREIGN KEY Book_ID REFERENCES Books (Book_ID) ON DELETE CASCADE)",
+			"PUSH Author_ID = 1 TO Authors",
+			"PUSH Author_ID = 1 TO Books",
+			"PUSH Book_ID = 1 TO Chapters",
+		} {
+			if _, queryErr := setup.runQuery(query); queryErr != nil {
+				t.Fatalf("unexpected error for %v: %v", query, queryErr)
+			}
+		}
+		if closeErr := setup.Close(); closeErr != nil {
+			t.Fatalf("unexpected error: %v", closeErr)
+		}
+
+		// only the table the rows are deleted from is loaded, the rest have to be found through it
+		reloaded := DB{}
+		defer reloaded.Close()
+
+		if _, queryErr := reloaded.runQuery("DELETE FROM Authors WHERE Author_ID = 1"); queryErr != nil {
+			t.Fatalf("unexpected error: %v", queryErr)
+		}
+
+		result, queryErr := reloaded.runQuery("PULL Chapter_ID FROM Chapters")
+		if queryErr != nil {
+			t.Fatalf("unexpected error: %v", queryErr)
+		}
+
+		if len(result.Rows) != 0 {
+			t.Fatalf("result was incorrect, got: %v, expected no rows", result.Rows)
+		}
+	})
+}
+
+// test tables with the same name are kept apart in each database, and are listed in the catalog
+func Test_databases(t *testing.T) {
+	useTestStore(t)
+
+	db := DB{}
+	defer db.Close()
+
+	// Remove the catalog once the table is saved, so it has to be found again when the catalog is built from the stores
+	for _, query := range []string{
+		"CREATE TABLE Accounts (Account_ID int, Owner string NOT NULL, PRIMARY KEY Account_ID AUTO)",
+		"PUSH Owner = 'Grace' TO Accounts",
+		"PUSH Owner = 'Alan' TO Accounts",
+	} {
+		if _, queryErr := db.runQuery(query); queryErr != nil {
+			t.Fatalf("unexpected error for %v: %v", query, queryErr)
+		}
+	}
+
+	if closeErr := db.Close(); closeErr != nil {
+		t.Fatalf("unexpected error: %v", closeErr)
+	}
+
+	if removeErr := os.Remove(catalogPath); removeErr != nil {
+		t.Fatalf("unexpected error: %v", removeErr)
+	}
+
+	for _, query := range []string{
+		"CREATE DATABASE Sales",
+		"USE Sales",
+		"CREATE TABLE Accounts (Account_ID int, Owner string NOT NULL, Region string DEFAULT 'EU' CHECK (Region IN ('EU', 'US')), PRIMARY KEY Account_ID AUTO)",
+		"PUSH Owner = 'Ada' TO Accounts",
+	} {
+		if _, queryErr := db.runQuery(query); queryErr != nil {
+			t.Fatalf("unexpected error for %v: %v", query, queryErr)
+		}
+	}
+
+	testTemplates := []TestTemplate{
+		{
+			TestName: "Test show databases",
+			Inputs:   map[string]any{"query": "SHOW DATABASES"},
+			ExpectedOutput: [][]any{
+				{"default", 1},
+				{"Sales", 1},
+			},
+		},
+		{
+			TestName:       "Test show tables of the current database",
+			Inputs:         map[string]any{"query": "SHOW TABLES"},
+			ExpectedOutput: [][]any{{"Accounts", 3, "Account_ID"}},
+		},
+		{
+			TestName: "Test describe table",
+			Inputs:   map[string]any{"query": "DESCRIBE Accounts"},
+			ExpectedOutput: [][]any{
+				{"Account_ID", "int", false, "PRIMARY", "AUTO", nil},
+				{"Owner", "string", false, "", nil, nil},
+				{"Region", "string", true, "", "EU", "Region IN ('EU', 'US')"},
+			},
+		},
+		{
+			TestName:       "Test tables are kept apart in each database",
+			Inputs:         map[string]any{"query": "PULL Owner FROM Accounts"},
+			ExpectedOutput: [][]any{{"Ada"}},
+		},
+		{
+			TestName:       "Test creating a database twice",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "CREATE DATABASE Sales"},
+			ExpectedOutput: "a database already exists with the name: Sales",
+		},
+		{
+			TestName:       "Test using a missing database",
+			IsError:        true,
+			Inputs:         map[string]any{"query": "USE Billing"},
+			ExpectedOutput: "no database was found with the name: Billing",
+		},
+	}
+
+	for _, test := range testTemplates {
+		t.Run(test.TestName, func(t *testing.T) {
+			result, queryErr := db.runQuery(test.Inputs["query"].(string))
+			if test.IsError {
+				if queryErr == nil || queryErr.Error() != test.ExpectedOutput.(string) {
+					t.Fatalf("error result was incorrect, got: %v, expected: %v", queryErr, test.ExpectedOutput)
+				}
+				return
+			}
+
+			if queryErr != nil {
+				t.Fatalf("unexpected error: %v", queryErr)
+			}
+
+			if !reflect.DeepEqual(result.Rows, test.ExpectedOutput) {
+				t.Fatalf("result was incorrect, got: %v, expected: %v", result.Rows, test.ExpectedOutput)
+			}
+		})
+	}
+
+	t.Run("Test tables are saved in the folder of their database", func(t *testing.T) {
+		if closeErr := db.Close(); closeErr != nil {
+			t.Fatalf("unexpected error: %v", closeErr)
+		}
+
+		if _, statErr := os.Stat("stores/Sales/Accounts.dat"); statErr != nil {
+			t.Fatalf("unexpected error: %v", statErr)
+		}
+	})
+
+	t.Run("Test using the default database again", func(t *testing.T) {
+		if _, useErr := db.runQuery("USE default"); useErr != nil {
+			t.Fatalf("unexpected error: %v", useErr)
+		}
+
+		result, queryErr := db.runQuery("PULL Owner FROM Accounts SORT BY Account_ID")
+		if queryErr != nil {
+			t.Fatalf("unexpected error: %v", queryErr)
+		}
+
+		if expectedRows := [][]any{{"Grace"}, {"Alan"}}; !reflect.DeepEqual(result.Rows, expectedRows) {
+			t.Fatalf("result was incorrect, got: %v, expected: %v", result.Rows, expectedRows)
+		}
+	})
+
+	t.Run("Test dropped tables are removed from the catalog", func(t *testing.T) {
+		for _, query := range []string{"USE Sales", "DROP TABLE Accounts"} {
+			if _, queryErr := db.runQuery(query); queryErr != nil {
+				t.Fatalf("unexpected error for %v: %v", query, queryErr)
+			}
+		}
+
+		result, queryErr := db.runQuery("SHOW TABLES")
+		if queryErr != nil {
+			t.Fatalf("unexpected error: %v", queryErr)
+		}
+
+		if len(result.Rows) != 0 {
+			t.Fatalf("result was incorrect, got: %v", result.Rows)
+		}
+	})
+}
+
+// test changes are logged and replayed when a table is loaded again without being saved
+func Test_tableLog(t *testing.T) {
+	useTestStore(t)
+
+	db := DB{}
+	for _, query := range []string{
+		"CREATE TABLE Orders (Order_ID int, Customer string NOT NULL, Amount int, PRIMARY KEY Order_ID AUTO)",
+		"PUSH Customer = Alice, Amount = 10 TO Orders",
+		"PUSH Customer = Bob, Amount = 5 TO Orders",
+		"PUSH Customer = Carol TO Orders",
+		"PUT Amount = 7 TO Orders WHERE Customer = Bob",
+		"DELETE FROM Orders WHERE Customer = Alice",
+	} {
+		if _, queryErr := db.runQuery(query); queryErr != nil {
+			t.Fatalf("unexpected error for %v: %v", query, queryErr)
+		}
+	}
+
+	// stop without saving, as if the process had crashed
+	db.Tables[0].closeLog()
+
+	expectedRows := [][]any{{2, "Bob", 7}, {3, "Carol", nil}}
+
+	// loads the table into a fresh database and checks it holds the expected rows
+	checkRows := func(t *testing.T) DB {
+		reloaded := DB{}
+		result, queryErr := reloaded.runQuery("PULL Order_ID, Customer, Amount FROM Orders")
+		if queryErr != nil {
+			t.Fatalf("unexpected error: %v", queryErr)
+		}
+
+		if !reflect.DeepEqual(result.Rows, expectedRows) {
+			t.Fatalf("result was incorrect, got: %v, expected: %v", result.Rows, expectedRows)
+		}
+
+		return reloaded
+	}
+
+	t.Run("Test changes are replayed from the log", func(t *testing.T) {
+		checkRows(t)
+	})
+
+	t.Run("Test an incomplete record at the end of the log is dropped", func(t *testing.T) {
+		logFile, openErr := os.OpenFile(storePath(defaultDatabase, "Orders", "wal"), os.O_WRONLY|os.O_APPEND, 0755)
+		if openErr != nil {
+			t.Fatalf("unexpected error: %v", openErr)
+		}
+		logFile.Write([]byte{0, 0, 1, 0, 42})
+		logFile.Close()
+
+		reloaded := checkRows(t)
+		defer reloaded.Tables[0].closeLog()
+
+		// new changes go after the last complete record, rather than the partial one
+		if _, queryErr := reloaded.runQuery("PUSH Customer = Dave, Amount = 1 TO Orders"); queryErr != nil {
+			t.Fatalf("unexpected error: %v", queryErr)
+		}
+		expectedRows = append(expectedRows, []any{4, "Dave", 1})
+		checkRows(t)
+	})
+
+	t.Run("Test records already in the snapshot are skipped", func(t *testing.T) {
+		logContent, readErr := os.ReadFile(storePath(defaultDatabase, "Orders", "wal"))
+		if readErr != nil {
+			t.Fatalf("unexpected error: %v", readErr)
+		}
+
+		reloaded := checkRows(t)
+		if closeErr := reloaded.Close(); closeErr != nil {
+			t.Fatalf("unexpected error: %v", closeErr)
+		}
+
+		// put the old records back, as if the process stopped between saving the snapshot and emptying the log
+		if writeErr := os.WriteFile(storePath(defaultDatabase, "Orders", "wal"), logContent, 0755); writeErr != nil {
+			t.Fatalf("unexpected error: %v", writeErr)
+		}
+
+		checkRows(t)
+	})
+}
+
+func Test_transactions(t *testing.T) {
+	useTestStore(t)
+
+	db := DB{}
+	for _, query := range []string{
+		"CREATE TABLE Accounts (Account_ID int, Balance int NOT NULL, PRIMARY KEY Account_ID AUTO)",
+		"CREATE TABLE Transfers (Transfer_ID int, Amount int NOT NULL, PRIMARY KEY Transfer_ID AUTO)",
+		"PUSH Balance = 100 TO Accounts",
+		"PUSH Balance = 50 TO Accounts",
+	} {
+		if _, queryErr := db.runQuery(query); queryErr != nil {
+			t.Fatalf("unexpected error for %v: %v", query, queryErr)
+		}
+	}
+
+	// loads both tables into a fresh database and checks they hold the expected rows
+	checkRows := func(t *testing.T, expectedAccounts [][]any, expectedTransfers [][]any) {
+		reloaded := DB{}
+		defer func() {
+			for index := range reloaded.Tables {
+				reloaded.Tables[index].closeLog()
+			}
+		}()
+
+		for query, expectedRows := range map[string][][]any{
+			"PULL Account_ID, Balance FROM Accounts":  expectedAccounts,
+			"PULL Transfer_ID, Amount FROM Transfers": expectedTransfers,
+		} {
+			result, queryErr := reloaded.runQuery(query)
+			if queryErr != nil {
+				t.Fatalf("unexpected error: %v", queryErr)
+			}
+
+			if !reflect.DeepEqual(result.Rows, expectedRows) {
+				t.Fatalf("result of %v was incorrect, got: %v, expected: %v", query, result.Rows, expectedRows)
+			}
+		}
+	}
+
+	t.Run("Test a rollback leaves no changes", func(t *testing.T) {
+		for _, query := range []string{
+			"BEGIN",
+			"PUT Balance = 0 TO Accounts WHERE Account_ID = 1",
+			"PUSH Amount = 100 TO Transfers",
+			"ROLLBACK",
+		} {
+			if _, queryErr := db.runQuery(query); queryErr != nil {
+				t.Fatalf("unexpected error for %v: %v", query, queryErr)
+			}
+		}
+
+		result, queryErr := db.runQuery("PULL Account_ID, Balance FROM Accounts")
+		if queryErr != nil {
+			t.Fatalf("unexpected error: %v", queryErr)
+		}
+
+		if expectedRows := [][]any{{1, 100}, {2, 50}}; !reflect.DeepEqual(result.Rows, expectedRows) {
+			t.Fatalf("result was incorrect, got: %v, expected: %v", result.Rows, expectedRows)
+		}
+
+		checkRows(t, [][]any{{1, 100}, {2, 50}}, [][]any{})
+	})
+
+	t.Run("Test a commit is applied to every table", func(t *testing.T) {
+		tx, beginErr := db.Begin()
+		if beginErr != nil {
+			t.Fatalf("unexpected error: %v", beginErr)
+		}
+
+		for _, query := range []string{
+			"PUT Balance = 70 TO Accounts WHERE Account_ID = 1",
+			"PUT Balance = 80 TO Accounts WHERE Account_ID = 2",
+			"PUSH Amount = 30 TO Transfers",
+		} {
+			if _, queryErr := tx.runQuery(query); queryErr != nil {
+				t.Fatalf("unexpected error for %v: %v", query, queryErr)
+			}
+		}
+
+		// nothing is written to file until the transaction is committed
+		checkRows(t, [][]any{{1, 100}, {2, 50}}, [][]any{})
+
+		if commitErr := tx.Commit(); commitErr != nil {
+			t.Fatalf("unexpected error: %v", commitErr)
+		}
+
+		checkRows(t, [][]any{{1, 70}, {2, 80}}, [][]any{{1, 30}})
+	})
+
+	t.Run("Test a committed journal is recovered after a crash", func(t *testing.T) {
+		accountsIndex, _ := db.getTable("Accounts")
+		transfersIndex, _ := db.getTable("Transfers")
+
+		// write the journal without adding the changes to the table logs, as if the process stopped straight after committing
+		records := []transactionRecord{
+			{Table: "Accounts", Record: walRecord{Sequence: db.Tables[accountsIndex].LastSequence + 1, Operation: "PUT", Rows: []int{0}, Values: map[string]any{"Balance": 40}}},
+			{Table: "Transfers", Record: walRecord{Sequence: db.Tables[transfersIndex].LastSequence + 1, Operation: "PUSH", Row: map[string]any{"Transfer_ID": 2, "Amount": 30}, NextID: 3}},
+		}
+
+		content, marshalErr := json.Marshal(records)
+		if marshalErr != nil {
+			t.Fatalf("unexpected error: %v", marshalErr)
+		}
+
+		if writeErr := writeEncryptedFile(storePath(defaultDatabase, "transaction", "journal"), content); writeErr != nil {
+			t.Fatalf("unexpected error: %v", writeErr)
+		}
+
+		checkRows(t, [][]any{{1, 40}, {2, 80}}, [][]any{{1, 30}, {2, 30}})
+
+		if _, statErr := os.Stat(storePath(defaultDatabase, "transaction", "journal")); !errors.Is(statErr, fs.ErrNotExist) {
+			t.Fatalf("expected the journal to be removed once recovered, got: %v", statErr)
+		}
+
+		// the recovered changes were added to the table logs, so are still there without the journal
+		checkRows(t, [][]any{{1, 40}, {2, 80}}, [][]any{{1, 30}, {2, 30}})
+	})
+
+	t.Run("Test transaction statements are checked", func(t *testing.T) {
+		if _, queryErr := db.runQuery("COMMIT"); queryErr == nil {
+			t.Fatalf("expected an error for COMMIT without a transaction")
+		}
+
+		if _, beginErr := db.Begin(); beginErr != nil {
+			t.Fatalf("unexpected error: %v", beginErr)
+		}
+		defer db.runQuery("ROLLBACK")
+
+		if _, queryErr := db.runQuery("BEGIN TRANSACTION"); queryErr == nil {
+			t.Fatalf("expected an error for BEGIN within a transaction")
+		}
+
+		if _, queryErr := db.runQuery("DROP TABLE Transfers"); queryErr == nil {
+			t.Fatalf("expected an error for DROP TABLE within a transaction")
+		}
+	})
+
+	for index := range db.Tables {
+		db.Tables[index].closeLog()
+	}
+
+	t.Run("Test the database can't be closed with uncommitted changes", func(t *testing.T) {
+		txDB := DB{}
+		for _, query := range []string{"BEGIN", "PUT Balance = 0 TO Accounts WHERE Account_ID = 1"} {
+			if _, queryErr := txDB.runQuery(query); queryErr != nil {
+				t.Fatalf("unexpected error for %v: %v", query, queryErr)
+			}
+		}
+
+		if closeErr := txDB.Close(); closeErr == nil {
+			t.Fatalf("expected an error closing the database within a transaction")
+		}
+
+		if _, queryErr := txDB.runQuery("ROLLBACK"); queryErr != nil {
+			t.Fatalf("unexpected error: %v", queryErr)
+		}
+
+		// closing saves the tables, which have to still hold the rows from before the transaction
+		if closeErr := txDB.Close(); closeErr != nil {
+			t.Fatalf("unexpected error: %v", closeErr)
+		}
+
+		checkRows(t, [][]any{{1, 40}, {2, 80}}, [][]any{{1, 30}, {2, 30}})
+	})
+}
+
+// test indexes give the same rows as a full scan, are kept up to date and are saved next to the table
+func Test_indexes(t *testing.T) {
+	useTestStore(t)
+
+	db := DB{}
+	defer db.Close()
+
+	queries := []string{"CREATE TABLE Orders (Order_ID int, Customer string NOT NULL, Amount int, PRIMARY KEY Order_ID AUTO)"}
+	for orderIndex := 0; orderIndex < 200; orderIndex++ {
+		if orderIndex%10 == 0 {
+			queries = append(queries, fmt.Sprintf("PUSH Customer = c%v TO Orders", orderIndex%7))
+			continue
+		}
+
+		queries = append(queries, fmt.Sprintf("PUSH Customer = c%v, Amount = %v TO Orders", orderIndex%7, (orderIndex*37)%101))
+	}
+
+	for _, query := range queries {
+		if _, queryErr := db.runQuery(query); queryErr != nil {
+			t.Fatalf("unexpected error for %v: %v", query, queryErr)
+		}
+	}
+
+	// runs a query against the table with and without its indexes, checking both give the same rows
+	checkQuery := func(t *testing.T, db *DB, query string, expectIndexed bool) []int {
+		tableIndex, _ := db.getTable("Orders")
+		table := &db.Tables[tableIndex]
+
+		parsedQuery, parseErr := queryBreakdown(query)
+		if parseErr != nil {
+			t.Fatalf("unexpected error: %v", parseErr)
+		}
+
+		if _, isIndexed := table.indexedCandidates(parsedQuery.WhereClause); isIndexed != expectIndexed {
+			t.Fatalf("index use for %v was incorrect, got: %v, expected: %v", query, isIndexed, expectIndexed)
+		}
+
+		indexedRows, indexedErr := table.selectRows(parsedQuery)
+		if indexedErr != nil {
+			t.Fatalf("unexpected error: %v", indexedErr)
+		}
+
+		unindexed := table.copyTable()
+		unindexed.indexes = nil
+		unindexed.PrimaryKeyColumnName = ""
+		scannedRows, scannedErr := unindexed.selectRows(parsedQuery)
+		if scannedErr != nil {
+			t.Fatalf("unexpected error: %v", scannedErr)
+		}
+
+		if !reflect.DeepEqual(indexedRows, scannedRows) {
+			t.Fatalf("indexed rows for %v were incorrect, got: %v, expected: %v", query, indexedRows, scannedRows)
+		}
+
+		orderIDs := []int{}
+		for _, row := range indexedRows {
+			orderIDs = append(orderIDs, row.ColumnValues["Order_ID"].(int))
+		}
+
+		return orderIDs
+	}
+
+	for _, query := range []string{
+		"CREATE INDEX Orders_By_Customer ON Orders (Customer)",
+		"CREATE INDEX Orders_By_Amount ON Orders (Amount) USING ORDERED",
+	} {
+		if _, queryErr := db.runQuery(query); queryErr != nil {
+			t.Fatalf("unexpected error for %v: %v", query, queryErr)
+		}
+	}
+
+	testTemplates := []TestTemplate{
+		{TestName: "Test hash index equality", Inputs: map[string]any{"query": "PULL * FROM Orders WHERE Customer = c3", "indexed": true}},
+		{TestName: "Test hash index IN", Inputs: map[string]any{"query": "PULL * FROM Orders WHERE Customer IN (c1, c5) AND Amount > 20", "indexed": true}},
+		{TestName: "Test ordered index range", Inputs: map[string]any{"query": "PULL * FROM Orders WHERE Amount >= 40 AND Amount < 60", "indexed": true}},
+		{TestName: "Test ordered index with the literal first", Inputs: map[string]any{"query": "PULL * FROM Orders WHERE 90 < Amount", "indexed": true}},
+		{TestName: "Test ordered index between", Inputs: map[string]any{"query": "PULL * FROM Orders WHERE Amount BETWEEN 10 AND 12", "indexed": true}},
+		{TestName: "Test ordered index sort", Inputs: map[string]any{"query": "PULL * FROM Orders SORT BY Amount DESC LIMIT 30", "indexed": false}},
+		{TestName: "Test ordered index sort with a where clause", Inputs: map[string]any{"query": "PULL * FROM Orders WHERE Customer = c2 SORT BY Amount", "indexed": true}},
+		{TestName: "Test unindexed column", Inputs: map[string]any{"query": "PULL * FROM Orders WHERE Amount != 5", "indexed": false}},
+		{TestName: "Test OR can't use an index", Inputs: map[string]any{"query": "PULL * FROM Orders WHERE Customer = c1 OR Amount = 5", "indexed": false}},
+	}
+
+	for _, test := range testTemplates {
+		t.Run(test.TestName, func(t *testing.T) {
+			checkQuery(t, &db, test.Inputs["query"].(string), test.Inputs["indexed"].(bool))
+		})
+	}
+
+	t.Run("Test indexes follow changes to the rows", func(t *testing.T) {
+		for _, query := range []string{
+			"PUT Amount = 1000 TO Orders WHERE Customer = c4",
+			"DELETE FROM Orders WHERE Amount < 30",
+			"PUSH Customer = c4, Amount = 1001 TO Orders",
+		} {
+			if _, queryErr := db.runQuery(query); queryErr != nil {
+				t.Fatalf("unexpected error for %v: %v", query, queryErr)
+			}
+		}
+
+		checkQuery(t, &db, "PULL * FROM Orders WHERE Amount >= 1000", true)
+		checkQuery(t, &db, "PULL * FROM Orders WHERE Customer = c4", true)
+	})
+
+	t.Run("Test indexes are saved and reloaded", func(t *testing.T) {
+		if saveErr := db.saveTables(); saveErr != nil {
+			t.Fatalf("unexpected error: %v", saveErr)
+		}
+
+		reloaded := DB{}
+		defer reloaded.Close()
+		if loadErr := reloaded.loadTable("Orders"); loadErr != nil {
+			t.Fatalf("unexpected error: %v", loadErr)
+		}
+
+		// the saved entries are reused, rather than rebuilt from the rows
+		table := &reloaded.Tables[0]
+		if len(table.indexes) != 2 || !table.indexes[0].isBuilt() || !table.indexes[1].isBuilt() {
+			t.Fatalf("indexes were not loaded, got: %+v", table.indexes)
+		}
+
+		expectedIDs := checkQuery(t, &db, "PULL * FROM Orders WHERE Amount BETWEEN 50 AND 70 SORT BY Amount", true)
+		if orderIDs := checkQuery(t, &reloaded, "PULL * FROM Orders WHERE Amount BETWEEN 50 AND 70 SORT BY Amount", true); !reflect.DeepEqual(orderIDs, expectedIDs) {
+			t.Fatalf("result was incorrect, got: %v, expected: %v", orderIDs, expectedIDs)
+		}
+
+		if _, queryErr := reloaded.runQuery("DROP INDEX Orders_By_Amount ON Orders"); queryErr != nil {
+			t.Fatalf("unexpected error: %v", queryErr)
+		}
+
+		checkQuery(t, &reloaded, "PULL * FROM Orders WHERE Amount > 50", false)
+	})
+}
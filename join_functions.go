@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Returned when an unqualified column name is found in more than one joined table
+type AmbiguousColumnError struct {
+	ColumnName string
+}
+
+func (e *AmbiguousColumnError) Error() string {
+	return fmt.Sprintf("column name %v is ambiguous, qualify it with a table name or alias", e.ColumnName)
+}
+
+// Checks if a PULL query reads from more than one table, or names its table with an alias
+func (query *DBQuery) isJoined() bool {
+	return len(query.Joins) > 0 || query.TableAlias != ""
+}
+
+// Returns every table a query reads from, starting with the table after FROM
+func (query *DBQuery) tableNames() []string {
+	names := []string{query.TableName}
+	for _, join := range query.Joins {
+		names = append(names, join.Table)
+	}
+
+	return names
+}
+
+// Joins the tables of a PULL query together into a single table, ready for the query to be run against
+// ** Every column is named <alias>.<column>, or <table>.<column> when the table has no alias, so same named columns don't collide
+func (db *DB) joinTables(query DBQuery) (DBTable, error) {
+	baseTable, baseErr := db.qualifiedTable(query.TableName, query.TableAlias, false)
+	if baseErr != nil {
+		return DBTable{}, baseErr
+	}
+
+	qualifiers := []string{qualifierName(query.TableName, query.TableAlias)}
+	joined := baseTable
+
+	for _, join := range query.Joins {
+		qualifier := qualifierName(join.Table, join.Alias)
+		if Contains(qualifiers, qualifier) {
+			return DBTable{}, fmt.Errorf("table name or alias %v is used more than once, give each table a different alias", qualifier)
+		}
+		qualifiers = append(qualifiers, qualifier)
+
+		rightTable, rightErr := db.qualifiedTable(join.Table, join.Alias, join.Type == "LEFT")
+		if rightErr != nil {
+			return DBTable{}, rightErr
+		}
+
+		var joinErr error
+		joined, joinErr = joinTablePair(joined, rightTable, join)
+		if joinErr != nil {
+			return DBTable{}, joinErr
+		}
+	}
+
+	joined.Name = strings.Join(qualifiers, ", ")
+	return joined, nil
+}
+
+// Returns the alias used to qualify the columns of a table
+func qualifierName(tableName string, alias string) string {
+	if alias != "" {
+		return alias
+	}
+
+	return tableName
+}
+
+// Copies a table in memory with every column name qualified by the table name or alias
+// ** The right hand table of a LEFT join can be padded with nulls, so all its columns are made nullable
+func (db *DB) qualifiedTable(tableName string, alias string, nullable bool) (DBTable, error) {
+	tableIndex, tableErr := db.getTable(tableName)
+	if tableErr != nil {
+		return DBTable{}, tableErr
+	}
+
+	table := db.Tables[tableIndex]
+	qualifier := qualifierName(tableName, alias)
+
+	qualified := DBTable{
+		Name:         qualifier,
+		ColumnConfig: []ColumnConfig{},
+		RowValues:    []RowValue{},
+	}
+
+	for _, config := range table.ColumnConfig {
+		qualified.ColumnConfig = append(qualified.ColumnConfig, ColumnConfig{
+			ColumnName: qualifier + "." + config.ColumnName,
+			ColumnType: config.ColumnType,
+			Nullable:   config.Nullable || nullable,
+		})
+	}
+
+	for _, row := range table.RowValues {
+		qualifiedRow := RowValue{ColumnValues: map[string]any{}}
+		for _, config := range table.ColumnConfig {
+			qualifiedRow.ColumnValues[qualifier+"."+config.ColumnName] = row.ColumnValues[config.ColumnName]
+		}
+
+		qualified.RowValues = append(qualified.RowValues, qualifiedRow)
+	}
+
+	return qualified, nil
+}
+
+// Joins two qualified tables together, checking the ON condition against every pair of rows
+func joinTablePair(left DBTable, right DBTable, join JoinClause) (DBTable, error) {
+	joined := DBTable{
+		Name:         left.Name + ", " + right.Name,
+		ColumnConfig: append(append([]ColumnConfig{}, left.ColumnConfig...), right.ColumnConfig...),
+		RowValues:    []RowValue{},
+	}
+
+	for _, leftRow := range left.RowValues {
+		matched := false
+
+		for _, rightRow := range right.RowValues {
+			row := mergeRows(leftRow, rightRow)
+
+			if join.Type != "CROSS" {
+				isMatch, matchErr := joined.rowMatches(row, join.On)
+				if matchErr != nil {
+					return DBTable{}, fmt.Errorf("failed to join table %v: %w", join.Table, matchErr)
+				}
+
+				if !isMatch {
+					continue
+				}
+			}
+
+			joined.RowValues = append(joined.RowValues, row)
+			matched = true
+		}
+
+		// LEFT joins keep every row of the left table, even when nothing on the right matches it
+		if !matched && join.Type == "LEFT" {
+			row := mergeRows(leftRow, RowValue{ColumnValues: map[string]any{}})
+			for _, config := range right.ColumnConfig {
+				row.ColumnValues[config.ColumnName] = nil
+			}
+
+			joined.RowValues = append(joined.RowValues, row)
+		}
+	}
+
+	return joined, nil
+}
+
+// Combines the values of two rows into a new row
+func mergeRows(left RowValue, right RowValue) RowValue {
+	merged := RowValue{ColumnValues: map[string]any{}}
+	for key, value := range left.ColumnValues {
+		merged.ColumnValues[key] = value
+	}
+
+	for key, value := range right.ColumnValues {
+		merged.ColumnValues[key] = value
+	}
+
+	return merged
+}
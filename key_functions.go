@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Marks content sealed with a key from the keyring, it is followed by the 4 byte ID of the key
+// ** Content written before there was a keyring has no tag, and was sealed with the first key
+var keyTag = []byte("UTK1")
+
+// Size of the key tag and key ID written before sealed content
+const keyTagSize = 8
+
+// Version of the key file format written by writeKeyring, key files holding just a key from before the keyring are version 0
+const keyFileVersion = 1
+
+// Size in bytes of every encryption key, for AES-256
+const encryptionKeySize = 32
+
+// Folders holding every file sealed with the encryption key
+var sealedFolders = []string{"stores", "system"}
+
+// Every encryption key that may still be needed to read a file, the active key is used for everything written
+// ** The keyring only holds more than one key while a rotation is unfinished
+type Keyring struct {
+	Version  int
+	ActiveID int
+	Keys     []KeyringKey
+}
+
+type KeyringKey struct {
+	ID      int
+	Key     []byte
+	Created time.Time `json:",omitempty"`
+}
+
+// The outcome of a key rotation
+type KeyRotation struct {
+	KeyID   int  // the key every file is now sealed with
+	Resumed bool // whether an unfinished rotation was carried on, rather than a new key made
+	Files   int  // number of files that were sealed again
+}
+
+// Reads the keyring from the key file, a key file holding a single key from before there was a keyring is given the ID 1
+// ** Keyrings saved before the format was versioned are read as version 0, the same as a single key
+func parseKeyring(content []byte) (Keyring, error) {
+	if !bytes.HasPrefix(content, []byte("{")) {
+		if len(content) != encryptionKeySize {
+			return Keyring{}, fmt.Errorf("expected a keyring or a %v byte key, but found %v bytes", encryptionKeySize, len(content))
+		}
+
+		return Keyring{ActiveID: 1, Keys: []KeyringKey{{ID: 1, Key: content}}}, nil
+	}
+
+	keyring := Keyring{}
+	unmarshalErr := json.Unmarshal(content, &keyring)
+	if unmarshalErr != nil {
+		return Keyring{}, fmt.Errorf("failed to read the keyring: %w", unmarshalErr)
+	}
+
+	if keyring.Version > keyFileVersion {
+		return Keyring{}, fmt.Errorf("the keyring is version %v, but only versions up to %v can be read", keyring.Version, keyFileVersion)
+	}
+
+	for _, key := range keyring.Keys {
+		if len(key.Key) != encryptionKeySize {
+			return Keyring{}, fmt.Errorf("key %v is %v bytes, expected %v", key.ID, len(key.Key), encryptionKeySize)
+		}
+	}
+
+	return keyring, nil
+}
+
+// Reads the keyring, creating the key file first if there isn't one
+func readKeyring(keyFilePath string) (Keyring, error) {
+	ekErr := generateEncryptionKey(keyFilePath)
+	if ekErr != nil {
+		return Keyring{}, ekErr
+	}
+
+	content, readErr := os.ReadFile(keyFilePath)
+	if readErr != nil {
+		return Keyring{}, readErr
+	}
+
+	return parseKeyring(content)
+}
+
+// Saves the keyring to the key file
+func writeKeyring(keyFilePath string, keyring Keyring) error {
+	keyring.Version = keyFileVersion
+	content, marshalErr := json.Marshal(keyring)
+	if marshalErr != nil {
+		return fmt.Errorf("failed to convert the keyring for saving: %w", marshalErr)
+	}
+
+	return writeFileAtomic(keyFilePath, content, 0755)
+}
+
+// Returns the key with the ID given
+func (keyring Keyring) key(keyID int) ([]byte, error) {
+	keyIndex := slices.IndexFunc(keyring.Keys, func(key KeyringKey) bool { return key.ID == keyID })
+	if keyIndex == -1 {
+		return nil, fmt.Errorf("key %v is not in the keyring", keyID)
+	}
+
+	return keyring.Keys[keyIndex].Key, nil
+}
+
+// Returns the ID of the key content was sealed with, along with the encrypted content after the tag
+func sealedKeyID(content []byte) (int, []byte) {
+	if len(content) < keyTagSize || !bytes.HasPrefix(content, keyTag) {
+		return 1, content
+	}
+
+	return int(binary.BigEndian.Uint32(content[len(keyTag):keyTagSize])), content[keyTagSize:]
+}
+
+// Encrypts content with the active key, tagging it with the ID of the key
+func (keyring Keyring) seal(content []byte) ([]byte, error) {
+	activeKey, keyErr := keyring.key(keyring.ActiveID)
+	if keyErr != nil {
+		return nil, keyErr
+	}
+
+	encryptedContent, encryptErr := encrpytData(activeKey, content, nil)
+	if encryptErr != nil {
+		return nil, encryptErr
+	}
+
+	sealed := make([]byte, 0, keyTagSize+len(encryptedContent))
+	sealed = append(sealed, keyTag...)
+	sealed = binary.BigEndian.AppendUint32(sealed, uint32(keyring.ActiveID))
+
+	return append(sealed, encryptedContent...), nil
+}
+
+// Decrypts content with the key it was sealed with
+func (keyring Keyring) open(content []byte) ([]byte, error) {
+	keyID, encryptedContent := sealedKeyID(content)
+
+	key, keyErr := keyring.key(keyID)
+	if keyErr != nil {
+		return nil, fmt.Errorf("the content was sealed with a key that is no longer available: %w", keyErr)
+	}
+
+	return decryptData(key, encryptedContent, nil)
+}
+
+// Encrypts content with the active key of the master keyring
+func sealData(content []byte) ([]byte, error) {
+	keyring, keyringErr := masterKeyring()
+	if keyringErr != nil {
+		return nil, keyringErr
+	}
+
+	return keyring.seal(content)
+}
+
+// Decrypts content with the key of the master keyring it was sealed with
+func openData(content []byte) ([]byte, error) {
+	keyring, keyringErr := masterKeyring()
+	if keyringErr != nil {
+		return nil, keyringErr
+	}
+
+	return keyring.open(content)
+}
+
+// Makes a new key active and seals every file in the stores and system folders with it, then removes the old keys
+// ** The new key is saved before any file is changed and the old keys are only removed once every file is done, so an
+// interrupted rotation is carried on by running it again. Tables and audit logs open in another process have to be closed first,
+// as their logs are replaced. Only a provider that keeps its own keys, like the key file, can save the new key
+func rotateEncryptionKey(provider KeyProvider) (KeyRotation, error) {
+	saver, canSave := provider.(keyringSaver)
+	if !canSave {
+		return KeyRotation{}, fmt.Errorf("the key can't be rotated, as the key provider can't save a new key")
+	}
+
+	keyring, keyringErr := provider.Keyring()
+	if keyringErr != nil {
+		return KeyRotation{}, keyringErr
+	}
+
+	rotation := KeyRotation{Resumed: len(keyring.Keys) > 1}
+	if !rotation.Resumed {
+		key, keyErr := newEncryptionKey()
+		if keyErr != nil {
+			return KeyRotation{}, keyErr
+		}
+
+		newKey := KeyringKey{ID: keyring.ActiveID + 1, Key: key, Created: time.Now().UTC()}
+		keyring.Keys = append(keyring.Keys, newKey)
+		keyring.ActiveID = newKey.ID
+
+		writeErr := saver.saveKeyring(keyring)
+		if writeErr != nil {
+			return KeyRotation{}, fmt.Errorf("failed to save the new key: %w", writeErr)
+		}
+	}
+
+	rotation.KeyID = keyring.ActiveID
+
+	for _, folder := range sealedFolders {
+		walkErr := filepath.WalkDir(folder, func(path string, entry fs.DirEntry, walkErr error) error {
+			if errors.Is(walkErr, fs.ErrNotExist) && path == folder {
+				return fs.SkipDir
+			} else if walkErr != nil {
+				return walkErr
+			}
+
+			// Temp files are left behind by writes that never finished, so are never read
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				return nil
+			}
+
+			isResealed, resealErr := keyring.resealFile(path)
+			if resealErr != nil {
+				return fmt.Errorf("failed to seal %v with the new key: %w", path, resealErr)
+			}
+
+			if isResealed {
+				rotation.Files = rotation.Files + 1
+			}
+
+			return nil
+		})
+
+		if walkErr != nil {
+			return rotation, walkErr
+		}
+	}
+
+	keyring.Keys = slices.DeleteFunc(keyring.Keys, func(key KeyringKey) bool { return key.ID != keyring.ActiveID })
+	writeErr := saver.saveKeyring(keyring)
+	if writeErr != nil {
+		return rotation, fmt.Errorf("failed to remove the old keys: %w", writeErr)
+	}
+
+	return rotation, nil
+}
+
+// Seals a file again with the active key, returning false if it was already sealed with it
+// ** Logs are sealed a frame at a time, so each frame is sealed again and the log is replaced as a whole. Files sealed with a
+// data key only have the data key in their header wrapped again, and anything else sealed with the data key is left alone
+func (keyring Keyring) resealFile(path string) (bool, error) {
+	content, readErr := os.ReadFile(path)
+	if readErr != nil {
+		return false, readErr
+	}
+
+	if envelopeHeaderSize(content) > 0 {
+		rewrapped, isRewrapped, rewrapErr := keyring.rewrapEnvelope(content)
+		if rewrapErr != nil || !isRewrapped {
+			return false, rewrapErr
+		}
+
+		return true, writeFileAtomic(path, rewrapped, 0755)
+	}
+
+	sealedParts := [][]byte{content}
+	isLog := filepath.Ext(path) == ".wal" || filepath.Ext(path) == ".log"
+	if isLog {
+		sealedParts, _ = splitLogFrames(content)
+	}
+
+	isSealed := true
+	for _, part := range sealedParts {
+		if keyID, _ := sealedKeyID(part); keyID != keyring.ActiveID && !bytes.HasPrefix(part, dataKeyTag) {
+			isSealed = false
+		}
+	}
+
+	if isSealed || len(content) == 0 {
+		return false, nil
+	}
+
+	resealed := []byte{}
+	for _, part := range sealedParts {
+		// A log record sealed with a data key is kept as it is, it's only reached here when older frames in the same log aren't
+		if bytes.HasPrefix(part, dataKeyTag) {
+			if isLog {
+				part = logFrame(part)
+			}
+
+			resealed = append(resealed, part...)
+			continue
+		}
+
+		decryptedPart, openErr := keyring.open(part)
+		if openErr != nil {
+			return false, openErr
+		}
+
+		sealedPart, sealErr := keyring.seal(decryptedPart)
+		if sealErr != nil {
+			return false, sealErr
+		}
+
+		if isLog {
+			sealedPart = logFrame(sealedPart)
+		}
+
+		resealed = append(resealed, sealedPart...)
+	}
+
+	return true, writeFileAtomic(path, resealed, 0755)
+}
+
+// Rotates the encryption key, see rotateEncryptionKey, which only a user with every permission of the Root Admin role can do
+// ** The tables of the database are saved and closed first and the audit log is closed while the key is rotated, as their logs
+// are replaced. Tables open in any other DB have to be closed too
+func (db *DB) RotateEncryptionKey() (KeyRotation, error) {
+	if db.System == nil || !db.System.confirmRootAdmin(db.User) {
+		return KeyRotation{}, fmt.Errorf("only a Root Admin can rotate the encryption key")
+	}
+
+	if db.tx != nil {
+		return KeyRotation{}, fmt.Errorf("the encryption key cannot be rotated within a transaction")
+	}
+
+	closeErr := db.Close()
+	if closeErr != nil {
+		return KeyRotation{}, closeErr
+	}
+	db.Tables = []DBTable{}
+
+	auditCloseErr := db.System.closeAuditLog()
+	if auditCloseErr != nil {
+		return KeyRotation{}, auditCloseErr
+	}
+
+	provider, providerErr := masterKeyProvider()
+	if providerErr != nil {
+		return KeyRotation{}, providerErr
+	}
+
+	rotation, rotateErr := rotateEncryptionKey(provider)
+
+	// The rotation is recorded even when it fails, so it's clear it has to be run again
+	auditOpenErr := db.System.openAuditLog()
+	if auditOpenErr != nil {
+		return rotation, errors.Join(rotateErr, auditOpenErr)
+	}
+
+	auditErr := db.System.recordAudit(db.User.Username, "ROTATE KEY", "", fmt.Sprintf("key %v", rotation.KeyID), rotateErr)
+	return rotation, errors.Join(rotateErr, auditErr)
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// returns the ID of the key each sealed file, or each frame of a log, was sealed with
+// ** for a file sealed with a data key this is the key its data key was wrapped with, content sealed with a data key is skipped
+func sealedKeyIDs(t *testing.T) map[string][]int {
+	keyIDs := map[string][]int{}
+
+	for _, folder := range sealedFolders {
+		walkErr := filepath.WalkDir(folder, func(path string, entry fs.DirEntry, walkErr error) error {
+			if walkErr != nil || entry.IsDir() {
+				return walkErr
+			}
+
+			content, readErr := os.ReadFile(path)
+			if readErr != nil || len(content) == 0 {
+				return readErr
+			}
+
+			parts := [][]byte{content}
+			if sealed, isEnvelope, _ := splitEnvelope(content); isEnvelope {
+				parts = [][]byte{sealed.wrappedKey}
+			} else if filepath.Ext(path) == ".wal" || filepath.Ext(path) == ".log" {
+				parts, _ = splitLogFrames(content)
+			}
+
+			for _, part := range parts {
+				if bytes.HasPrefix(part, dataKeyTag) {
+					continue
+				}
+
+				keyID, _ := sealedKeyID(part)
+				keyIDs[path] = append(keyIDs[path], keyID)
+			}
+
+			return nil
+		})
+
+		if walkErr != nil {
+			t.Fatalf("unexpected error: %v", walkErr)
+		}
+	}
+
+	return keyIDs
+}
+
+// checks every sealed file was sealed with the key given
+func confirmSealedWith(t *testing.T, expectedKeyID int) {
+	for path, keyIDs := range sealedKeyIDs(t) {
+		for _, keyID := range keyIDs {
+			if keyID != expectedKeyID {
+				t.Fatalf("%v was sealed with key %v, expected: %v", path, keyID, expectedKeyID)
+			}
+		}
+	}
+}
+
+// test a missing key file is created as a keyring, and older key files are saved again in the current format
+func Test_generateEncryptionKey(t *testing.T) {
+	testTemplates := []TestTemplate{
+		{
+			TestName:       "Test missing key file",
+			Inputs:         map[string]any{"content": nil},
+			ExpectedOutput: 1,
+		},
+		{
+			TestName:       "Test key file holding just a key",
+			Inputs:         map[string]any{"content": []byte("abcdefghijklmnopqrstuvwxyzABCDEF")},
+			ExpectedOutput: 1,
+		},
+		{
+			TestName:       "Test keyring from before the format was versioned",
+			Inputs:         map[string]any{"content": []byte(`{"ActiveID":2,"Keys":[{"ID":2,"Key":"YWJjZGVmZ2hpamtsbW5vcHFyc3R1dnd4eXpBQkNERUY="}]}`)},
+			ExpectedOutput: 2,
+		},
+		{
+			TestName:       "Test key that is too short",
+			IsError:        true,
+			Inputs:         map[string]any{"content": []byte("abcdef")},
+			ExpectedOutput: "failed to read the key file keys/main.dat: expected a keyring or a 32 byte key, but found 6 bytes",
+		},
+		{
+			TestName:       "Test keyring from a newer version",
+			IsError:        true,
+			Inputs:         map[string]any{"content": []byte(`{"Version":2,"ActiveID":1,"Keys":[]}`)},
+			ExpectedOutput: "failed to read the key file keys/main.dat: the keyring is version 2, but only versions up to 1 can be read",
+		},
+	}
+
+	for _, test := range testTemplates {
+		t.Run(test.TestName, func(t *testing.T) {
+			useTestStore(t)
+
+			if content, hasContent := test.Inputs["content"].([]byte); hasContent {
+				if writeErr := os.WriteFile(keyPath, content, 0600); writeErr != nil {
+					t.Fatalf("unexpected error: %v", writeErr)
+				}
+			} else if removeErr := os.Remove(keyPath); removeErr != nil {
+				t.Fatalf("unexpected error: %v", removeErr)
+			}
+
+			keyErr := generateEncryptionKey(keyPath)
+			if test.IsError {
+				if keyErr == nil || keyErr.Error() != test.ExpectedOutput.(string) {
+					t.Fatalf("error result was incorrect, got: %v, expected: %v", keyErr, test.ExpectedOutput)
+				}
+				return
+			}
+
+			if keyErr != nil {
+				t.Fatalf("unexpected error: %v", keyErr)
+			}
+
+			content, _ := os.ReadFile(keyPath)
+			keyring, keyringErr := parseKeyring(content)
+			if keyringErr != nil {
+				t.Fatalf("unexpected error: %v", keyringErr)
+			}
+
+			if keyring.Version != keyFileVersion || keyring.ActiveID != test.ExpectedOutput || len(keyring.Keys) != 1 {
+				t.Fatalf("result was incorrect, got: %+v, expected version %v with active key %v", keyring, keyFileVersion, test.ExpectedOutput)
+			}
+
+			if original, _ := test.Inputs["content"].([]byte); original != nil && len(original) == encryptionKeySize && !bytes.Equal(keyring.Keys[0].Key, original) {
+				t.Fatalf("the key was changed when the key file was saved again, got: %v", keyring.Keys[0].Key)
+			}
+		})
+	}
+
+	t.Run("Test new keys are random and the full key size", func(t *testing.T) {
+		firstKey, firstErr := newEncryptionKey()
+		secondKey, secondErr := newEncryptionKey()
+		if firstErr != nil || secondErr != nil {
+			t.Fatalf("unexpected error: %v", errors.Join(firstErr, secondErr))
+		}
+
+		if len(firstKey) != encryptionKeySize || bytes.Equal(firstKey, secondKey) {
+			t.Fatalf("keys were incorrect, got: %v and %v", firstKey, secondKey)
+		}
+	})
+}
+
+// test every store and system file is sealed with the new key, and an interrupted rotation is carried on
+func Test_rotateEncryptionKey(t *testing.T) {
+	useTestStore(t)
+
+	db := DB{}
+	for _, query := range []string{
+		"CREATE TABLE Orders (Order_ID int, Amount int, PRIMARY KEY Order_ID AUTO)",
+		"CREATE INDEX Orders_By_Amount ON Orders (Amount)",
+		"PUSH Amount = 10 TO Orders",
+		"PUSH Amount = 20 TO Orders",
+	} {
+		if _, queryErr := db.runQuery(query); queryErr != nil {
+			t.Fatalf("unexpected error for %v: %v", query, queryErr)
+		}
+	}
+
+	// Leave the pushed rows in the write-ahead log, rather than saving them to the .dat file
+	tableIndex, _ := db.getTable("Orders")
+	if closeErr := db.Tables[tableIndex].closeLog(); closeErr != nil {
+		t.Fatalf("unexpected error: %v", closeErr)
+	}
+
+	systemDB := SystemDB{}
+	if openErr := systemDB.openAuditLog(); openErr != nil {
+		t.Fatalf("unexpected error: %v", openErr)
+	}
+
+	if auditErr := systemDB.recordChange("CREATE USER", "admin", ""); auditErr != nil {
+		t.Fatalf("unexpected error: %v", auditErr)
+	}
+	systemDB.closeAuditLog()
+
+	// checks the rows and audit entries can still be read once the key has been rotated
+	confirmReadable := func(t *testing.T) {
+		reloaded := DB{}
+		defer reloaded.Close()
+
+		result, queryErr := reloaded.runQuery("PULL Amount FROM Orders SORT BY Order_ID")
+		if queryErr != nil {
+			t.Fatalf("unexpected error: %v", queryErr)
+		}
+
+		if expectedRows := [][]any{{10}, {20}}; !reflect.DeepEqual(result.Rows, expectedRows) {
+			t.Fatalf("result was incorrect, got: %v, expected: %v", result.Rows, expectedRows)
+		}
+
+		if actions := auditActions(t, AuditFilter{}); !reflect.DeepEqual(actions, []string{"system CREATE USER admin"}) {
+			t.Fatalf("audit log was incorrect, got: %v", actions)
+		}
+	}
+
+	t.Run("Test every file is sealed with the new key", func(t *testing.T) {
+		rotation, rotateErr := rotateEncryptionKey(&fileKeyProvider{path: keyPath})
+		if rotateErr != nil {
+			t.Fatalf("unexpected error: %v", rotateErr)
+		}
+
+		// the .dat and catalog of the table, along with the audit log and generations, the .idx and .wal are sealed with the data key
+		// of the table
+		if expected := (KeyRotation{KeyID: 2, Files: 4}); rotation != expected {
+			t.Fatalf("result was incorrect, got: %+v, expected: %+v", rotation, expected)
+		}
+
+		confirmSealedWith(t, 2)
+		confirmReadable(t)
+
+		keyring, keyringErr := readKeyring(keyPath)
+		if keyringErr != nil {
+			t.Fatalf("unexpected error: %v", keyringErr)
+		}
+
+		if keyring.ActiveID != 2 || len(keyring.Keys) != 1 {
+			t.Fatalf("old keys were not removed from the keyring, got: %+v", keyring)
+		}
+	})
+
+	t.Run("Test an interrupted rotation is carried on", func(t *testing.T) {
+		keyring, _ := readKeyring(keyPath)
+		keyring.Keys = append(keyring.Keys, KeyringKey{ID: 3, Key: []byte("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdef")})
+		keyring.ActiveID = 3
+		if writeErr := writeKeyring(keyPath, keyring); writeErr != nil {
+			t.Fatalf("unexpected error: %v", writeErr)
+		}
+
+		// one file was sealed with the new key before the rotation stopped
+		catalogContent, _ := os.ReadFile(catalogPath)
+		decryptedCatalog, _ := keyring.open(catalogContent)
+		if writeErr := writeEncryptedFile(catalogPath, decryptedCatalog); writeErr != nil {
+			t.Fatalf("unexpected error: %v", writeErr)
+		}
+
+		rotation, rotateErr := rotateEncryptionKey(&fileKeyProvider{path: keyPath})
+		if rotateErr != nil {
+			t.Fatalf("unexpected error: %v", rotateErr)
+		}
+
+		// only the data key in the .dat, the audit log and generations are left
+		if expected := (KeyRotation{KeyID: 3, Resumed: true, Files: 3}); rotation != expected {
+			t.Fatalf("result was incorrect, got: %+v, expected: %+v", rotation, expected)
+		}
+
+		confirmSealedWith(t, 3)
+		confirmReadable(t)
+	})
+}
+
+// test a rotation from the command line is added to the audit log under the user of the operating system
+func Test_runRotateKeyCommand(t *testing.T) {
+	useTestStore(t)
+
+	if rotateErr := runRotateKeyCommand(nil); rotateErr != nil {
+		t.Fatalf("unexpected error: %v", rotateErr)
+	}
+
+	entries, readErr := readAuditLog(AuditFilter{})
+	if readErr != nil {
+		t.Fatalf("unexpected error: %v", readErr)
+	}
+
+	if len(entries) != 1 || entries[0].Action.ActionType != "ROTATE KEY" {
+		t.Fatalf("audit log was incorrect, got: %+v", entries)
+	}
+
+	if blame := entries[0].Blame; blame != commandLineBlame() || blame == systemBlame || !strings.HasPrefix(blame, commandLineBlamePrefix) {
+		t.Fatalf("rotation was not blamed on the user of the operating system, got: %v", blame)
+	}
+}
+
+// test a store holding envelopes from before generations, alongside current ones, can have its key rotated
+func Test_rotateMixedEnvelopes(t *testing.T) {
+	useTestStore(t)
+
+	db := DB{}
+	for _, query := range []string{
+		"CREATE TABLE Orders (Order_ID int, Amount int, PRIMARY KEY Order_ID AUTO)",
+		"CREATE TABLE Refunds (Refund_ID int, Amount int, PRIMARY KEY Refund_ID AUTO)",
+	} {
+		if _, queryErr := db.runQuery(query); queryErr != nil {
+			t.Fatalf("unexpected error for %v: %v", query, queryErr)
+		}
+	}
+	db.Close()
+
+	// save Refunds as a version 1 envelope, with its content sealed by its data key but not bound to the file
+	refundsPath := storePath(defaultDatabase, "Refunds", "dat")
+	content, _ := os.ReadFile(refundsPath)
+	decryptedContent, key, _, openErr := openEnvelope(content, tableIdentity(defaultDatabase, "Refunds"))
+	if openErr != nil {
+		t.Fatalf("unexpected error: %v", openErr)
+	}
+
+	sealed, _, _ := splitEnvelope(content)
+	sealed.version = 1
+	sealed.body, _ = key.seal(decryptedContent)
+	if writeErr := os.WriteFile(refundsPath, sealed.bytes(), 0755); writeErr != nil {
+		t.Fatalf("unexpected error: %v", writeErr)
+	}
+
+	if _, rotateErr := rotateEncryptionKey(&fileKeyProvider{path: keyPath}); rotateErr != nil {
+		t.Fatalf("unexpected error: %v", rotateErr)
+	}
+
+	confirmSealedWith(t, 2)
+
+	rotatedContent, _ := os.ReadFile(refundsPath)
+	if !bytes.HasPrefix(rotatedContent, unboundEnvelopeTag) {
+		t.Fatalf("the version 1 envelope was not kept as it was")
+	}
+
+	if rotatedRefunds, _, _, openErr := openEnvelope(rotatedContent, tableIdentity(defaultDatabase, "Refunds")); openErr != nil || !bytes.Equal(rotatedRefunds, decryptedContent) {
+		t.Fatalf("the version 1 envelope could not be opened after the rotation: %v", openErr)
+	}
+}
+
+// test only a Root Admin can rotate the key through a database, and the rotation is audited
+func Test_RotateEncryptionKey(t *testing.T) {
+	useTestStore(t)
+
+	systemDB := SystemDB{}
+	if openErr := systemDB.openAuditLog(); openErr != nil {
+		t.Fatalf("unexpected error: %v", openErr)
+	}
+	defer systemDB.closeAuditLog()
+
+	systemDB.createBasePolicies()
+	if rolesErr := systemDB.createBaseRoles(); rolesErr != nil {
+		t.Fatalf("unexpected error: %v", rolesErr)
+	}
+
+	admin, _ := systemDB.createUser("admin", "admin")
+	adminRole, _ := systemDB.findRoleByName("Root Admin")
+	if assignErr := systemDB.assignUserToRole(admin, adminRole); assignErr != nil {
+		t.Fatalf("unexpected error: %v", assignErr)
+	}
+
+	writer, _ := systemDB.createUser("writer", "writer")
+	writerRole, _ := systemDB.findRoleByName("Root Writer")
+	if assignErr := systemDB.assignUserToRole(writer, writerRole); assignErr != nil {
+		t.Fatalf("unexpected error: %v", assignErr)
+	}
+
+	adminDB := DB{System: &systemDB, User: admin}
+	defer adminDB.Close()
+	for _, query := range []string{
+		"CREATE TABLE Orders (Order_ID int, Amount int, PRIMARY KEY Order_ID AUTO)",
+		"PUSH Amount = 10 TO Orders",
+	} {
+		if _, queryErr := adminDB.runQuery(query); queryErr != nil {
+			t.Fatalf("unexpected error for %v: %v", query, queryErr)
+		}
+	}
+
+	t.Run("Test a user without every admin permission is refused", func(t *testing.T) {
+		writerDB := DB{System: &systemDB, User: writer}
+		_, rotateErr := writerDB.RotateEncryptionKey()
+		if rotateErr == nil || rotateErr.Error() != "only a Root Admin can rotate the encryption key" {
+			t.Fatalf("error result was incorrect, got: %v", rotateErr)
+		}
+	})
+
+	t.Run("Test a Root Admin can rotate the key with tables open", func(t *testing.T) {
+		rotation, rotateErr := adminDB.RotateEncryptionKey()
+		if rotateErr != nil {
+			t.Fatalf("unexpected error: %v", rotateErr)
+		}
+
+		if rotation.KeyID != 2 {
+			t.Fatalf("result was incorrect, got: %+v", rotation)
+		}
+
+		confirmSealedWith(t, 2)
+
+		if _, queryErr := adminDB.runQuery("PUSH Amount = 20 TO Orders"); queryErr != nil {
+			t.Fatalf("unexpected error: %v", queryErr)
+		}
+
+		result, queryErr := adminDB.runQuery("PULL Amount FROM Orders SORT BY Order_ID")
+		if queryErr != nil {
+			t.Fatalf("unexpected error: %v", queryErr)
+		}
+
+		if expectedRows := [][]any{{10}, {20}}; !reflect.DeepEqual(result.Rows, expectedRows) {
+			t.Fatalf("result was incorrect, got: %v, expected: %v", result.Rows, expectedRows)
+		}
+
+		actions := auditActions(t, AuditFilter{Action: "ROTATE KEY"})
+		if !reflect.DeepEqual(actions, []string{"admin ROTATE KEY"}) {
+			t.Fatalf("audit log was incorrect, got: %v", actions)
+		}
+	})
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// Path of the config file, the master key is kept in keys/main.dat when there isn't one
+const configPath = "untold.config"
+
+// Number of PBKDF2 iterations used to stretch a passphrase when the config doesn't give one
+const defaultPassphraseIterations = 600000
+
+// Size in bytes of the salt made for a passphrase the first time it's used
+const passphraseSaltSize = 16
+
+// Settings read from the config file
+type Config struct {
+	KeyProvider KeyProviderConfig
+}
+
+// Where the master key comes from, the fields used depend on the type of provider
+type KeyProviderConfig struct {
+	Type       string   // file (the default), env, passphrase or command
+	Path       string   `json:",omitempty"` // file: the key file, keys/main.dat by default
+	Variable   string   `json:",omitempty"` // env: the variable holding the base64 key, passphrase: the variable holding the passphrase
+	KeyID      int      `json:",omitempty"` // env, passphrase and command: the ID files are tagged with, 1 by default
+	SaltPath   string   `json:",omitempty"` // passphrase: the file holding the salt, keys/passphrase.salt by default
+	Iterations int      `json:",omitempty"` // passphrase: the number of PBKDF2 iterations
+	Command    []string `json:",omitempty"` // command: the helper and its arguments, get is added as the last argument
+}
+
+// A source of the master keys used to seal every file
+type KeyProvider interface {
+	// Returns the keyring holding every key that may be needed to open a file, the active key is used to seal them
+	Keyring() (Keyring, error)
+}
+
+// Implemented by providers that keep their own keys, so can save the new key made when the key is rotated
+type keyringSaver interface {
+	saveKeyring(keyring Keyring) error
+}
+
+// Reads the keyring from a key file, creating it the first time it's used
+type fileKeyProvider struct {
+	path string
+}
+
+// Reads a single base64 key from an environment variable
+type envKeyProvider struct {
+	variable string
+	keyID    int
+}
+
+// Stretches a passphrase into the key with PBKDF2, the passphrase is only asked for once
+type passphraseKeyProvider struct {
+	variable   string
+	saltPath   string
+	iterations int
+	keyID      int
+	once       sync.Once
+	keyring    Keyring
+	keyringErr error
+}
+
+// Asks an external helper for the key, in the style of a git credential helper, the helper is only run once
+// ** The helper is run with get as its last argument, and writes lines of key=<base64 key> and optionally id=<key ID>
+type commandKeyProvider struct {
+	command    []string
+	keyID      int
+	once       sync.Once
+	keyring    Keyring
+	keyringErr error
+}
+
+// The provider chosen by the config file, loaded the first time a key is needed
+var masterKeys struct {
+	sync.Mutex
+	provider KeyProvider
+}
+
+// Returns the key provider chosen by the config file
+func masterKeyProvider() (KeyProvider, error) {
+	masterKeys.Lock()
+	defer masterKeys.Unlock()
+
+	if masterKeys.provider != nil {
+		return masterKeys.provider, nil
+	}
+
+	provider, providerErr := loadKeyProvider(configPath)
+	if providerErr != nil {
+		return nil, providerErr
+	}
+
+	masterKeys.provider = provider
+	return provider, nil
+}
+
+// Returns the keyring of the key provider chosen by the config file
+func masterKeyring() (Keyring, error) {
+	provider, providerErr := masterKeyProvider()
+	if providerErr != nil {
+		return Keyring{}, providerErr
+	}
+
+	return provider.Keyring()
+}
+
+// Reads the config file and builds the key provider it chooses, keys/main.dat is used when there's no config file
+func loadKeyProvider(path string) (KeyProvider, error) {
+	config := Config{}
+
+	content, readErr := os.ReadFile(path)
+	if readErr != nil && !errors.Is(readErr, fs.ErrNotExist) {
+		return nil, readErr
+	} else if readErr == nil {
+		unmarshalErr := json.Unmarshal(content, &config)
+		if unmarshalErr != nil {
+			return nil, fmt.Errorf("failed to read the config file %v: %w", path, unmarshalErr)
+		}
+	}
+
+	return config.KeyProvider.provider()
+}
+
+// Builds the key provider described by the config, filling in the defaults of any fields left out
+func (config KeyProviderConfig) provider() (KeyProvider, error) {
+	keyID := config.KeyID
+	if keyID == 0 {
+		keyID = 1
+	}
+
+	switch config.Type {
+	case "", "file":
+		if config.Path == "" {
+			config.Path = keyPath
+		}
+
+		return &fileKeyProvider{path: config.Path}, nil
+
+	case "env":
+		if config.Variable == "" {
+			return nil, fmt.Errorf("the env key provider needs the Variable holding the key")
+		}
+
+		return &envKeyProvider{variable: config.Variable, keyID: keyID}, nil
+
+	case "passphrase":
+		if config.SaltPath == "" {
+			config.SaltPath = "keys/passphrase.salt"
+		}
+
+		if config.Iterations == 0 {
+			config.Iterations = defaultPassphraseIterations
+		}
+
+		return &passphraseKeyProvider{variable: config.Variable, saltPath: config.SaltPath, iterations: config.Iterations, keyID: keyID}, nil
+
+	case "command":
+		if len(config.Command) == 0 {
+			return nil, fmt.Errorf("the command key provider needs the Command of the helper to run")
+		}
+
+		return &commandKeyProvider{command: config.Command, keyID: keyID}, nil
+	}
+
+	return nil, fmt.Errorf("%v is not a key provider, expected one of file, env, passphrase or command", config.Type)
+}
+
+// Returns a keyring holding a single key
+func singleKeyring(keyID int, key []byte) (Keyring, error) {
+	if len(key) != encryptionKeySize {
+		return Keyring{}, fmt.Errorf("the key is %v bytes, expected %v", len(key), encryptionKeySize)
+	}
+
+	return Keyring{Version: keyFileVersion, ActiveID: keyID, Keys: []KeyringKey{{ID: keyID, Key: key}}}, nil
+}
+
+func (provider *fileKeyProvider) Keyring() (Keyring, error) {
+	return readKeyring(provider.path)
+}
+
+func (provider *fileKeyProvider) saveKeyring(keyring Keyring) error {
+	return writeKeyring(provider.path, keyring)
+}
+
+func (provider *envKeyProvider) Keyring() (Keyring, error) {
+	encodedKey, isSet := os.LookupEnv(provider.variable)
+	if !isSet {
+		return Keyring{}, fmt.Errorf("the environment variable %v holding the key is not set", provider.variable)
+	}
+
+	key, decodeErr := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+	if decodeErr != nil {
+		return Keyring{}, fmt.Errorf("the environment variable %v does not hold a base64 key: %w", provider.variable, decodeErr)
+	}
+
+	return singleKeyring(provider.keyID, key)
+}
+
+func (provider *passphraseKeyProvider) Keyring() (Keyring, error) {
+	provider.once.Do(func() {
+		passphrase, passphraseErr := provider.passphrase()
+		if passphraseErr != nil {
+			provider.keyringErr = passphraseErr
+			return
+		}
+
+		salt, saltErr := readSalt(provider.saltPath)
+		if saltErr != nil {
+			provider.keyringErr = saltErr
+			return
+		}
+
+		provider.keyring, provider.keyringErr = singleKeyring(provider.keyID, pbkdf2Key(passphrase, salt, provider.iterations, encryptionKeySize))
+	})
+
+	return provider.keyring, provider.keyringErr
+}
+
+// Returns the passphrase from its environment variable, asking for it on the terminal when the variable isn't set
+func (provider *passphraseKeyProvider) passphrase() ([]byte, error) {
+	if passphrase, isSet := os.LookupEnv(provider.variable); provider.variable != "" && isSet {
+		return []byte(passphrase), nil
+	}
+
+	fmt.Println("Enter the passphrase for the master key:")
+	passphrase, readErr := readSecret()
+	if readErr != nil {
+		return nil, fmt.Errorf("no passphrase was given for the master key: %w", readErr)
+	}
+
+	return passphrase, nil
+}
+
+// Reads a line from stdin without echoing it when stdin is a terminal, falling back to a plain read when it isn't
+func readSecret() ([]byte, error) {
+	if descriptor := int(os.Stdin.Fd()); term.IsTerminal(descriptor) {
+		secret, readErr := term.ReadPassword(descriptor)
+		fmt.Println()
+		return secret, readErr
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	if !scanner.Scan() {
+		return nil, errors.Join(fmt.Errorf("stdin ended before a line was read"), scanner.Err())
+	}
+
+	return slices.Clone(scanner.Bytes()), nil
+}
+
+// Reads the salt a passphrase is stretched with, making a random one the first time it's used
+// ** Losing the salt loses the key as much as losing the passphrase does, so it's kept with the keys
+func readSalt(path string) ([]byte, error) {
+	salt, readErr := os.ReadFile(path)
+	if readErr == nil {
+		return salt, nil
+	} else if !errors.Is(readErr, fs.ErrNotExist) {
+		return nil, readErr
+	}
+
+	salt = make([]byte, passphraseSaltSize)
+	if _, randErr := rand.Read(salt); randErr != nil {
+		return nil, fmt.Errorf("failed to generate a salt for the passphrase: %w", randErr)
+	}
+
+	return salt, writeFileAtomic(path, salt, 0600)
+}
+
+// Stretches a passphrase into a key with PBKDF2-HMAC-SHA256, as described in RFC 8018
+func pbkdf2Key(passphrase []byte, salt []byte, iterations int, keyLength int) []byte {
+	mac := hmac.New(sha256.New, passphrase)
+	key := []byte{}
+
+	for block := uint32(1); len(key) < keyLength; block++ {
+		mac.Reset()
+		mac.Write(salt)
+		mac.Write(binary.BigEndian.AppendUint32(nil, block))
+		sum := mac.Sum(nil)
+		blockKey := slices.Clone(sum)
+
+		for range iterations - 1 {
+			mac.Reset()
+			mac.Write(sum)
+			sum = mac.Sum(sum[:0])
+
+			for index := range blockKey {
+				blockKey[index] ^= sum[index]
+			}
+		}
+
+		key = append(key, blockKey...)
+	}
+
+	return key[:keyLength]
+}
+
+func (provider *commandKeyProvider) Keyring() (Keyring, error) {
+	provider.once.Do(func() {
+		command := exec.Command(provider.command[0], append(slices.Clone(provider.command[1:]), "get")...)
+		command.Stdin = os.Stdin
+		command.Stderr = os.Stderr
+
+		output, runErr := command.Output()
+		if runErr != nil {
+			provider.keyringErr = fmt.Errorf("the key helper %v failed: %w", provider.command[0], runErr)
+			return
+		}
+
+		provider.keyring, provider.keyringErr = parseHelperOutput(output, provider.keyID)
+	})
+
+	return provider.keyring, provider.keyringErr
+}
+
+// Reads the key=<base64 key> and id=<key ID> lines written by a key helper, other lines are ignored
+func parseHelperOutput(output []byte, keyID int) (Keyring, error) {
+	var key []byte
+
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		name, value, isPair := strings.Cut(strings.TrimSpace(string(line)), "=")
+		if !isPair {
+			continue
+		}
+
+		switch name {
+		case "key":
+			decodedKey, decodeErr := base64.StdEncoding.DecodeString(value)
+			if decodeErr != nil {
+				return Keyring{}, fmt.Errorf("the key helper did not give a base64 key: %w", decodeErr)
+			}
+
+			key = decodedKey
+		case "id":
+			parsedID, parseErr := strconv.Atoi(value)
+			if parseErr != nil {
+				return Keyring{}, fmt.Errorf("the key helper gave an invalid key ID %v", value)
+			}
+
+			keyID = parsedID
+		}
+	}
+
+	if key == nil {
+		return Keyring{}, fmt.Errorf("the key helper did not give a key")
+	}
+
+	return singleKeyring(keyID, key)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// test the key provider is chosen by the config file, with the key file used when there isn't one
+func Test_loadKeyProvider(t *testing.T) {
+	testTemplates := []TestTemplate{
+		{
+			TestName:       "Test no config file",
+			Inputs:         map[string]any{"config": ""},
+			ExpectedOutput: &fileKeyProvider{path: keyPath},
+		},
+		{
+			TestName:       "Test env provider",
+			Inputs:         map[string]any{"config": `{"KeyProvider": {"Type": "env", "Variable": "UNTOLD_KEY", "KeyID": 3}}`},
+			ExpectedOutput: &envKeyProvider{variable: "UNTOLD_KEY", keyID: 3},
+		},
+		{
+			TestName:       "Test passphrase provider defaults",
+			Inputs:         map[string]any{"config": `{"KeyProvider": {"Type": "passphrase"}}`},
+			ExpectedOutput: &passphraseKeyProvider{saltPath: "keys/passphrase.salt", iterations: defaultPassphraseIterations, keyID: 1},
+		},
+		{
+			TestName:       "Test command provider",
+			Inputs:         map[string]any{"config": `{"KeyProvider": {"Type": "command", "Command": ["untold-key-helper", "--vault", "prod"]}}`},
+			ExpectedOutput: &commandKeyProvider{command: []string{"untold-key-helper", "--vault", "prod"}, keyID: 1},
+		},
+		{
+			TestName:       "Test env provider without a variable",
+			IsError:        true,
+			Inputs:         map[string]any{"config": `{"KeyProvider": {"Type": "env"}}`},
+			ExpectedOutput: "the env key provider needs the Variable holding the key",
+		},
+		{
+			TestName:       "Test unknown provider",
+			IsError:        true,
+			Inputs:         map[string]any{"config": `{"KeyProvider": {"Type": "vault"}}`},
+			ExpectedOutput: "vault is not a key provider, expected one of file, env, passphrase or command",
+		},
+	}
+
+	for _, test := range testTemplates {
+		t.Run(test.TestName, func(t *testing.T) {
+			useTestStore(t)
+
+			if config := test.Inputs["config"].(string); config != "" {
+				if writeErr := os.WriteFile(configPath, []byte(config), 0600); writeErr != nil {
+					t.Fatalf("unexpected error: %v", writeErr)
+				}
+			}
+
+			provider, providerErr := loadKeyProvider(configPath)
+			if test.IsError {
+				if providerErr == nil || providerErr.Error() != test.ExpectedOutput.(string) {
+					t.Fatalf("error result was incorrect, got: %v, expected: %v", providerErr, test.ExpectedOutput)
+				}
+				return
+			}
+
+			if providerErr != nil {
+				t.Fatalf("unexpected error: %v", providerErr)
+			}
+
+			if !reflect.DeepEqual(provider, test.ExpectedOutput) {
+				t.Fatalf("result was incorrect, got: %#v, expected: %#v", provider, test.ExpectedOutput)
+			}
+		})
+	}
+}
+
+// test each provider gives the key from its own source
+func Test_keyProviders(t *testing.T) {
+	useTestStore(t)
+
+	key := []byte("0123456789abcdef0123456789ABCDEF")
+	encodedKey := base64.StdEncoding.EncodeToString(key)
+
+	t.Run("Test env provider", func(t *testing.T) {
+		t.Setenv("UNTOLD_TEST_KEY", encodedKey)
+
+		keyring, keyringErr := (&envKeyProvider{variable: "UNTOLD_TEST_KEY", keyID: 2}).Keyring()
+		if keyringErr != nil {
+			t.Fatalf("unexpected error: %v", keyringErr)
+		}
+
+		if activeKey, _ := keyring.key(2); keyring.ActiveID != 2 || !bytes.Equal(activeKey, key) {
+			t.Fatalf("result was incorrect, got: %+v", keyring)
+		}
+	})
+
+	t.Run("Test env provider without its variable", func(t *testing.T) {
+		_, keyringErr := (&envKeyProvider{variable: "UNTOLD_TEST_MISSING_KEY", keyID: 1}).Keyring()
+		if keyringErr == nil || keyringErr.Error() != "the environment variable UNTOLD_TEST_MISSING_KEY holding the key is not set" {
+			t.Fatalf("error result was incorrect, got: %v", keyringErr)
+		}
+	})
+
+	t.Run("Test passphrase provider keeps its salt", func(t *testing.T) {
+		t.Setenv("UNTOLD_TEST_PASSPHRASE", "correct horse battery staple")
+
+		keys := [][]byte{}
+		for range 2 {
+			keyring, keyringErr := (&passphraseKeyProvider{variable: "UNTOLD_TEST_PASSPHRASE", saltPath: "keys/passphrase.salt", iterations: 10, keyID: 1}).Keyring()
+			if keyringErr != nil {
+				t.Fatalf("unexpected error: %v", keyringErr)
+			}
+
+			activeKey, _ := keyring.key(1)
+			keys = append(keys, activeKey)
+		}
+
+		salt, _ := os.ReadFile("keys/passphrase.salt")
+		expectedKey := pbkdf2Key([]byte("correct horse battery staple"), salt, 10, encryptionKeySize)
+		if len(salt) != passphraseSaltSize || !bytes.Equal(keys[0], expectedKey) || !bytes.Equal(keys[1], expectedKey) {
+			t.Fatalf("result was incorrect, got: %v, expected: %v", keys, expectedKey)
+		}
+	})
+
+	t.Run("Test command provider", func(t *testing.T) {
+		provider := &commandKeyProvider{command: []string{"sh", "-c", `[ "$1" = get ] && echo "key=` + encodedKey + `" && echo id=4`, "helper"}, keyID: 1}
+
+		keyring, keyringErr := provider.Keyring()
+		if keyringErr != nil {
+			t.Fatalf("unexpected error: %v", keyringErr)
+		}
+
+		if activeKey, _ := keyring.key(4); keyring.ActiveID != 4 || !bytes.Equal(activeKey, key) {
+			t.Fatalf("result was incorrect, got: %+v", keyring)
+		}
+	})
+
+	t.Run("Test command provider without a key", func(t *testing.T) {
+		_, keyringErr := (&commandKeyProvider{command: []string{"sh", "-c", "echo id=4"}, keyID: 1}).Keyring()
+		if keyringErr == nil || keyringErr.Error() != "the key helper did not give a key" {
+			t.Fatalf("error result was incorrect, got: %v", keyringErr)
+		}
+	})
+
+	t.Run("Test rotating a key the provider can't save", func(t *testing.T) {
+		_, rotateErr := rotateEncryptionKey(&envKeyProvider{variable: "UNTOLD_TEST_KEY", keyID: 1})
+		if rotateErr == nil || rotateErr.Error() != "the key can't be rotated, as the key provider can't save a new key" {
+			t.Fatalf("error result was incorrect, got: %v", rotateErr)
+		}
+	})
+}
+
+// test PBKDF2 against the PBKDF2-HMAC-SHA256 vectors of RFC 7914
+func Test_pbkdf2Key(t *testing.T) {
+	testTemplates := []TestTemplate{
+		{
+			TestName:       "Test single iteration",
+			Inputs:         map[string]any{"passphrase": "passwd", "salt": "salt", "iterations": 1},
+			ExpectedOutput: "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783",
+		},
+		{
+			TestName:       "Test many iterations",
+			Inputs:         map[string]any{"passphrase": "Password", "salt": "NaCl", "iterations": 80000},
+			ExpectedOutput: "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d",
+		},
+	}
+
+	for _, test := range testTemplates {
+		t.Run(test.TestName, func(t *testing.T) {
+			key := pbkdf2Key([]byte(test.Inputs["passphrase"].(string)), []byte(test.Inputs["salt"].(string)), test.Inputs["iterations"].(int), 64)
+			if hex.EncodeToString(key) != test.ExpectedOutput {
+				t.Fatalf("result was incorrect, got: %x, expected: %v", key, test.ExpectedOutput)
+			}
+		})
+	}
+}
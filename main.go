@@ -2,9 +2,12 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"time"
 )
 
 func readTerminalInput() (string, error) {
@@ -48,9 +51,10 @@ func initialiseRootAccount() {
 }
 
 func initSystem() (SystemDB, error) {
-	generateEcryptionKeyErr := generateEncryptionKey(keyPath)
-	if generateEcryptionKeyErr != nil {
-		log.Fatal("encryption key could not be generated")
+	// The key is read once at startup, so a passphrase or key helper is asked for it straight away
+	_, keyringErr := masterKeyring()
+	if keyringErr != nil {
+		log.Fatalf("the encryption key could not be loaded: %v", keyringErr)
 	}
 
 	system := SystemDB{
@@ -64,7 +68,102 @@ func initSystem() (SystemDB, error) {
 	return system, nil
 }
 
+// Prints the audit log entries that match the filters given on the command line
+// ** untold audit [-user <username>] [-table <table>] [-action <action>] [-from <time>] [-to <time>], times are RFC 3339
+func runAuditCommand(args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	filter := AuditFilter{}
+	from := flags.String("from", "", "only show entries from this time, e.g. 2024-01-02T15:04:05Z")
+	to := flags.String("to", "", "only show entries up to this time, e.g. 2024-01-02T15:04:05Z")
+	flags.StringVar(&filter.Username, "user", "", "only show entries blamed on this user")
+	flags.StringVar(&filter.Table, "table", "", "only show entries for this table")
+	flags.StringVar(&filter.Action, "action", "", "only show entries for this action, e.g. PULL or CREATE USER")
+
+	parseErr := flags.Parse(args)
+	if parseErr != nil {
+		return parseErr
+	}
+
+	for _, bound := range []struct {
+		text   string
+		target *time.Time
+	}{{*from, &filter.From}, {*to, &filter.To}} {
+		if bound.text == "" {
+			continue
+		}
+
+		boundTime, timeErr := time.Parse(time.RFC3339, bound.text)
+		if timeErr != nil {
+			return fmt.Errorf("%v is not a valid time, expected a time like 2024-01-02T15:04:05Z", bound.text)
+		}
+
+		*bound.target = boundTime
+	}
+
+	entries, auditErr := readAuditLog(filter)
+	if auditErr != nil {
+		return auditErr
+	}
+
+	printResultSet(auditResultSet(entries))
+	return nil
+}
+
+// Rotates the encryption key, sealing every file in the stores and system folders with a new key
+// ** untold rotate-key, the database must not be running while the key is rotated. An interrupted rotation is carried on by running it again
+func runRotateKeyCommand(args []string) error {
+	flags := flag.NewFlagSet("rotate-key", flag.ContinueOnError)
+	parseErr := flags.Parse(args)
+	if parseErr != nil {
+		return parseErr
+	}
+
+	provider, providerErr := masterKeyProvider()
+	if providerErr != nil {
+		return providerErr
+	}
+
+	rotation, rotateErr := rotateEncryptionKey(provider)
+
+	// The rotation is added to the audit log even when it fails, so it's clear it has to be run again
+	system := SystemDB{}
+	auditErr := system.openAuditLog()
+	if auditErr == nil {
+		auditErr = system.recordAudit(commandLineBlame(), "ROTATE KEY", "", fmt.Sprintf("key %v", rotation.KeyID), rotateErr)
+		system.closeAuditLog()
+	}
+
+	if rotateErr != nil {
+		return errors.Join(rotateErr, auditErr)
+	}
+
+	if rotation.Resumed {
+		fmt.Printf("Carried on the unfinished rotation to key %v\n", rotation.KeyID)
+	}
+
+	fmt.Printf("Every file is now sealed with key %v, %v files were sealed again\n", rotation.KeyID, rotation.Files)
+	return auditErr
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		auditErr := runAuditCommand(os.Args[2:])
+		if auditErr != nil {
+			log.Fatal(auditErr)
+		}
+
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "rotate-key" {
+		rotateErr := runRotateKeyCommand(os.Args[2:])
+		if rotateErr != nil {
+			log.Fatal(rotateErr)
+		}
+
+		return
+	}
+
 	initSystem()
 	// db := DB{}
 	// defer db.Close()
@@ -109,18 +208,20 @@ func main() {
 	// 	return
 	// }
 
-	// err := db.runQuery(inputQuery)
+	// result, err := db.runQuery(inputQuery)
 	// if err != nil {
 	// 	log.Println(err)
 	// 	return
 	// }
 
+	// printResultSet(result)
+
 	// createDBErr := db.createTableFromMap("Users", "User_ID", true, map[string]any{"User_ID": 1, "Username": "Admin", "Password": "admin"})
 	// if createDBErr != nil {
 	// 	log.Fatal(createDBErr)
 	// }
 
-	// queryErr := db.runQuery("PULL Username FROM Users")
+	// _, queryErr := db.runQuery("PULL Username FROM Users")
 	// if queryErr != nil {
 	// 	log.Println(queryErr)
 	// }
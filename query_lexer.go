@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type TokenType int
+
+const (
+	tokenEOF TokenType = iota
+	tokenIdentifier
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenComma
+	tokenLeftParen
+	tokenRightParen
+	tokenDot
+	tokenStar
+	tokenSemicolon
+)
+
+// Position of a token within the query string, both values start at 1
+type Position struct {
+	Line   int
+	Column int
+}
+
+type Token struct {
+	Type TokenType
+	Text string
+	Pos  Position
+}
+
+// Error returned while lexing or parsing a query, carries the position of the problem
+type QueryError struct {
+	Pos     Position
+	Message string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("line %v, column %v: %v", e.Pos.Line, e.Pos.Column, e.Message)
+}
+
+// Readable names for each token type, used in error messages
+func (t TokenType) String() string {
+	switch t {
+	case tokenEOF:
+		return "end of query"
+	case tokenIdentifier:
+		return "identifier"
+	case tokenString:
+		return "string"
+	case tokenNumber:
+		return "number"
+	case tokenOperator:
+		return "operator"
+	case tokenComma:
+		return "','"
+	case tokenLeftParen:
+		return "'('"
+	case tokenRightParen:
+		return "')'"
+	case tokenDot:
+		return "'.'"
+	case tokenStar:
+		return "'*'"
+	case tokenSemicolon:
+		return "';'"
+	default:
+		return "unknown token"
+	}
+}
+
+type queryLexer struct {
+	input []rune
+	index int
+	pos   Position
+}
+
+// Break a query string down into a list of tokens, the last token is always tokenEOF
+func tokenizeQuery(query string) ([]Token, error) {
+	lexer := queryLexer{input: []rune(query), pos: Position{Line: 1, Column: 1}}
+	tokens := []Token{}
+
+	for {
+		token, err := lexer.next()
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, token)
+
+		if token.Type == tokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+// Look at the current rune without consuming it
+func (l *queryLexer) peek(offset int) rune {
+	if l.index+offset >= len(l.input) {
+		return 0
+	}
+
+	return l.input[l.index+offset]
+}
+
+// Consume the current rune, keeping track of the line and column
+func (l *queryLexer) advance() rune {
+	r := l.input[l.index]
+	l.index = l.index + 1
+
+	if r == '\n' {
+		l.pos.Line = l.pos.Line + 1
+		l.pos.Column = 1
+	} else {
+		l.pos.Column = l.pos.Column + 1
+	}
+
+	return r
+}
+
+// Read the next token from the input
+func (l *queryLexer) next() (Token, error) {
+	// Skip any whitespace between tokens
+	for l.index < len(l.input) && unicode.IsSpace(l.peek(0)) {
+		l.advance()
+	}
+
+	start := l.pos
+
+	if l.index >= len(l.input) {
+		return Token{Type: tokenEOF, Pos: start}, nil
+	}
+
+	r := l.peek(0)
+
+	switch {
+	case r == '\'' || r == '"':
+		return l.readString(start)
+	case unicode.IsDigit(r) || (r == '-' && unicode.IsDigit(l.peek(1))) || (r == '.' && unicode.IsDigit(l.peek(1))):
+		return l.readNumber(start)
+	case unicode.IsLetter(r) || r == '_':
+		return l.readIdentifier(start)
+	}
+
+	l.advance()
+
+	switch r {
+	case ',':
+		return Token{Type: tokenComma, Text: ",", Pos: start}, nil
+	case '(':
+		return Token{Type: tokenLeftParen, Text: "(", Pos: start}, nil
+	case ')':
+		return Token{Type: tokenRightParen, Text: ")", Pos: start}, nil
+	case '.':
+		return Token{Type: tokenDot, Text: ".", Pos: start}, nil
+	case '*':
+		return Token{Type: tokenStar, Text: "*", Pos: start}, nil
+	case ';':
+		return Token{Type: tokenSemicolon, Text: ";", Pos: start}, nil
+	case '=', '%', '~':
+		return Token{Type: tokenOperator, Text: string(r), Pos: start}, nil
+	case '!':
+		if l.peek(0) == '=' {
+			l.advance()
+			return Token{Type: tokenOperator, Text: "!=", Pos: start}, nil
+		}
+	case '<':
+		if l.peek(0) == '=' || l.peek(0) == '>' {
+			return Token{Type: tokenOperator, Text: string([]rune{r, l.advance()}), Pos: start}, nil
+		}
+
+		return Token{Type: tokenOperator, Text: "<", Pos: start}, nil
+	case '>':
+		if l.peek(0) == '=' {
+			l.advance()
+			return Token{Type: tokenOperator, Text: ">=", Pos: start}, nil
+		}
+
+		return Token{Type: tokenOperator, Text: ">", Pos: start}, nil
+	}
+
+	return Token{}, &QueryError{Pos: start, Message: fmt.Sprintf("unexpected character %q", r)}
+}
+
+// Read a quoted string literal, quotes can be escaped by doubling them or with a backslash
+func (l *queryLexer) readString(start Position) (Token, error) {
+	quote := l.advance()
+	var builder strings.Builder
+
+	for l.index < len(l.input) {
+		r := l.advance()
+
+		switch {
+		case r == '\\' && l.index < len(l.input):
+			builder.WriteRune(l.advance())
+		case r == quote && l.peek(0) == quote:
+			builder.WriteRune(l.advance())
+		case r == quote:
+			return Token{Type: tokenString, Text: builder.String(), Pos: start}, nil
+		default:
+			builder.WriteRune(r)
+		}
+	}
+
+	return Token{}, &QueryError{Pos: start, Message: "string literal was not closed"}
+}
+
+// Read an integer or decimal number, with an optional leading minus sign
+func (l *queryLexer) readNumber(start Position) (Token, error) {
+	var builder strings.Builder
+	seenDot := false
+
+	if l.peek(0) == '-' {
+		builder.WriteRune(l.advance())
+	}
+
+	for l.index < len(l.input) {
+		r := l.peek(0)
+
+		if r == '.' && !seenDot && unicode.IsDigit(l.peek(1)) {
+			seenDot = true
+		} else if !unicode.IsDigit(r) {
+			break
+		}
+
+		builder.WriteRune(l.advance())
+	}
+
+	// A number running straight into letters is most likely a typo, so don't split it silently
+	if unicode.IsLetter(l.peek(0)) || l.peek(0) == '_' {
+		return Token{}, &QueryError{Pos: l.pos, Message: fmt.Sprintf("unexpected character %q after number %v", l.peek(0), builder.String())}
+	}
+
+	return Token{Type: tokenNumber, Text: builder.String(), Pos: start}, nil
+}
+
+// Read an identifier or keyword, made up of letters, digits and underscores
+func (l *queryLexer) readIdentifier(start Position) (Token, error) {
+	var builder strings.Builder
+
+	for l.index < len(l.input) {
+		r := l.peek(0)
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_') {
+			break
+		}
+
+		builder.WriteRune(l.advance())
+	}
+
+	return Token{Type: tokenIdentifier, Text: builder.String(), Pos: start}, nil
+}
@@ -0,0 +1,1592 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// A parsed query statement, one of PullStatement, PushStatement, PutStatement, DeleteStatement,
+// CreateTableStatement, DropTableStatement, AlterTableStatement, CreateIndexStatement, DropIndexStatement, TransactionStatement,
+// CreateDatabaseStatement, UseStatement, ShowStatement or DescribeStatement
+type Statement interface {
+	operation() string
+}
+
+type PullStatement struct {
+	Columns []SelectItem
+	Table   string
+	Alias   string
+	Joins   []JoinClause
+	Where   WhereExpr
+	GroupBy []string
+	Having  WhereExpr
+	Sort    []SortItem
+	Limit   *int
+	Offset  *int
+}
+
+// A table joined onto a PULL, Type is one of INNER, LEFT or CROSS
+// ** CROSS joins have no ON condition
+type JoinClause struct {
+	Type  string
+	Table string
+	Alias string
+	On    WhereExpr
+}
+
+// Keywords that can follow a table name, so can't be used as an alias without AS
+var tableClauseKeywords = []string{"WHERE", "JOIN", "INNER", "LEFT", "CROSS", "ON", "GROUP", "HAVING", "SORT", "LIMIT", "OFFSET"}
+
+// A single entry in the column list of a PULL, either a column or an aggregate function
+type SelectItem struct {
+	Column    string
+	Aggregate *AggregateCall
+}
+
+// An aggregate function call, such as COUNT(*) or SUM(Amount)
+type AggregateCall struct {
+	Function string
+	Column   string
+}
+
+// A single column to sort PULL results by
+type SortItem struct {
+	Column     string
+	Descending bool
+	Aggregate  *AggregateCall
+}
+
+// Functions that can be used to aggregate the rows of a PULL
+var aggregateFunctions = []string{"COUNT", "SUM", "AVG", "MIN", "MAX"}
+
+type PushStatement struct {
+	Assignments []Assignment
+	Table       string
+}
+
+type PutStatement struct {
+	Assignments []Assignment
+	Table       string
+	Where       WhereExpr
+}
+
+type DeleteStatement struct {
+	Table string
+	Where WhereExpr
+}
+
+type CreateTableStatement struct {
+	Table         string
+	Columns       []ColumnConfig
+	PrimaryKey    string
+	AutoIncrement bool
+	ForeignKeys   []ForeignKey
+}
+
+type DropTableStatement struct {
+	Table string
+}
+
+// Kind is HASH or ORDERED
+type CreateIndexStatement struct {
+	Name   string
+	Table  string
+	Column string
+	Kind   string
+}
+
+type DropIndexStatement struct {
+	Name  string
+	Table string
+}
+
+// BEGIN, COMMIT or ROLLBACK
+type TransactionStatement struct {
+	Operation string
+}
+
+type CreateDatabaseStatement struct {
+	Name string
+}
+
+type UseStatement struct {
+	Database string
+}
+
+// Target is DATABASES or TABLES
+type ShowStatement struct {
+	Target string
+}
+
+type DescribeStatement struct {
+	Table string
+}
+
+// Action is one of ADD, DROP or RENAME, NewName is only set for RENAME
+type AlterTableStatement struct {
+	Table   string
+	Action  string
+	Column  ColumnConfig
+	NewName string
+}
+
+// A single <column> = <value> pair used by PUSH and PUT
+type Assignment struct {
+	Column string
+	Value  Operand
+	Pos    Position
+}
+
+// A node within the expression tree of a WHERE clause, one of *Condition, *LogicalCondition or *NotCondition
+type WhereExpr interface {
+	String() string
+}
+
+// Two expressions joined together by AND or OR
+type LogicalCondition struct {
+	Operator string
+	Left     WhereExpr
+	Right    WhereExpr
+}
+
+// An expression negated with NOT
+type NotCondition struct {
+	Expr WhereExpr
+}
+
+// A single comparison within a WHERE clause
+// ** IN and BETWEEN keep their values in Values, IS NULL and IS NOT NULL have no right hand side
+type Condition struct {
+	Left     Operand
+	Operator string
+	Right    Operand
+	Values   []Operand
+	Pos      Position
+}
+
+// A value or column reference within a statement
+// ** Aggregate functions within HAVING are held as a reference to the column holding their result
+type Operand struct {
+	Kind      TokenType
+	Text      string
+	Value     any
+	Aggregate *AggregateCall
+	Pos       Position
+}
+
+// Checks if the operand is a bare identifier, rather than a literal or one of true, false and null
+func (o Operand) isIdentifier() bool {
+	return o.Kind == tokenIdentifier && o.Value == o.Text
+}
+
+// Returns the value used for the operand in a WHERE clause, bare identifiers may refer to a column
+func (o Operand) argumentValue() any {
+	if o.isIdentifier() {
+		return ColumnReference(o.Text)
+	}
+
+	return o.Value
+}
+
+func (c *LogicalCondition) String() string {
+	return fmt.Sprintf("(%v %v %v)", c.Left, c.Operator, c.Right)
+}
+
+func (c *NotCondition) String() string {
+	return fmt.Sprintf("NOT %v", c.Expr)
+}
+
+func (c *Condition) String() string {
+	switch {
+	case c.Values != nil && strings.HasSuffix(c.Operator, "BETWEEN"):
+		return fmt.Sprintf("%v %v %v AND %v", c.Left, c.Operator, c.Values[0], c.Values[1])
+	case c.Values != nil:
+		values := []string{}
+		for _, value := range c.Values {
+			values = append(values, value.String())
+		}
+		return fmt.Sprintf("%v %v (%v)", c.Left, c.Operator, strings.Join(values, ", "))
+	case c.Right.Kind == tokenEOF:
+		return fmt.Sprintf("%v %v", c.Left, c.Operator)
+	}
+
+	return fmt.Sprintf("%v %v %v", c.Left, c.Operator, c.Right)
+}
+
+// Writes the operand back out the way it would appear in a query
+// ** Backslashes are escaped as well as quotes, as the lexer reads a backslash as escaping the character after it
+func (o Operand) String() string {
+	if o.Kind == tokenString {
+		escaped := strings.ReplaceAll(o.Text, `\`, `\\`)
+		return "'" + strings.ReplaceAll(escaped, "'", "''") + "'"
+	}
+
+	return o.Text
+}
+
+func (s *PullStatement) operation() string   { return "PULL" }
+func (s *PushStatement) operation() string   { return "PUSH" }
+func (s *PutStatement) operation() string    { return "PUT" }
+func (s *DeleteStatement) operation() string { return "DELETE" }
+
+func (s *CreateTableStatement) operation() string { return "CREATE" }
+func (s *DropTableStatement) operation() string   { return "DROP" }
+func (s *AlterTableStatement) operation() string  { return "ALTER" }
+func (s *CreateIndexStatement) operation() string { return "CREATE" }
+func (s *DropIndexStatement) operation() string   { return "DROP" }
+func (s *TransactionStatement) operation() string { return s.Operation }
+
+func (s *CreateDatabaseStatement) operation() string { return "CREATE" }
+func (s *UseStatement) operation() string            { return "USE" }
+func (s *ShowStatement) operation() string           { return "SHOW" }
+func (s *DescribeStatement) operation() string       { return "DESCRIBE" }
+
+// Name of the result column for an aggregate, e.g. SUM(Amount)
+func (a AggregateCall) name() string {
+	return fmt.Sprintf("%v(%v)", a.Function, a.Column)
+}
+
+// Name of the result column for a select item
+func (i SelectItem) name() string {
+	if i.Aggregate != nil {
+		return i.Aggregate.name()
+	}
+
+	return i.Column
+}
+
+type queryParser struct {
+	tokens          []Token
+	index           int
+	allowAggregates bool
+}
+
+// Parse a query string into a statement
+func parseQuery(query string) (Statement, error) {
+	tokens, err := tokenizeQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := queryParser{tokens: tokens}
+	statement, err := parser.parseStatement()
+	if err != nil {
+		return nil, err
+	}
+
+	// Allow a single trailing semicolon, anything after that is a mistake
+	parser.acceptType(tokenSemicolon)
+	if parser.current().Type != tokenEOF {
+		return nil, parser.unexpected("end of query")
+	}
+
+	return statement, nil
+}
+
+// Parse a saved expression in the same form as a WHERE clause, e.g. the CHECK of a column
+func parseExpression(expression string) (WhereExpr, error) {
+	tokens, err := tokenizeQuery(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := queryParser{tokens: tokens}
+	expr, err := parser.parseWhere()
+	if err != nil {
+		return nil, err
+	}
+
+	if parser.current().Type != tokenEOF {
+		return nil, parser.unexpected("end of expression")
+	}
+
+	return expr, nil
+}
+
+func (p *queryParser) current() Token {
+	return p.tokens[p.index]
+}
+
+func (p *queryParser) advance() Token {
+	token := p.tokens[p.index]
+	if token.Type != tokenEOF {
+		p.index = p.index + 1
+	}
+
+	return token
+}
+
+// Checks if the current token is the keyword specified, keywords are case insensitive
+func (p *queryParser) isKeyword(keyword string) bool {
+	token := p.current()
+	return token.Type == tokenIdentifier && strings.EqualFold(token.Text, keyword)
+}
+
+// Consumes the current token if it is the keyword specified
+func (p *queryParser) acceptKeyword(keyword string) bool {
+	if p.isKeyword(keyword) {
+		p.advance()
+		return true
+	}
+
+	return false
+}
+
+// Consumes the current token if it is of the type specified
+func (p *queryParser) acceptType(tokenType TokenType) bool {
+	if p.current().Type == tokenType {
+		p.advance()
+		return true
+	}
+
+	return false
+}
+
+func (p *queryParser) expectKeyword(keyword string) error {
+	if !p.acceptKeyword(keyword) {
+		return p.unexpected(keyword)
+	}
+
+	return nil
+}
+
+func (p *queryParser) expectType(tokenType TokenType) (Token, error) {
+	if p.current().Type != tokenType {
+		return Token{}, p.unexpected(tokenType.String())
+	}
+
+	return p.advance(), nil
+}
+
+// Builds an error for the current token, explaining what was expected instead
+func (p *queryParser) unexpected(expected string) error {
+	token := p.current()
+
+	if token.Type == tokenEOF {
+		return &QueryError{Pos: token.Pos, Message: fmt.Sprintf("expected %v but reached the end of the query", expected)}
+	}
+
+	return &QueryError{Pos: token.Pos, Message: fmt.Sprintf("expected %v but found %q", expected, token.Text)}
+}
+
+// Parse a full statement, based on the operation keyword it starts with
+func (p *queryParser) parseStatement() (Statement, error) {
+	switch {
+	case p.acceptKeyword("PULL"):
+		return p.parsePull()
+	case p.acceptKeyword("PUSH"):
+		return p.parsePush()
+	case p.acceptKeyword("PUT"):
+		return p.parsePut()
+	case p.acceptKeyword("DELETE"):
+		return p.parseDelete()
+	case p.acceptKeyword("CREATE"):
+		if p.acceptKeyword("INDEX") {
+			return p.parseCreateIndex()
+		}
+
+		if p.acceptKeyword("DATABASE") {
+			name, err := p.expectType(tokenIdentifier)
+			if err != nil {
+				return nil, err
+			}
+
+			return &CreateDatabaseStatement{Name: name.Text}, nil
+		}
+
+		return p.parseCreateTable()
+	case p.acceptKeyword("DROP"):
+		if p.acceptKeyword("INDEX") {
+			return p.parseDropIndex()
+		}
+
+		return p.parseDropTable()
+	case p.acceptKeyword("ALTER"):
+		return p.parseAlterTable()
+	case p.acceptKeyword("USE"):
+		database, err := p.expectType(tokenIdentifier)
+		if err != nil {
+			return nil, err
+		}
+
+		return &UseStatement{Database: database.Text}, nil
+	case p.acceptKeyword("SHOW"):
+		for _, target := range []string{"DATABASES", "TABLES"} {
+			if p.acceptKeyword(target) {
+				return &ShowStatement{Target: target}, nil
+			}
+		}
+
+		return nil, p.unexpected("one of DATABASES or TABLES")
+	case p.acceptKeyword("DESCRIBE"):
+		table, err := p.expectType(tokenIdentifier)
+		if err != nil {
+			return nil, err
+		}
+
+		return &DescribeStatement{Table: table.Text}, nil
+	}
+
+	// BEGIN, COMMIT and ROLLBACK can optionally be followed by TRANSACTION
+	for _, keyword := range []string{"BEGIN", "COMMIT", "ROLLBACK"} {
+		if p.acceptKeyword(keyword) {
+			p.acceptKeyword("TRANSACTION")
+			return &TransactionStatement{Operation: keyword}, nil
+		}
+	}
+
+	return nil, p.unexpected("one of PULL, PUSH, PUT, DELETE, CREATE, DROP, ALTER, BEGIN, COMMIT, ROLLBACK, USE, SHOW or DESCRIBE")
+}
+
+// PULL <column>, ... FROM <table> [[AS] <alias>] [[INNER|LEFT|CROSS] JOIN <table> [[AS] <alias>] [ON <conditions>] ...]
+// [WHERE <conditions>] [GROUP BY <column>, ...] [HAVING <conditions>] [SORT BY <column> [ASC|DESC], ...] [LIMIT <n>] [OFFSET <m>]
+func (p *queryParser) parsePull() (Statement, error) {
+	statement := PullStatement{}
+	var err error
+
+	if p.acceptType(tokenStar) {
+		statement.Columns = []SelectItem{{Column: "*"}}
+	} else {
+		for {
+			item, err := p.parseSelectItem()
+			if err != nil {
+				return nil, err
+			}
+
+			statement.Columns = append(statement.Columns, item)
+
+			if !p.acceptType(tokenComma) || p.isKeyword("FROM") {
+				break
+			}
+		}
+	}
+
+	if err = p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+
+	statement.Table, statement.Alias, err = p.parseTableReference()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		join, found, err := p.parseJoin()
+		if err != nil {
+			return nil, err
+		}
+
+		if !found {
+			break
+		}
+
+		statement.Joins = append(statement.Joins, join)
+	}
+
+	if p.acceptKeyword("WHERE") {
+		statement.Where, err = p.parseWhere()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("GROUP") {
+		statement.GroupBy, err = p.parseGroupBy()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Aggregates can be used from HAVING onwards, as they are worked out once the rows are grouped
+	p.allowAggregates = true
+
+	if p.acceptKeyword("HAVING") {
+		statement.Having, err = p.parseWhere()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("SORT") {
+		statement.Sort, err = p.parseSort()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("LIMIT") {
+		statement.Limit, err = p.parseCount("LIMIT")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("OFFSET") {
+		statement.Offset, err = p.parseCount("OFFSET")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &statement, nil
+}
+
+// Parse a table name, followed by an optional alias
+func (p *queryParser) parseTableReference() (string, string, error) {
+	table, err := p.expectType(tokenIdentifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	if p.acceptKeyword("AS") {
+		alias, err := p.expectType(tokenIdentifier)
+		if err != nil {
+			return "", "", err
+		}
+
+		return table.Text, alias.Text, nil
+	}
+
+	// A bare alias can be used, as long as it isn't the start of the next clause
+	token := p.current()
+	if token.Type == tokenIdentifier && !Contains(tableClauseKeywords, strings.ToUpper(token.Text)) {
+		p.advance()
+		return table.Text, token.Text, nil
+	}
+
+	return table.Text, "", nil
+}
+
+// Parse a single JOIN onto the tables of a PULL, found is false when there are no more joins
+func (p *queryParser) parseJoin() (JoinClause, bool, error) {
+	join := JoinClause{Type: "INNER"}
+
+	switch {
+	case p.acceptKeyword("INNER"):
+		if err := p.expectKeyword("JOIN"); err != nil {
+			return JoinClause{}, false, err
+		}
+	case p.acceptKeyword("LEFT"):
+		join.Type = "LEFT"
+		p.acceptKeyword("OUTER")
+		if err := p.expectKeyword("JOIN"); err != nil {
+			return JoinClause{}, false, err
+		}
+	case p.acceptKeyword("CROSS"):
+		join.Type = "CROSS"
+		if err := p.expectKeyword("JOIN"); err != nil {
+			return JoinClause{}, false, err
+		}
+	case p.acceptKeyword("JOIN"):
+	default:
+		return JoinClause{}, false, nil
+	}
+
+	var err error
+	join.Table, join.Alias, err = p.parseTableReference()
+	if err != nil {
+		return JoinClause{}, false, err
+	}
+
+	if join.Type == "CROSS" {
+		return join, true, nil
+	}
+
+	if err := p.expectKeyword("ON"); err != nil {
+		return JoinClause{}, false, err
+	}
+
+	join.On, err = p.parseWhere()
+	if err != nil {
+		return JoinClause{}, false, err
+	}
+
+	return join, true, nil
+}
+
+// Parse a column name, which can be qualified with the table or alias it belongs to, e.g. Orders.Amount
+// ** <table>.* is only accepted when allowStar is set
+func (p *queryParser) parseColumnName(allowStar bool) (string, error) {
+	column, err := p.expectType(tokenIdentifier)
+	if err != nil {
+		return "", err
+	}
+
+	if !p.acceptType(tokenDot) {
+		return column.Text, nil
+	}
+
+	if allowStar && p.acceptType(tokenStar) {
+		return column.Text + ".*", nil
+	}
+
+	qualified, err := p.expectType(tokenIdentifier)
+	if err != nil {
+		return "", err
+	}
+
+	return column.Text + "." + qualified.Text, nil
+}
+
+// Parse the columns of a SORT BY clause, each one can be followed by ASC or DESC
+func (p *queryParser) parseSort() ([]SortItem, error) {
+	if err := p.expectKeyword("BY"); err != nil {
+		return nil, err
+	}
+
+	items := []SortItem{}
+
+	for {
+		column, err := p.parseSelectItem()
+		if err != nil {
+			return nil, err
+		}
+
+		item := SortItem{Column: column.name(), Aggregate: column.Aggregate}
+		if p.acceptKeyword("DESC") {
+			item.Descending = true
+		} else {
+			p.acceptKeyword("ASC")
+		}
+
+		items = append(items, item)
+
+		if !p.acceptType(tokenComma) {
+			break
+		}
+	}
+
+	return items, nil
+}
+
+// Parse the columns of a GROUP BY clause
+func (p *queryParser) parseGroupBy() ([]string, error) {
+	if err := p.expectKeyword("BY"); err != nil {
+		return nil, err
+	}
+
+	columns := []string{}
+
+	for {
+		column, err := p.parseColumnName(false)
+		if err != nil {
+			return nil, err
+		}
+
+		columns = append(columns, column)
+
+		if !p.acceptType(tokenComma) {
+			break
+		}
+	}
+
+	return columns, nil
+}
+
+// Parse a column name or an aggregate function call, such as COUNT(*) or MAX(Amount)
+func (p *queryParser) parseSelectItem() (SelectItem, error) {
+	if p.isAggregateCall() {
+		aggregate, err := p.parseAggregateCall()
+		if err != nil {
+			return SelectItem{}, err
+		}
+
+		return SelectItem{Aggregate: &aggregate}, nil
+	}
+
+	column, err := p.parseColumnName(true)
+	if err != nil {
+		return SelectItem{}, err
+	}
+
+	return SelectItem{Column: column}, nil
+}
+
+// Checks if the parser is at the start of an aggregate function call
+func (p *queryParser) isAggregateCall() bool {
+	token := p.current()
+	if token.Type != tokenIdentifier || p.tokens[p.index+1].Type != tokenLeftParen {
+		return false
+	}
+
+	return Contains(aggregateFunctions, strings.ToUpper(token.Text))
+}
+
+// Parse an aggregate function call, only COUNT accepts * in place of a column
+func (p *queryParser) parseAggregateCall() (AggregateCall, error) {
+	function := p.advance()
+	p.advance()
+
+	aggregate := AggregateCall{Function: strings.ToUpper(function.Text)}
+
+	if aggregate.Function == "COUNT" && p.acceptType(tokenStar) {
+		aggregate.Column = "*"
+	} else {
+		column, err := p.parseColumnName(false)
+		if err != nil {
+			return AggregateCall{}, err
+		}
+
+		aggregate.Column = column
+	}
+
+	if _, err := p.expectType(tokenRightParen); err != nil {
+		return AggregateCall{}, err
+	}
+
+	return aggregate, nil
+}
+
+// Parse the whole, non-negative number following LIMIT or OFFSET
+func (p *queryParser) parseCount(keyword string) (*int, error) {
+	token, err := p.expectType(tokenNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	count, isInt := parseCountValue(token)
+	if !isInt {
+		return nil, &QueryError{Pos: token.Pos, Message: fmt.Sprintf("%v must be a whole number of zero or more, got: %v", keyword, token.Text)}
+	}
+
+	return &count, nil
+}
+
+// PUSH <column> = <value>, ... TO <table>
+func (p *queryParser) parsePush() (Statement, error) {
+	assignments, err := p.parseAssignments()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("TO"); err != nil {
+		return nil, err
+	}
+
+	table, err := p.expectType(tokenIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PushStatement{Assignments: assignments, Table: table.Text}, nil
+}
+
+// PUT <column> = <value>, ... TO <table> WHERE <conditions>
+func (p *queryParser) parsePut() (Statement, error) {
+	assignments, err := p.parseAssignments()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("TO"); err != nil {
+		return nil, err
+	}
+
+	table, err := p.expectType(tokenIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("WHERE"); err != nil {
+		return nil, err
+	}
+
+	where, err := p.parseWhere()
+	if err != nil {
+		return nil, err
+	}
+
+	return &PutStatement{Assignments: assignments, Table: table.Text, Where: where}, nil
+}
+
+// DELETE FROM <table> [WHERE <conditions>]
+func (p *queryParser) parseDelete() (Statement, error) {
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+
+	table, err := p.expectType(tokenIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := DeleteStatement{Table: table.Text}
+
+	if p.acceptKeyword("WHERE") {
+		statement.Where, err = p.parseWhere()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &statement, nil
+}
+
+// CREATE TABLE <table> (<column> <type> [NOT NULL], ..., PRIMARY KEY <column> [AUTO])
+func (p *queryParser) parseCreateTable() (Statement, error) {
+	if !p.acceptKeyword("TABLE") {
+		return nil, p.unexpected("one of TABLE, INDEX or DATABASE")
+	}
+
+	table, err := p.expectType(tokenIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.expectType(tokenLeftParen); err != nil {
+		return nil, err
+	}
+
+	statement := CreateTableStatement{Table: table.Text, Columns: []ColumnConfig{}}
+	var primaryKey Token
+
+	for {
+		if p.isKeyword("PRIMARY") {
+			if statement.PrimaryKey != "" {
+				return nil, &QueryError{Pos: p.current().Pos, Message: "a table can only have one PRIMARY KEY"}
+			}
+
+			p.advance()
+			if err := p.expectKeyword("KEY"); err != nil {
+				return nil, err
+			}
+
+			primaryKey, err = p.expectType(tokenIdentifier)
+			if err != nil {
+				return nil, err
+			}
+
+			statement.PrimaryKey = primaryKey.Text
+			statement.AutoIncrement = p.acceptKeyword("AUTO")
+		} else if p.acceptKeyword("FOREIGN") {
+			foreignKey, err := p.parseForeignKey()
+			if err != nil {
+				return nil, err
+			}
+
+			statement.ForeignKeys = append(statement.ForeignKeys, foreignKey)
+		} else {
+			column, err := p.parseColumnDefinition()
+			if err != nil {
+				return nil, err
+			}
+
+			if slices.ContainsFunc(statement.Columns, func(existing ColumnConfig) bool { return existing.ColumnName == column.ColumnName }) {
+				return nil, &QueryError{Pos: p.tokens[p.index-1].Pos, Message: fmt.Sprintf("column %v is defined more than once", column.ColumnName)}
+			}
+
+			statement.Columns = append(statement.Columns, column)
+		}
+
+		if !p.acceptType(tokenComma) {
+			break
+		}
+	}
+
+	if _, err := p.expectType(tokenRightParen); err != nil {
+		return nil, err
+	}
+
+	if statement.PrimaryKey == "" {
+		return nil, &QueryError{Pos: table.Pos, Message: fmt.Sprintf("table %v needs a PRIMARY KEY", table.Text)}
+	}
+
+	// The primary key has to be one of the columns, and can never be null
+	primaryIndex := slices.IndexFunc(statement.Columns, func(column ColumnConfig) bool { return column.ColumnName == statement.PrimaryKey })
+	if primaryIndex == -1 {
+		return nil, &QueryError{Pos: primaryKey.Pos, Message: fmt.Sprintf("PRIMARY KEY column %v is not one of the columns of the table", statement.PrimaryKey)}
+	}
+	statement.Columns[primaryIndex].Nullable = false
+
+	if statement.AutoIncrement && statement.Columns[primaryIndex].ColumnType != "int" {
+		return nil, &QueryError{Pos: primaryKey.Pos, Message: "AUTO can only be used on an int PRIMARY KEY"}
+	}
+
+	for _, foreignKey := range statement.ForeignKeys {
+		columnIndex := slices.IndexFunc(statement.Columns, func(column ColumnConfig) bool { return column.ColumnName == foreignKey.Column })
+		if columnIndex == -1 {
+			return nil, &QueryError{Pos: table.Pos, Message: fmt.Sprintf("FOREIGN KEY column %v is not one of the columns of the table", foreignKey.Column)}
+		}
+
+		if foreignKey.OnDelete == "SET NULL" && !statement.Columns[columnIndex].Nullable {
+			return nil, &QueryError{Pos: table.Pos, Message: fmt.Sprintf("FOREIGN KEY column %v can't use ON DELETE SET NULL, as it is NOT NULL", foreignKey.Column)}
+		}
+	}
+
+	return &statement, nil
+}
+
+// Parse a foreign key of CREATE TABLE, after the FOREIGN keyword
+// FOREIGN KEY <column> REFERENCES <table> (<column>) [ON DELETE RESTRICT | CASCADE | SET NULL], deletes are restricted by default
+func (p *queryParser) parseForeignKey() (ForeignKey, error) {
+	if err := p.expectKeyword("KEY"); err != nil {
+		return ForeignKey{}, err
+	}
+
+	column, err := p.expectType(tokenIdentifier)
+	if err != nil {
+		return ForeignKey{}, err
+	}
+
+	if err := p.expectKeyword("REFERENCES"); err != nil {
+		return ForeignKey{}, err
+	}
+
+	referencedTable, err := p.expectType(tokenIdentifier)
+	if err != nil {
+		return ForeignKey{}, err
+	}
+
+	if _, err := p.expectType(tokenLeftParen); err != nil {
+		return ForeignKey{}, err
+	}
+
+	referencedColumn, err := p.expectType(tokenIdentifier)
+	if err != nil {
+		return ForeignKey{}, err
+	}
+
+	if _, err := p.expectType(tokenRightParen); err != nil {
+		return ForeignKey{}, err
+	}
+
+	foreignKey := ForeignKey{Column: column.Text, ReferencedTable: referencedTable.Text, ReferencedColumn: referencedColumn.Text, OnDelete: "RESTRICT"}
+
+	if p.acceptKeyword("ON") {
+		if err := p.expectKeyword("DELETE"); err != nil {
+			return ForeignKey{}, err
+		}
+
+		switch {
+		case p.acceptKeyword("RESTRICT"):
+			foreignKey.OnDelete = "RESTRICT"
+		case p.acceptKeyword("CASCADE"):
+			foreignKey.OnDelete = "CASCADE"
+		case p.acceptKeyword("SET"):
+			if err := p.expectKeyword("NULL"); err != nil {
+				return ForeignKey{}, err
+			}
+
+			foreignKey.OnDelete = "SET NULL"
+		default:
+			return ForeignKey{}, p.unexpected("one of RESTRICT, CASCADE or SET NULL")
+		}
+	}
+
+	return foreignKey, nil
+}
+
+// CREATE INDEX <name> ON <table> (<column>) [USING HASH | ORDERED]
+// ** HASH indexes are used for = and IN, ORDERED indexes are also used for <, >, BETWEEN and SORT BY
+func (p *queryParser) parseCreateIndex() (Statement, error) {
+	name, err := p.expectType(tokenIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("ON"); err != nil {
+		return nil, err
+	}
+
+	table, err := p.expectType(tokenIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.expectType(tokenLeftParen); err != nil {
+		return nil, err
+	}
+
+	column, err := p.expectType(tokenIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.expectType(tokenRightParen); err != nil {
+		return nil, err
+	}
+
+	statement := CreateIndexStatement{Name: name.Text, Table: table.Text, Column: column.Text, Kind: "HASH"}
+
+	if p.acceptKeyword("USING") {
+		switch {
+		case p.acceptKeyword("HASH"):
+			statement.Kind = "HASH"
+		case p.acceptKeyword("ORDERED"):
+			statement.Kind = "ORDERED"
+		default:
+			return nil, p.unexpected("one of HASH or ORDERED")
+		}
+	}
+
+	return &statement, nil
+}
+
+// DROP INDEX <name> ON <table>
+func (p *queryParser) parseDropIndex() (Statement, error) {
+	name, err := p.expectType(tokenIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("ON"); err != nil {
+		return nil, err
+	}
+
+	table, err := p.expectType(tokenIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DropIndexStatement{Name: name.Text, Table: table.Text}, nil
+}
+
+// Parse a column of CREATE TABLE or ALTER TABLE ADD, columns can be null unless NOT NULL is given
+// <column> <type> [NOT NULL | NULL] [UNIQUE] [DEFAULT <value> | now() | uuid()] [CHECK (<expression>)], the constraints can be in any order
+func (p *queryParser) parseColumnDefinition() (ColumnConfig, error) {
+	name, err := p.expectType(tokenIdentifier)
+	if err != nil {
+		return ColumnConfig{}, err
+	}
+
+	columnType, err := p.expectType(tokenIdentifier)
+	if err != nil {
+		return ColumnConfig{}, err
+	}
+
+	typeName, isType := parseColumnType(columnType.Text)
+	if !isType {
+		return ColumnConfig{}, &QueryError{Pos: columnType.Pos, Message: fmt.Sprintf("%v is not a supported column type, expected one of %v", columnType.Text, strings.Join(columnTypeNames, ", "))}
+	}
+
+	column := ColumnConfig{ColumnName: name.Text, ColumnType: typeName, Nullable: true}
+
+	for {
+		switch {
+		case p.acceptKeyword("NOT"):
+			if err := p.expectKeyword("NULL"); err != nil {
+				return ColumnConfig{}, err
+			}
+
+			column.Nullable = false
+
+		case p.acceptKeyword("NULL"):
+			column.Nullable = true
+
+		case p.acceptKeyword("UNIQUE"):
+			column.Unique = true
+
+		case p.acceptKeyword("DEFAULT"):
+			column.Default, err = p.parseColumnDefault(typeName)
+			if err != nil {
+				return ColumnConfig{}, err
+			}
+
+		case p.acceptKeyword("CHECK"):
+			if _, err := p.expectType(tokenLeftParen); err != nil {
+				return ColumnConfig{}, err
+			}
+
+			check, err := p.parseWhere()
+			if err != nil {
+				return ColumnConfig{}, err
+			}
+
+			if _, err := p.expectType(tokenRightParen); err != nil {
+				return ColumnConfig{}, err
+			}
+
+			// The expression is kept in its written form, so it can be saved with the table and parsed again when it is loaded
+			column.Check = check.String()
+
+		default:
+			return column, nil
+		}
+	}
+}
+
+// Parse the value after DEFAULT, either a literal of the column type or one of the functions now() and uuid()
+func (p *queryParser) parseColumnDefault(columnType string) (*ColumnDefault, error) {
+	token := p.current()
+
+	for function, functionType := range defaultFunctions {
+		if !p.isKeyword(function) || p.tokens[p.index+1].Type != tokenLeftParen {
+			continue
+		}
+
+		if functionType != columnType {
+			return nil, &QueryError{Pos: token.Pos, Message: fmt.Sprintf("%v() can only be the default of a %v column", function, functionType)}
+		}
+
+		p.advance()
+		p.advance()
+		if _, err := p.expectType(tokenRightParen); err != nil {
+			return nil, err
+		}
+
+		return &ColumnDefault{Function: function}, nil
+	}
+
+	operand, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	if operand.Value == nil {
+		return nil, nil
+	}
+
+	value, typeErr := coerceValue(operand.Value, columnType)
+	if typeErr != nil {
+		return nil, &QueryError{Pos: token.Pos, Message: fmt.Sprintf("invalid default value: %v", typeErr)}
+	}
+
+	return &ColumnDefault{Value: value}, nil
+}
+
+// DROP TABLE <table>
+func (p *queryParser) parseDropTable() (Statement, error) {
+	if !p.acceptKeyword("TABLE") {
+		return nil, p.unexpected("one of TABLE or INDEX")
+	}
+
+	table, err := p.expectType(tokenIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DropTableStatement{Table: table.Text}, nil
+}
+
+// ALTER TABLE <table> ADD [COLUMN] <column> <type> [NOT NULL]
+// ALTER TABLE <table> DROP [COLUMN] <column>
+// ALTER TABLE <table> RENAME [COLUMN] <column> TO <new name>
+func (p *queryParser) parseAlterTable() (Statement, error) {
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, err
+	}
+
+	table, err := p.expectType(tokenIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := AlterTableStatement{Table: table.Text}
+
+	switch {
+	case p.acceptKeyword("ADD"):
+		statement.Action = "ADD"
+		p.acceptKeyword("COLUMN")
+
+		statement.Column, err = p.parseColumnDefinition()
+		if err != nil {
+			return nil, err
+		}
+
+	case p.acceptKeyword("DROP"):
+		statement.Action = "DROP"
+		p.acceptKeyword("COLUMN")
+
+		column, err := p.expectType(tokenIdentifier)
+		if err != nil {
+			return nil, err
+		}
+		statement.Column = ColumnConfig{ColumnName: column.Text}
+
+	case p.acceptKeyword("RENAME"):
+		statement.Action = "RENAME"
+		p.acceptKeyword("COLUMN")
+
+		column, err := p.expectType(tokenIdentifier)
+		if err != nil {
+			return nil, err
+		}
+		statement.Column = ColumnConfig{ColumnName: column.Text}
+
+		if err := p.expectKeyword("TO"); err != nil {
+			return nil, err
+		}
+
+		newName, err := p.expectType(tokenIdentifier)
+		if err != nil {
+			return nil, err
+		}
+		statement.NewName = newName.Text
+
+	default:
+		return nil, p.unexpected("one of ADD, DROP or RENAME")
+	}
+
+	return &statement, nil
+}
+
+// Parse a comma separated list of <column> = <value> pairs, a trailing comma is allowed
+func (p *queryParser) parseAssignments() ([]Assignment, error) {
+	assignments := []Assignment{}
+
+	for {
+		column, err := p.expectType(tokenIdentifier)
+		if err != nil {
+			return nil, err
+		}
+
+		operator := p.current()
+		if operator.Type != tokenOperator || operator.Text != "=" {
+			return nil, p.unexpected("'='")
+		}
+		p.advance()
+
+		value, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+
+		assignments = append(assignments, Assignment{Column: column.Text, Value: value, Pos: column.Pos})
+
+		if !p.acceptType(tokenComma) || p.isKeyword("TO") {
+			break
+		}
+	}
+
+	return assignments, nil
+}
+
+// Parse the expression of a WHERE clause
+// ** NOT binds tighter than AND, which binds tighter than OR, brackets can be used to group expressions
+func (p *queryParser) parseWhere() (WhereExpr, error) {
+	left, err := p.parseAndExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("OR") {
+		right, err := p.parseAndExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &LogicalCondition{Operator: "OR", Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+// Parse expressions joined by AND, a comma between conditions is treated the same as AND
+func (p *queryParser) parseAndExpr() (WhereExpr, error) {
+	left, err := p.parseNotExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("AND") || p.acceptType(tokenComma) {
+		right, err := p.parseNotExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &LogicalCondition{Operator: "AND", Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+// Parse an optionally negated condition or bracketed expression
+func (p *queryParser) parseNotExpr() (WhereExpr, error) {
+	if p.acceptKeyword("NOT") {
+		expr, err := p.parseNotExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		return &NotCondition{Expr: expr}, nil
+	}
+
+	if p.acceptType(tokenLeftParen) {
+		expr, err := p.parseWhere()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := p.expectType(tokenRightParen); err != nil {
+			return nil, err
+		}
+
+		return expr, nil
+	}
+
+	condition, err := p.parseCondition()
+	if err != nil {
+		return nil, err
+	}
+
+	return &condition, nil
+}
+
+// Parse a single condition, one of:
+// <value> <operator> <value>, <value> [NOT] LIKE <pattern>, <value> MATCHES <regex>,
+// <value> [NOT] IN (<value>, ...), <value> [NOT] BETWEEN <value> AND <value>, <value> IS [NOT] NULL
+func (p *queryParser) parseCondition() (Condition, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return Condition{}, err
+	}
+
+	condition := Condition{Left: left, Pos: left.Pos}
+
+	if p.current().Type == tokenOperator {
+		condition.Operator = p.advance().Text
+
+		// % was the original LIKE operator, ~ matches a regular expression
+		switch condition.Operator {
+		case "%":
+			condition.Operator = "LIKE"
+		case "<>":
+			condition.Operator = "!="
+		}
+
+		condition.Right, err = p.parseOperand()
+		return condition, err
+	}
+
+	if p.acceptKeyword("IS") {
+		if p.acceptKeyword("NOT") {
+			condition.Operator = "IS NOT NULL"
+		} else {
+			condition.Operator = "IS NULL"
+		}
+
+		return condition, p.expectKeyword("NULL")
+	}
+
+	negated := p.acceptKeyword("NOT")
+
+	switch {
+	case p.acceptKeyword("LIKE"):
+		condition.Operator = "LIKE"
+		condition.Right, err = p.parseOperand()
+
+	case !negated && (p.acceptKeyword("MATCHES") || p.acceptKeyword("REGEXP")):
+		condition.Operator = "~"
+		condition.Right, err = p.parseOperand()
+
+	case p.acceptKeyword("IN"):
+		condition.Operator = "IN"
+		condition.Values, err = p.parseOperandList()
+
+	case p.acceptKeyword("BETWEEN"):
+		condition.Operator = "BETWEEN"
+		condition.Values = make([]Operand, 2)
+
+		condition.Values[0], err = p.parseOperand()
+		if err != nil {
+			return Condition{}, err
+		}
+
+		if err := p.expectKeyword("AND"); err != nil {
+			return Condition{}, err
+		}
+
+		condition.Values[1], err = p.parseOperand()
+
+	default:
+		if negated {
+			return Condition{}, p.unexpected("one of LIKE, IN or BETWEEN")
+		}
+
+		return Condition{}, p.unexpected("an operator")
+	}
+
+	if negated {
+		condition.Operator = "NOT " + condition.Operator
+	}
+
+	return condition, err
+}
+
+// Parse a bracketed, comma separated list of values
+func (p *queryParser) parseOperandList() ([]Operand, error) {
+	if _, err := p.expectType(tokenLeftParen); err != nil {
+		return nil, err
+	}
+
+	operands := []Operand{}
+
+	for {
+		operand, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+
+		operands = append(operands, operand)
+
+		if !p.acceptType(tokenComma) || p.current().Type == tokenRightParen {
+			break
+		}
+	}
+
+	if _, err := p.expectType(tokenRightParen); err != nil {
+		return nil, err
+	}
+
+	return operands, nil
+}
+
+// Converts a number token into a count, failing for negative and decimal numbers
+func parseCountValue(token Token) (int, bool) {
+	value, err := parseLiteral(token)
+	count, isInt := value.(int)
+
+	return count, err == nil && isInt && count >= 0
+}
+
+// Parse a single identifier, string or number
+func (p *queryParser) parseOperand() (Operand, error) {
+	token := p.current()
+
+	if p.isAggregateCall() {
+		if !p.allowAggregates {
+			return Operand{}, &QueryError{Pos: token.Pos, Message: "aggregate functions can only be used in the column list, HAVING and SORT BY"}
+		}
+
+		aggregate, err := p.parseAggregateCall()
+		if err != nil {
+			return Operand{}, err
+		}
+
+		return Operand{Kind: tokenIdentifier, Text: aggregate.name(), Value: aggregate.name(), Aggregate: &aggregate, Pos: token.Pos}, nil
+	}
+
+	// Qualified column names are kept as a single identifier, e.g. Orders.Amount
+	if token.Type == tokenIdentifier && p.tokens[p.index+1].Type == tokenDot {
+		column, err := p.parseColumnName(false)
+		if err != nil {
+			return Operand{}, err
+		}
+
+		return Operand{Kind: tokenIdentifier, Text: column, Value: column, Pos: token.Pos}, nil
+	}
+
+	switch token.Type {
+	case tokenIdentifier, tokenString, tokenNumber:
+		value, err := parseLiteral(token)
+		if err != nil {
+			return Operand{}, err
+		}
+
+		p.advance()
+		return Operand{Kind: token.Type, Text: token.Text, Value: value, Pos: token.Pos}, nil
+	}
+
+	return Operand{}, p.unexpected("a value")
+}
+
+// Convert a parsed statement into the DBQuery object used to run it against the tables
+func buildDBQuery(statement Statement) DBQuery {
+	query := DBQuery{
+		Operation:     statement.operation(),
+		ColumnNames:   []string{},
+		OptionsClause: map[string]any{},
+	}
+
+	var assignments []Assignment
+
+	switch s := statement.(type) {
+	case *PullStatement:
+		query.TableName = s.Table
+		query.TableAlias = s.Alias
+		query.Joins = s.Joins
+		query.WhereClause = s.Where
+		query.GroupBy = s.GroupBy
+		query.HavingClause = s.Having
+
+		for _, item := range s.Columns {
+			query.ColumnNames = append(query.ColumnNames, item.name())
+			if item.Aggregate != nil {
+				query.Aggregates = appendAggregate(query.Aggregates, *item.Aggregate)
+			}
+		}
+
+		for _, aggregate := range whereAggregates(s.Having) {
+			query.Aggregates = appendAggregate(query.Aggregates, aggregate)
+		}
+
+		for _, item := range s.Sort {
+			if item.Aggregate != nil {
+				query.Aggregates = appendAggregate(query.Aggregates, *item.Aggregate)
+			}
+		}
+
+		query.SortClause = s.Sort
+		query.Limit = s.Limit
+		query.Offset = s.Offset
+	case *PushStatement:
+		query.TableName = s.Table
+		assignments = s.Assignments
+	case *PutStatement:
+		query.TableName = s.Table
+		assignments = s.Assignments
+		query.WhereClause = s.Where
+	case *DeleteStatement:
+		query.TableName = s.Table
+		query.WhereClause = s.Where
+	case *CreateTableStatement:
+		query.TableName = s.Table
+		query.Definition = &TableDefinition{Columns: s.Columns, PrimaryKey: s.PrimaryKey, AutoIncrement: s.AutoIncrement, ForeignKeys: s.ForeignKeys}
+	case *DropTableStatement:
+		query.TableName = s.Table
+	case *AlterTableStatement:
+		query.TableName = s.Table
+		query.Definition = &TableDefinition{Columns: []ColumnConfig{s.Column}, AlterAction: s.Action, NewColumnName: s.NewName}
+	case *CreateIndexStatement:
+		query.TableName = s.Table
+		query.Index = &IndexDefinition{Name: s.Name, Column: s.Column, Kind: s.Kind}
+	case *DropIndexStatement:
+		query.TableName = s.Table
+		query.Index = &IndexDefinition{Name: s.Name}
+	case *CreateDatabaseStatement:
+		query.Database = s.Name
+	case *UseStatement:
+		query.Database = s.Database
+	case *ShowStatement:
+		query.ShowTarget = s.Target
+	case *DescribeStatement:
+		query.TableName = s.Table
+	}
+
+	for _, assignment := range assignments {
+		query.OptionsClause[assignment.Column] = assignment.Value.Value
+	}
+
+	return query
+}
+
+// Adds an aggregate to the list, skipping it if the same one is already there
+func appendAggregate(aggregates []AggregateCall, aggregate AggregateCall) []AggregateCall {
+	if slices.Contains(aggregates, aggregate) {
+		return aggregates
+	}
+
+	return append(aggregates, aggregate)
+}
+
+// Finds every aggregate used within a WHERE or HAVING expression
+func whereAggregates(where WhereExpr) []AggregateCall {
+	aggregates := []AggregateCall{}
+
+	switch expr := where.(type) {
+	case *LogicalCondition:
+		aggregates = append(aggregates, whereAggregates(expr.Left)...)
+		aggregates = append(aggregates, whereAggregates(expr.Right)...)
+	case *NotCondition:
+		aggregates = append(aggregates, whereAggregates(expr.Expr)...)
+	case *Condition:
+		for _, operand := range append([]Operand{expr.Left, expr.Right}, expr.Values...) {
+			if operand.Aggregate != nil {
+				aggregates = append(aggregates, *operand.Aggregate)
+			}
+		}
+	}
+
+	return aggregates
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// test the queryBreakdown function against the query shapes the old string splitting couldn't handle
+func Test_queryBreakdown(t *testing.T) {
+	testTemplates := []TestTemplate{
+		{
+			TestName: "Test push without spaces around the operator",
+			Inputs: map[string]any{
+				"query": "PUSH Username=Admin, Password=admin TO Users",
+			},
+			ExpectedOutput: DBQuery{
+				TableName:     "Users",
+				ColumnNames:   []string{},
+				Operation:     "PUSH",
+				OptionsClause: map[string]any{"Username": "Admin", "Password": "admin"},
+			},
+		},
+		{
+			TestName: "Test push with quoted values and a trailing comma",
+			Inputs: map[string]any{
+				"query": "PUSH Username = 'Jane Doe', Password = \"a, b\", TO Users",
+			},
+			ExpectedOutput: DBQuery{
+				TableName:     "Users",
+				ColumnNames:   []string{},
+				Operation:     "PUSH",
+				OptionsClause: map[string]any{"Username": "Jane Doe", "Password": "a, b"},
+			},
+		},
+		{
+			TestName: "Test pull with where clause",
+			Inputs: map[string]any{
+				"query": "PULL Username, Password FROM Users WHERE Username = Admin AND User_ID=1",
+				"where": "(Username = Admin AND User_ID = 1)",
+			},
+			ExpectedOutput: DBQuery{
+				TableName:     "Users",
+				ColumnNames:   []string{"Username", "Password"},
+				Operation:     "PULL",
+				OptionsClause: map[string]any{},
+			},
+		},
+		{
+			TestName: "Test delete with a trailing semicolon",
+			Inputs: map[string]any{
+				"query": "delete from Users where Username = 'Admin';",
+				"where": "Username = 'Admin'",
+			},
+			ExpectedOutput: DBQuery{
+				TableName:     "Users",
+				ColumnNames:   []string{},
+				Operation:     "DELETE",
+				OptionsClause: map[string]any{},
+			},
+		},
+		{
+			TestName: "Test typed literals",
+			Inputs: map[string]any{
+				"query": "PUSH User_ID = 5, Balance = -2.5, Active = true, Nickname = null, Code = '5' TO Users",
+			},
+			ExpectedOutput: DBQuery{
+				TableName:     "Users",
+				ColumnNames:   []string{},
+				Operation:     "PUSH",
+				OptionsClause: map[string]any{"User_ID": 5, "Balance": -2.5, "Active": true, "Nickname": nil, "Code": "5"},
+			},
+		},
+		{
+			TestName: "Test where precedence and grouping",
+			Inputs: map[string]any{
+				"query": "PULL * FROM Users WHERE NOT Role = admin OR Role = owner AND (Active = true OR Age >= 18), Name IS NOT NULL",
+				"where": "(NOT Role = admin OR ((Role = owner AND (Active = true OR Age >= 18)) AND Name IS NOT NULL))",
+			},
+			ExpectedOutput: DBQuery{
+				TableName:     "Users",
+				ColumnNames:   []string{"*"},
+				Operation:     "PULL",
+				OptionsClause: map[string]any{},
+			},
+		},
+		{
+			TestName: "Test joins with aliases and qualified columns",
+			Inputs: map[string]any{
+				"query": "PULL c.Name, o.* FROM Customers AS c CROSS JOIN Orders o WHERE o.Amount > 5",
+				"where": "o.Amount > 5",
+			},
+			ExpectedOutput: DBQuery{
+				TableName:     "Customers",
+				TableAlias:    "c",
+				Joins:         []JoinClause{{Type: "CROSS", Table: "Orders", Alias: "o"}},
+				ColumnNames:   []string{"c.Name", "o.*"},
+				Operation:     "PULL",
+				OptionsClause: map[string]any{},
+			},
+		},
+		{
+			TestName: "Test join without an on condition",
+			IsError:  true,
+			Inputs: map[string]any{
+				"query": "PULL * FROM Customers LEFT JOIN Orders WHERE Amount > 5",
+			},
+			ExpectedOutput: "line 1, column 40: expected ON but found \"WHERE\"",
+		},
+		{
+			TestName: "Test create table",
+			Inputs: map[string]any{
+				"query": "CREATE TABLE Orders (Order_ID int, Customer string NOT NULL, Amount float, Notes bytes NULL, PRIMARY KEY Order_ID AUTO)",
+			},
+			ExpectedOutput: DBQuery{
+				TableName:     "Orders",
+				ColumnNames:   []string{},
+				Operation:     "CREATE",
+				OptionsClause: map[string]any{},
+				Definition: &TableDefinition{
+					Columns: []ColumnConfig{
+						{ColumnName: "Order_ID", ColumnType: "int", Nullable: false},
+						{ColumnName: "Customer", ColumnType: "string", Nullable: false},
+						{ColumnName: "Amount", ColumnType: "float64", Nullable: true},
+						{ColumnName: "Notes", ColumnType: "[]byte", Nullable: true},
+					},
+					PrimaryKey:    "Order_ID",
+					AutoIncrement: true,
+				},
+			},
+		},
+		{
+			TestName: "Test create table with constraints",
+			Inputs: map[string]any{
+				"query": "CREATE TABLE Users (User_ID string DEFAULT uuid(), Email string UNIQUE NOT NULL, Age int CHECK (Age >= 18), Role string DEFAULT 'member', Joined time DEFAULT now(), PRIMARY KEY User_ID)",
+			},
+			ExpectedOutput: DBQuery{
+				TableName:     "Users",
+				ColumnNames:   []string{},
+				Operation:     "CREATE",
+				OptionsClause: map[string]any{},
+				Definition: &TableDefinition{
+					Columns: []ColumnConfig{
+						{ColumnName: "User_ID", ColumnType: "string", Nullable: false, Default: &ColumnDefault{Function: "uuid"}},
+						{ColumnName: "Email", ColumnType: "string", Nullable: false, Unique: true},
+						{ColumnName: "Age", ColumnType: "int", Nullable: true, Check: "Age >= 18"},
+						{ColumnName: "Role", ColumnType: "string", Nullable: true, Default: &ColumnDefault{Value: "member"}},
+						{ColumnName: "Joined", ColumnType: "time", Nullable: true, Default: &ColumnDefault{Function: "now"}},
+					},
+					PrimaryKey: "User_ID",
+				},
+			},
+		},
+		{
+			TestName: "Test create table with foreign keys",
+			Inputs: map[string]any{
+				"query": "CREATE TABLE Orders (Order_ID int, Customer_ID int NOT NULL, Agent string, PRIMARY KEY Order_ID, FOREIGN KEY Customer_ID REFERENCES Customers (Customer_ID) ON DELETE CASCADE, FOREIGN KEY Agent REFERENCES Agents (Name))",
+			},
+			ExpectedOutput: DBQuery{
+				TableName:     "Orders",
+				ColumnNames:   []string{},
+				Operation:     "CREATE",
+				OptionsClause: map[string]any{},
+				Definition: &TableDefinition{
+					Columns: []ColumnConfig{
+						{ColumnName: "Order_ID", ColumnType: "int", Nullable: false},
+						{ColumnName: "Customer_ID", ColumnType: "int", Nullable: false},
+						{ColumnName: "Agent", ColumnType: "string", Nullable: true},
+					},
+					PrimaryKey: "Order_ID",
+					ForeignKeys: []ForeignKey{
+						{Column: "Customer_ID", ReferencedTable: "Customers", ReferencedColumn: "Customer_ID", OnDelete: "CASCADE"},
+						{Column: "Agent", ReferencedTable: "Agents", ReferencedColumn: "Name", OnDelete: "RESTRICT"},
+					},
+				},
+			},
+		},
+		{
+			TestName: "Test set null foreign key on a not null column",
+			IsError:  true,
+			Inputs: map[string]any{
+				"query": "CREATE TABLE Orders (Order_ID int, Customer_ID int NOT NULL, PRIMARY KEY Order_ID, FOREIGN KEY Customer_ID REFERENCES Customers (Customer_ID) ON DELETE SET NULL)",
+			},
+			ExpectedOutput: "line 1, column 14: FOREIGN KEY column Customer_ID can't use ON DELETE SET NULL, as it is NOT NULL",
+		},
+		{
+			TestName: "Test default function of the wrong type",
+			IsError:  true,
+			Inputs: map[string]any{
+				"query": "CREATE TABLE Users (User_ID int DEFAULT now(), PRIMARY KEY User_ID)",
+			},
+			ExpectedOutput: "line 1, column 41: now() can only be the default of a time column",
+		},
+		{
+			TestName: "Test default value of the wrong type",
+			IsError:  true,
+			Inputs: map[string]any{
+				"query": "CREATE TABLE Users (User_ID int DEFAULT 'one', PRIMARY KEY User_ID)",
+			},
+			ExpectedOutput: "line 1, column 41: invalid default value: value one of type string does not match column type int",
+		},
+		{
+			TestName: "Test alter table rename",
+			Inputs: map[string]any{
+				"query": "ALTER TABLE Orders RENAME COLUMN Amount TO Total",
+			},
+			ExpectedOutput: DBQuery{
+				TableName:     "Orders",
+				ColumnNames:   []string{},
+				Operation:     "ALTER",
+				OptionsClause: map[string]any{},
+				Definition: &TableDefinition{
+					Columns:       []ColumnConfig{{ColumnName: "Amount"}},
+					AlterAction:   "RENAME",
+					NewColumnName: "Total",
+				},
+			},
+		},
+		{
+			TestName: "Test create ordered index",
+			Inputs: map[string]any{
+				"query": "CREATE INDEX Orders_By_Amount ON Orders (Amount) USING ORDERED",
+			},
+			ExpectedOutput: DBQuery{
+				TableName:     "Orders",
+				ColumnNames:   []string{},
+				Operation:     "CREATE",
+				OptionsClause: map[string]any{},
+				Index:         &IndexDefinition{Name: "Orders_By_Amount", Column: "Amount", Kind: "ORDERED"},
+			},
+		},
+		{
+			TestName: "Test drop index",
+			Inputs: map[string]any{
+				"query": "DROP INDEX Orders_By_Amount ON Orders",
+			},
+			ExpectedOutput: DBQuery{
+				TableName:     "Orders",
+				ColumnNames:   []string{},
+				Operation:     "DROP",
+				OptionsClause: map[string]any{},
+				Index:         &IndexDefinition{Name: "Orders_By_Amount"},
+			},
+		},
+		{
+			TestName: "Test create index with an unknown kind",
+			IsError:  true,
+			Inputs: map[string]any{
+				"query": "CREATE INDEX Orders_By_Amount ON Orders (Amount) USING BTREE",
+			},
+			ExpectedOutput: "line 1, column 56: expected one of HASH or ORDERED but found \"BTREE\"",
+		},
+		{
+			TestName: "Test rollback transaction",
+			Inputs: map[string]any{
+				"query": "ROLLBACK TRANSACTION",
+			},
+			ExpectedOutput: DBQuery{
+				ColumnNames:   []string{},
+				Operation:     "ROLLBACK",
+				OptionsClause: map[string]any{},
+			},
+		},
+		{
+			TestName: "Test create database",
+			Inputs: map[string]any{
+				"query": "CREATE DATABASE Sales",
+			},
+			ExpectedOutput: DBQuery{
+				ColumnNames:   []string{},
+				Operation:     "CREATE",
+				OptionsClause: map[string]any{},
+				Database:      "Sales",
+			},
+		},
+		{
+			TestName: "Test use database",
+			Inputs: map[string]any{
+				"query": "USE Sales",
+			},
+			ExpectedOutput: DBQuery{
+				ColumnNames:   []string{},
+				Operation:     "USE",
+				OptionsClause: map[string]any{},
+				Database:      "Sales",
+			},
+		},
+		{
+			TestName: "Test show tables",
+			Inputs: map[string]any{
+				"query": "SHOW TABLES",
+			},
+			ExpectedOutput: DBQuery{
+				ColumnNames:   []string{},
+				Operation:     "SHOW",
+				OptionsClause: map[string]any{},
+				ShowTarget:    "TABLES",
+			},
+		},
+		{
+			TestName: "Test describe table",
+			Inputs: map[string]any{
+				"query": "DESCRIBE Orders",
+			},
+			ExpectedOutput: DBQuery{
+				TableName:     "Orders",
+				ColumnNames:   []string{},
+				Operation:     "DESCRIBE",
+				OptionsClause: map[string]any{},
+			},
+		},
+		{
+			TestName: "Test show with an unknown target",
+			IsError:  true,
+			Inputs: map[string]any{
+				"query": "SHOW INDEXES",
+			},
+			ExpectedOutput: "line 1, column 6: expected one of DATABASES or TABLES but found \"INDEXES\"",
+		},
+		{
+			TestName: "Test create table without a primary key",
+			IsError:  true,
+			Inputs: map[string]any{
+				"query": "CREATE TABLE Orders (Order_ID int)",
+			},
+			ExpectedOutput: "line 1, column 14: table Orders needs a PRIMARY KEY",
+		},
+		{
+			TestName: "Test create table with an unknown type",
+			IsError:  true,
+			Inputs: map[string]any{
+				"query": "CREATE TABLE Orders (Order_ID integer, PRIMARY KEY Order_ID)",
+			},
+			ExpectedOutput: "line 1, column 31: integer is not a supported column type, expected one of int, float64, string, bool, bytes, time",
+		},
+		{
+			TestName: "Test unclosed bracket",
+			IsError:  true,
+			Inputs: map[string]any{
+				"query": "PULL * FROM Users WHERE (Role = admin OR Role = owner",
+			},
+			ExpectedOutput: "line 1, column 54: expected ')' but reached the end of the query",
+		},
+		{
+			TestName: "Test aggregate within where",
+			IsError:  true,
+			Inputs: map[string]any{
+				"query": "PULL Customer FROM Orders WHERE COUNT(*) > 1",
+			},
+			ExpectedOutput: "line 1, column 33: aggregate functions can only be used in the column list, HAVING and SORT BY",
+		},
+		{
+			TestName: "Test negative limit",
+			IsError:  true,
+			Inputs: map[string]any{
+				"query": "PULL * FROM Users SORT BY Username LIMIT -1",
+			},
+			ExpectedOutput: "line 1, column 42: LIMIT must be a whole number of zero or more, got: -1",
+		},
+		{
+			TestName: "Test short push returns an error instead of panicking",
+			IsError:  true,
+			Inputs: map[string]any{
+				"query": "PUSH Username =",
+			},
+			ExpectedOutput: "line 1, column 16: expected a value but reached the end of the query",
+		},
+		{
+			TestName: "Test put without a where clause",
+			IsError:  true,
+			Inputs: map[string]any{
+				"query": "PUT Password = admin TO Users",
+			},
+			ExpectedOutput: "line 1, column 30: expected WHERE but reached the end of the query",
+		},
+		{
+			TestName: "Test error position on a later line",
+			IsError:  true,
+			Inputs: map[string]any{
+				"query": "PULL Username\nFROM Users\nWHERE Username = 'Admin",
+			},
+			ExpectedOutput: "line 3, column 18: string literal was not closed",
+		},
+		{
+			TestName: "Test unknown operation",
+			IsError:  true,
+			Inputs: map[string]any{
+				"query": "FETCH Username FROM Users",
+			},
+			ExpectedOutput: "line 1, column 1: expected one of PULL, PUSH, PUT, DELETE, CREATE, DROP, ALTER, BEGIN, COMMIT, ROLLBACK, USE, SHOW or DESCRIBE but found \"FETCH\"",
+		},
+	}
+
+	for _, test := range testTemplates {
+		t.Run(test.TestName, func(t *testing.T) {
+			query, err := queryBreakdown(test.Inputs["query"].(string))
+
+			if test.IsError {
+				if err == nil || err.Error() != test.ExpectedOutput.(string) {
+					t.Fatalf("error result was incorrect, got: %v, expected: %v", err, test.ExpectedOutput)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// The where clause is checked through its string form, rather than the full expression tree
+			where := ""
+			if query.WhereClause != nil {
+				where = query.WhereClause.String()
+				query.WhereClause = nil
+			}
+
+			if expectedWhere, _ := test.Inputs["where"].(string); where != expectedWhere {
+				t.Fatalf("where clause was incorrect, got: %v, expected: %v", where, expectedWhere)
+			}
+
+			if !reflect.DeepEqual(query, test.ExpectedOutput) {
+				t.Fatalf("result was incorrect, got: %+v, expected: %+v", query, test.ExpectedOutput)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+// The result of running a query
+// ** PULL fills Columns and Rows, PUSH, PUT and DELETE fill RowsAffected, and PUSH also sets LastInsertID
+type ResultSet struct {
+	Operation    string
+	Columns      []ResultColumn
+	Rows         [][]any
+	RowsAffected int
+	LastInsertID any
+}
+
+// Describes a single column of a ResultSet
+type ResultColumn struct {
+	Name string
+	Type string
+}
+
+// Longest value that will be printed to the terminal before being cut short
+const maxPrintedValueLength = 40
+
+// Builds the result set for a PULL query against the table
+func (table *DBTable) pullResultSet(query DBQuery) (ResultSet, error) {
+	if query.isGrouped() {
+		return table.groupedResultSet(query)
+	}
+
+	headers, headerErr := table.getColumnHeaders(query.ColumnNames)
+	if headerErr != nil {
+		return ResultSet{}, headerErr
+	}
+
+	rows, selectErr := table.selectRows(query)
+	if selectErr != nil {
+		return ResultSet{}, selectErr
+	}
+
+	result := ResultSet{Operation: query.Operation, Columns: []ResultColumn{}, Rows: [][]any{}}
+
+	for _, header := range headers {
+		config, _ := table.getColumnConfig(header)
+		result.Columns = append(result.Columns, ResultColumn{Name: header, Type: config.ColumnType})
+	}
+
+	for _, row := range rows {
+		_, rowValues := row.getRowValue(headers)
+		result.Rows = append(result.Rows, rowValues)
+	}
+
+	return result, nil
+}
+
+// Returns the names of each column in the result set
+func (rs *ResultSet) columnNames() []string {
+	names := []string{}
+	for _, column := range rs.Columns {
+		names = append(names, column.Name)
+	}
+
+	return names
+}
+
+// Returns a row of the result set as a map of column names to values
+func (rs *ResultSet) rowMap(rowIndex int) map[string]any {
+	row := map[string]any{}
+	for index, column := range rs.Columns {
+		row[column.Name] = rs.Rows[rowIndex][index]
+	}
+
+	return row
+}
+
+// Formats the result set as a text table, ready to be shown in a terminal
+func (rs *ResultSet) format() string {
+	if rs.Operation != "PULL" {
+		return fmt.Sprintf("%v completed successfully, %v row(s) affected.", rs.Operation, rs.RowsAffected)
+	}
+
+	// Work out the width of each column from its header and longest value
+	cells := [][]string{}
+	widths := []int{}
+	for _, column := range rs.Columns {
+		widths = append(widths, utf8.RuneCountInString(column.Name))
+	}
+
+	for _, row := range rs.Rows {
+		cellRow := []string{}
+		for index, value := range row {
+			cell := formatValue(value)
+			widths[index] = max(widths[index], utf8.RuneCountInString(cell))
+			cellRow = append(cellRow, cell)
+		}
+		cells = append(cells, cellRow)
+	}
+
+	var builder strings.Builder
+	builder.WriteString(formatRow(rs.columnNames(), widths))
+	builder.WriteString("\n")
+
+	dividers := []string{}
+	for _, width := range widths {
+		dividers = append(dividers, strings.Repeat("-", width))
+	}
+	builder.WriteString(strings.Join(dividers, "-+-"))
+
+	for _, cellRow := range cells {
+		builder.WriteString("\n")
+		builder.WriteString(formatRow(cellRow, widths))
+	}
+
+	builder.WriteString(fmt.Sprintf("\n(%v row(s))", len(rs.Rows)))
+	return builder.String()
+}
+
+// Pads each cell out to the width of its column and joins them together
+func formatRow(cells []string, widths []int) string {
+	padded := []string{}
+	for index, cell := range cells {
+		padded = append(padded, cell+strings.Repeat(" ", widths[index]-utf8.RuneCountInString(cell)))
+	}
+
+	return strings.TrimRight(strings.Join(padded, " | "), " ")
+}
+
+// Converts a value for display, cutting long values short
+func formatValue(value any) string {
+	if value == nil {
+		return "NULL"
+	}
+
+	text := strings.ReplaceAll(valueToString(value), "\n", " ")
+	if utf8.RuneCountInString(text) > maxPrintedValueLength {
+		text = string([]rune(text)[:maxPrintedValueLength-3]) + "..."
+	}
+
+	return text
+}
+
+// Prints the result set to the terminal
+func printResultSet(rs ResultSet) {
+	for _, line := range strings.Split(rs.format(), "\n") {
+		log.Println(line)
+	}
+}
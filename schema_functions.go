@@ -0,0 +1,374 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+)
+
+// Runs a CREATE TABLE, DROP TABLE or ALTER TABLE query
+func (db *DB) runSchemaQuery(query DBQuery) (ResultSet, error) {
+	result := ResultSet{Operation: query.Operation}
+
+	if query.Index != nil {
+		return db.runIndexQuery(query)
+	}
+
+	switch query.Operation {
+	case "CREATE":
+		createErr := db.createTableFromDefinition(query.TableName, *query.Definition)
+		if createErr != nil {
+			return ResultSet{}, createErr
+		}
+
+	case "DROP":
+		droppedRows, dropErr := db.dropTable(query.TableName)
+		if dropErr != nil {
+			return ResultSet{}, dropErr
+		}
+
+		result.RowsAffected = droppedRows
+
+	case "ALTER":
+		loadTableErr := db.loadQueryTable(query.TableName)
+		if loadTableErr != nil {
+			return ResultSet{}, loadTableErr
+		}
+
+		relatedErr := db.loadRelatedTables(query.TableName)
+		if relatedErr != nil {
+			return ResultSet{}, relatedErr
+		}
+
+		tableIndex, tableErr := db.getTable(query.TableName)
+		if tableErr != nil {
+			return ResultSet{}, tableErr
+		}
+
+		alteredRows, alterErr := db.Tables[tableIndex].alterTable(*query.Definition)
+		if alterErr != nil {
+			return ResultSet{}, alterErr
+		}
+
+		// Logged changes are replayed against the column layout of the snapshot, so it has to be saved straight away
+		checkpointErr := db.Tables[tableIndex].checkpoint()
+		if checkpointErr != nil {
+			return ResultSet{}, checkpointErr
+		}
+
+		result.RowsAffected = alteredRows
+
+	default:
+		return ResultSet{}, fmt.Errorf("%v is not a table definition operation", query.Operation)
+	}
+
+	return result, nil
+}
+
+// Creates and attaches a new, empty table from the definition given to CREATE TABLE
+// ** The table is saved straight away, so changes made to it can be logged
+func (db *DB) createTableFromDefinition(tableName string, definition TableDefinition) error {
+	if _, existingErr := db.getTable(tableName); existingErr == nil {
+		return fmt.Errorf("a table already exists with the name: %v", tableName)
+	}
+
+	if _, statErr := os.Stat(storePath(db.databaseName(), tableName, "dat")); statErr == nil {
+		return fmt.Errorf("a table already exists with the name: %v", tableName)
+	}
+
+	table := DBTable{
+		Name:                 tableName,
+		ColumnConfig:         definition.Columns,
+		PrimaryKeyColumnName: definition.PrimaryKey,
+		ForeignKeys:          definition.ForeignKeys,
+		database:             db.databaseName(),
+		AutoIncrementPrimary: definition.AutoIncrement,
+		NextID:               1,
+		RowValues:            []RowValue{},
+	}
+
+	// Tables referenced by the new table are told about it first, so a failure part way through can only leave a table
+	// referenced by one that doesn't exist, rather than a table that doesn't know about its references
+	referenceErr := db.addReferences(&table)
+	if referenceErr != nil {
+		return referenceErr
+	}
+
+	checkpointErr := table.checkpoint()
+	if checkpointErr != nil {
+		return checkpointErr
+	}
+
+	// Clear out any log or indexes left behind by an older table with the same name, so they aren't loaded into this one
+	for _, extension := range []string{"wal", "idx"} {
+		removeErr := os.Remove(storePath(db.databaseName(), tableName, extension))
+		if removeErr != nil && !errors.Is(removeErr, fs.ErrNotExist) {
+			return fmt.Errorf("failed to clear the old files for table %v: %w", tableName, removeErr)
+		}
+	}
+
+	sequenceErr := clearLogSequence(table.identity())
+	if sequenceErr != nil {
+		return sequenceErr
+	}
+
+	logErr := table.openLog()
+	if logErr != nil {
+		return logErr
+	}
+
+	db.attachTable(table)
+	return nil
+}
+
+// Removes a table from memory and deletes its store file, returning the number of rows it held
+func (db *DB) dropTable(tableName string) (int, error) {
+	loadTableErr := db.loadQueryTable(tableName)
+	if loadTableErr != nil {
+		return 0, loadTableErr
+	}
+
+	relatedErr := db.loadRelatedTables(tableName)
+	if relatedErr != nil {
+		return 0, relatedErr
+	}
+
+	tableIndex, tableErr := db.getTable(tableName)
+	if tableErr != nil {
+		return 0, tableErr
+	}
+
+	for _, childName := range db.Tables[tableIndex].ReferencedBy {
+		if childName != tableName {
+			return 0, fmt.Errorf("table %v cannot be dropped, as table %v has a foreign key to it", tableName, childName)
+		}
+	}
+
+	closeErr := db.Tables[tableIndex].closeLog()
+	if closeErr != nil {
+		return 0, closeErr
+	}
+
+	// The data key is destroyed first, so anything left of the files on disk can't be read even if removing them fails
+	shredErr := shredEnvelope(storePath(db.databaseName(), tableName, "dat"))
+	if shredErr != nil {
+		return 0, shredErr
+	}
+
+	// A table created from Go since the database was loaded won't have been saved to file yet
+	for _, extension := range []string{"dat", "wal", "idx"} {
+		removeErr := os.Remove(storePath(db.databaseName(), tableName, extension))
+		if removeErr != nil && !errors.Is(removeErr, fs.ErrNotExist) {
+			return 0, fmt.Errorf("failed to delete the store files for table %v: %w", tableName, removeErr)
+		}
+	}
+
+	droppedRows := len(db.Tables[tableIndex].RowValues)
+	foreignKeys := db.Tables[tableIndex].ForeignKeys
+	db.Tables = slices.Delete(db.Tables, tableIndex, tableIndex+1)
+
+	catalogErr := removeFromCatalog(db.databaseName(), tableName)
+	if catalogErr != nil {
+		return 0, catalogErr
+	}
+
+	identity := tableIdentity(db.databaseName(), tableName)
+	generationErr := recordGenerations(identity)
+	if generationErr != nil {
+		return 0, generationErr
+	}
+
+	sequenceErr := clearLogSequence(identity)
+	if sequenceErr != nil {
+		return 0, sequenceErr
+	}
+
+	// The tables it referenced are updated once the table is gone, for the same reason as when it was created
+	referenceErr := db.removeReferences(tableName, foreignKeys)
+	if referenceErr != nil {
+		return 0, referenceErr
+	}
+
+	return droppedRows, nil
+}
+
+// Applies an ALTER TABLE change to the table, rewriting every existing row to match
+func (table *DBTable) alterTable(definition TableDefinition) (int, error) {
+	column := definition.Columns[0]
+	columnIndex := slices.IndexFunc(table.ColumnConfig, func(config ColumnConfig) bool { return config.ColumnName == column.ColumnName })
+
+	// The UNIQUE column indexes are keyed by column name, so they are rebuilt after any change to the columns
+	table.uniqueIndexes = nil
+
+	switch definition.AlterAction {
+	case "ADD":
+		if columnIndex != -1 {
+			return 0, fmt.Errorf("column %v already exists in table %v", column.ColumnName, table.Name)
+		}
+
+		// Existing rows are given the default of the new column, without one they would break the NOT NULL straight away
+		if !column.Nullable && column.Default == nil && len(table.RowValues) > 0 {
+			return 0, fmt.Errorf("column %v cannot be added as NOT NULL without a DEFAULT, as table %v already has rows", column.ColumnName, table.Name)
+		}
+
+		table.ColumnConfig = append(table.ColumnConfig, column)
+		for _, row := range table.RowValues {
+			defaultValue, defaultErr := column.defaultValue()
+			if defaultErr != nil {
+				table.removeColumn(column.ColumnName)
+				return 0, defaultErr
+			}
+
+			row.ColumnValues[column.ColumnName] = defaultValue
+		}
+
+		// The values given to the existing rows have to pass the UNIQUE and CHECK of the new column
+		for rowIndex, row := range table.RowValues {
+			constraintErr := table.confirmConstraints(row.ColumnValues, map[string]any{column.ColumnName: row.ColumnValues[column.ColumnName]}, rowIndex)
+			if constraintErr != nil {
+				table.removeColumn(column.ColumnName)
+				return 0, fmt.Errorf("column %v cannot be added to the existing rows: %w", column.ColumnName, constraintErr)
+			}
+		}
+
+	case "DROP":
+		if columnIndex == -1 {
+			return 0, fmt.Errorf("no column was found with the name %v in table %v", column.ColumnName, table.Name)
+		}
+
+		if column.ColumnName == table.PrimaryKeyColumnName {
+			return 0, fmt.Errorf("the primary key column %v cannot be dropped", column.ColumnName)
+		}
+
+		foreignKeyErr := table.confirmColumnNotReferenced(column.ColumnName, true)
+		if foreignKeyErr != nil {
+			return 0, foreignKeyErr
+		}
+
+		for _, config := range table.ColumnConfig {
+			if config.Check == "" || config.ColumnName == column.ColumnName {
+				continue
+			}
+
+			usesColumn, checkErr := checkUsesColumn(config.Check, column.ColumnName)
+			if checkErr != nil {
+				return 0, checkErr
+			}
+
+			if usesColumn {
+				return 0, fmt.Errorf("column %v cannot be dropped, as it is used by the CHECK of column %v", column.ColumnName, config.ColumnName)
+			}
+		}
+
+		table.removeColumn(column.ColumnName)
+
+	case "RENAME":
+		if columnIndex == -1 {
+			return 0, fmt.Errorf("no column was found with the name %v in table %v", column.ColumnName, table.Name)
+		}
+
+		if slices.ContainsFunc(table.ColumnConfig, func(config ColumnConfig) bool { return config.ColumnName == definition.NewColumnName }) {
+			return 0, fmt.Errorf("column %v already exists in table %v", definition.NewColumnName, table.Name)
+		}
+
+		foreignKeyErr := table.confirmColumnNotReferenced(column.ColumnName, false)
+		if foreignKeyErr != nil {
+			return 0, foreignKeyErr
+		}
+
+		// CHECK expressions refer to columns by name, so they are rewritten before anything is renamed
+		renamedChecks := make([]string, len(table.ColumnConfig))
+		for index, config := range table.ColumnConfig {
+			if config.Check == "" {
+				continue
+			}
+
+			renamedCheck, checkErr := renameCheckColumn(config.Check, column.ColumnName, definition.NewColumnName)
+			if checkErr != nil {
+				return 0, checkErr
+			}
+
+			renamedChecks[index] = renamedCheck
+		}
+
+		for index := range table.ColumnConfig {
+			table.ColumnConfig[index].Check = renamedChecks[index]
+		}
+
+		table.ColumnConfig[columnIndex].ColumnName = definition.NewColumnName
+		if table.PrimaryKeyColumnName == column.ColumnName {
+			table.PrimaryKeyColumnName = definition.NewColumnName
+		}
+
+		for _, row := range table.RowValues {
+			row.ColumnValues[definition.NewColumnName] = row.ColumnValues[column.ColumnName]
+			delete(row.ColumnValues, column.ColumnName)
+		}
+
+		for _, index := range table.indexes {
+			if index.Column == column.ColumnName {
+				index.Column = definition.NewColumnName
+			}
+		}
+
+		for index, foreignKey := range table.ForeignKeys {
+			if foreignKey.Column == column.ColumnName {
+				table.ForeignKeys[index].Column = definition.NewColumnName
+			}
+		}
+
+	default:
+		return 0, fmt.Errorf("%v is not a supported ALTER TABLE action", definition.AlterAction)
+	}
+
+	return len(table.RowValues), nil
+}
+
+// Removes a column from the table and every row, dropping any indexes on it
+func (table *DBTable) removeColumn(columnName string) {
+	table.ColumnConfig = slices.DeleteFunc(table.ColumnConfig, func(config ColumnConfig) bool { return config.ColumnName == columnName })
+	for _, row := range table.RowValues {
+		delete(row.ColumnValues, columnName)
+	}
+
+	table.indexes = slices.DeleteFunc(table.indexes, func(index *tableIndex) bool { return index.Column == columnName })
+	table.uniqueIndexes = nil
+}
+
+// Runs a CREATE INDEX or DROP INDEX query, saving the indexes of the table straight away
+func (db *DB) runIndexQuery(query DBQuery) (ResultSet, error) {
+	loadTableErr := db.loadQueryTable(query.TableName)
+	if loadTableErr != nil {
+		return ResultSet{}, loadTableErr
+	}
+
+	tableIndex, tableErr := db.getTable(query.TableName)
+	if tableErr != nil {
+		return ResultSet{}, tableErr
+	}
+
+	table := &db.Tables[tableIndex]
+
+	var indexErr error
+	if query.Operation == "CREATE" {
+		indexErr = table.createIndex(*query.Index)
+	} else {
+		indexErr = table.dropIndex(query.Index.Name)
+	}
+
+	if indexErr != nil {
+		return ResultSet{}, indexErr
+	}
+
+	// ** Tables that haven't been saved to file yet have their indexes saved along with them when the database is closed
+	if table.wal != nil {
+		saveErr := table.saveIndexes()
+		if saveErr != nil {
+			return ResultSet{}, fmt.Errorf("failed to save the indexes for table %v: %w", table.Name, saveErr)
+		}
+	}
+
+	return ResultSet{Operation: query.Operation}, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"math/rand/v2"
+	"slices"
+)
+
+// Highest level a node of a skip list can reach, enough for far more rows than a table can hold in memory
+const skipListMaxLevel = 24
+
+// A sorted list of values with the rows holding each one, used by ORDERED indexes
+// ** Each node is linked on a random number of levels, so finding a value skips over most of the list and takes O(log n)
+type skipList struct {
+	head  *skipListNode
+	level int
+}
+
+type skipListNode struct {
+	value any
+	rows  []int
+	next  []*skipListNode
+}
+
+// A bound of a range scan over a skip list
+type indexBound struct {
+	value     any
+	inclusive bool
+}
+
+func newSkipList() *skipList {
+	return &skipList{head: &skipListNode{next: make([]*skipListNode, skipListMaxLevel)}, level: 1}
+}
+
+// Orders two values held in the same index, which always share a type
+func compareIndexValues(left any, right any) int {
+	comparison, _ := compareValues(left, right)
+	return comparison
+}
+
+// Picks the number of levels for a new node, each level is a quarter as likely as the one below
+func randomSkipListLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.IntN(4) == 0 {
+		level = level + 1
+	}
+
+	return level
+}
+
+// Finds the last node before the value on every level of the list
+func (list *skipList) findPrevious(value any) []*skipListNode {
+	previous := make([]*skipListNode, skipListMaxLevel)
+	node := list.head
+
+	for level := list.level - 1; level >= 0; level-- {
+		for node.next[level] != nil && compareIndexValues(node.next[level].value, value) < 0 {
+			node = node.next[level]
+		}
+
+		previous[level] = node
+	}
+
+	return previous
+}
+
+// Adds a row under the value it holds, keeping the rows of each value in table order
+func (list *skipList) insert(value any, rowIndex int) {
+	previous := list.findPrevious(value)
+
+	if existing := previous[0].next[0]; existing != nil && compareIndexValues(existing.value, value) == 0 {
+		position, _ := slices.BinarySearch(existing.rows, rowIndex)
+		existing.rows = slices.Insert(existing.rows, position, rowIndex)
+		return
+	}
+
+	level := randomSkipListLevel()
+	for ; list.level < level; list.level++ {
+		previous[list.level] = list.head
+	}
+
+	node := &skipListNode{value: value, rows: []int{rowIndex}, next: make([]*skipListNode, level)}
+	for nodeLevel := 0; nodeLevel < level; nodeLevel++ {
+		node.next[nodeLevel] = previous[nodeLevel].next[nodeLevel]
+		previous[nodeLevel].next[nodeLevel] = node
+	}
+}
+
+// Removes a row from under the value it held, unlinking the value once it has no rows left
+func (list *skipList) remove(value any, rowIndex int) {
+	previous := list.findPrevious(value)
+
+	node := previous[0].next[0]
+	if node == nil || compareIndexValues(node.value, value) != 0 {
+		return
+	}
+
+	node.rows = slices.DeleteFunc(node.rows, func(indexedRow int) bool { return indexedRow == rowIndex })
+	if len(node.rows) > 0 {
+		return
+	}
+
+	for nodeLevel := range node.next {
+		if previous[nodeLevel].next[nodeLevel] == node {
+			previous[nodeLevel].next[nodeLevel] = node.next[nodeLevel]
+		}
+	}
+
+	for list.level > 1 && list.head.next[list.level-1] == nil {
+		list.level = list.level - 1
+	}
+}
+
+// Returns the rows holding a value between the bounds in value order, a nil bound leaves that side open
+func (list *skipList) rowsBetween(lower *indexBound, upper *indexBound) []int {
+	node := list.head.next[0]
+	if lower != nil {
+		node = list.findPrevious(lower.value)[0].next[0]
+		if node != nil && !lower.inclusive && compareIndexValues(node.value, lower.value) == 0 {
+			node = node.next[0]
+		}
+	}
+
+	rows := []int{}
+	for ; node != nil; node = node.next[0] {
+		if upper != nil {
+			comparison := compareIndexValues(node.value, upper.value)
+			if comparison > 0 || (comparison == 0 && !upper.inclusive) {
+				break
+			}
+		}
+
+		rows = append(rows, node.rows...)
+	}
+
+	return rows
+}
+
+// Returns every value in the list in order, along with the rows holding it
+func (list *skipList) entries() []indexEntry {
+	entries := []indexEntry{}
+	for node := list.head.next[0]; node != nil; node = node.next[0] {
+		entries = append(entries, indexEntry{Value: node.value, Rows: slices.Clone(node.rows)})
+	}
+
+	return entries
+}
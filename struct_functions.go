@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A column made from a struct field, along with the options set in its untold tag
+type structColumn struct {
+	field      int
+	config     ColumnConfig
+	primaryKey bool
+	auto       bool
+}
+
+// Options that can be given in the untold tag of a struct field, e.g. `untold:"pk,auto"`, or `untold:"-"` to leave the field out
+var structTagOptions = []string{"pk", "auto", "nullable", "unique"}
+
+// Returns the struct type of a value, which can be a struct or a pointer to one
+func structType(value any) (reflect.Type, error) {
+	valueType := reflect.TypeOf(value)
+	for valueType != nil && valueType.Kind() == reflect.Pointer {
+		valueType = valueType.Elem()
+	}
+
+	if valueType == nil || valueType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expected a struct but got: %T", value)
+	}
+
+	return valueType, nil
+}
+
+// Returns the column type used to store values of a Go type, pointers are stored as the type they point to
+func columnTypeOf(fieldType reflect.Type) (string, bool) {
+	if fieldType.Kind() == reflect.Pointer {
+		fieldType = fieldType.Elem()
+	}
+
+	if fieldType == reflect.TypeOf(time.Time{}) {
+		return "time", true
+	}
+
+	switch fieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int", true
+	case reflect.Float32, reflect.Float64:
+		return "float64", true
+	case reflect.String:
+		return "string", true
+	case reflect.Bool:
+		return "bool", true
+	case reflect.Slice:
+		if fieldType.Elem().Kind() == reflect.Uint8 {
+			return "[]byte", true
+		}
+	}
+
+	return "", false
+}
+
+// Reads the exported fields of a struct type into columns, named after each field
+// ** Pointer fields are always nullable, other fields are only nullable when tagged as nullable
+func structColumns(valueType reflect.Type) ([]structColumn, error) {
+	columns := []structColumn{}
+
+	for fieldIndex := range valueType.NumField() {
+		field := valueType.Field(fieldIndex)
+		tag := field.Tag.Get("untold")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+
+		columnType, isSupported := columnTypeOf(field.Type)
+		if !isSupported {
+			return nil, fmt.Errorf("field %v of %v has the type %v, which can't be stored in a column", field.Name, valueType.Name(), field.Type)
+		}
+
+		column := structColumn{
+			field:  fieldIndex,
+			config: ColumnConfig{ColumnName: field.Name, ColumnType: columnType, Nullable: field.Type.Kind() == reflect.Pointer},
+		}
+
+		for _, option := range strings.Split(tag, ",") {
+			switch strings.TrimSpace(option) {
+			case "":
+			case "pk":
+				column.primaryKey = true
+			case "auto":
+				column.auto = true
+			case "nullable":
+				column.config.Nullable = true
+			case "unique":
+				column.config.Unique = true
+			default:
+				return nil, fmt.Errorf("field %v of %v has an unknown untold tag option %v, expected one of %v", field.Name, valueType.Name(), option, strings.Join(structTagOptions, ", "))
+			}
+		}
+
+		columns = append(columns, column)
+	}
+
+	return columns, nil
+}
+
+// Builds the definition of a table from a struct type, which needs exactly one field tagged as the primary key
+func structDefinition(valueType reflect.Type) (TableDefinition, error) {
+	columns, columnsErr := structColumns(valueType)
+	if columnsErr != nil {
+		return TableDefinition{}, columnsErr
+	}
+
+	definition := TableDefinition{Columns: []ColumnConfig{}}
+	for _, column := range columns {
+		if column.auto && (!column.primaryKey || column.config.ColumnType != "int") {
+			return TableDefinition{}, fmt.Errorf("field %v of %v is tagged auto, which can only be used on an int primary key", column.config.ColumnName, valueType.Name())
+		}
+
+		if column.primaryKey {
+			if definition.PrimaryKey != "" {
+				return TableDefinition{}, fmt.Errorf("%v has more than one field tagged as the primary key", valueType.Name())
+			}
+
+			// The primary key can never be null
+			column.config.Nullable = false
+			definition.PrimaryKey = column.config.ColumnName
+			definition.AutoIncrement = column.auto
+		}
+
+		definition.Columns = append(definition.Columns, column.config)
+	}
+
+	if definition.PrimaryKey == "" {
+		return TableDefinition{}, fmt.Errorf("%v needs a field tagged as the primary key, e.g. `untold:\"pk\"`", valueType.Name())
+	}
+
+	return definition, nil
+}
+
+// Creates a table with a column for each exported field of a struct, e.g. db.CreateTableFromStruct("Accounts", Account{})
+// ** The table is created the same way as CREATE TABLE, so is saved straight away
+func (db *DB) CreateTableFromStruct(tableName string, model any) error {
+	valueType, typeErr := structType(model)
+	if typeErr != nil {
+		return typeErr
+	}
+
+	definition, definitionErr := structDefinition(valueType)
+	if definitionErr != nil {
+		return definitionErr
+	}
+
+	query := DBQuery{TableName: tableName, ColumnNames: []string{}, Operation: "CREATE", OptionsClause: map[string]any{}, Definition: &definition}
+	_, queryErr := db.runParsedQuery(query, fmt.Sprintf("CreateTableFromStruct(%v, %v)", tableName, valueType.Name()))
+	return queryErr
+}
+
+// Adds a struct to a table as a new row, the zero value of an auto increment primary key is left for the table to fill
+// ** When given a pointer, the primary key given to the row is set on the struct
+func (db *DB) InsertStruct(tableName string, row any) (ResultSet, error) {
+	valueType, typeErr := structType(row)
+	if typeErr != nil {
+		return ResultSet{}, typeErr
+	}
+
+	columns, columnsErr := structColumns(valueType)
+	if columnsErr != nil {
+		return ResultSet{}, columnsErr
+	}
+
+	rowValue := reflect.Indirect(reflect.ValueOf(row))
+	values := map[string]any{}
+	var autoField reflect.Value
+
+	for _, column := range columns {
+		field := rowValue.Field(column.field)
+		if column.auto && field.IsZero() {
+			autoField = field
+			continue
+		}
+
+		if field.Kind() == reflect.Pointer {
+			if field.IsNil() {
+				values[column.config.ColumnName] = nil
+				continue
+			}
+
+			field = field.Elem()
+		}
+
+		values[column.config.ColumnName] = field.Interface()
+	}
+
+	query := DBQuery{TableName: tableName, ColumnNames: []string{}, Operation: "PUSH", OptionsClause: values}
+	result, queryErr := db.runParsedQuery(query, fmt.Sprintf("InsertStruct(%v, %v)", tableName, valueType.Name()))
+	if queryErr != nil {
+		return ResultSet{}, queryErr
+	}
+
+	if autoField.IsValid() && autoField.CanSet() {
+		setErr := setStructField(autoField, result.LastInsertID)
+		if setErr != nil {
+			return result, fmt.Errorf("the row was added, but its primary key couldn't be set on the struct: %w", setErr)
+		}
+	}
+
+	return result, nil
+}
+
+// Fills a slice of structs from the rows of a PULL result, dest has to be a pointer to a slice of structs or struct pointers
+// ** Columns are matched to fields by name, qualified columns like o.Amount are matched on the part after the dot and
+// columns without a matching field are skipped
+func (result ResultSet) ScanStructs(dest any) error {
+	destValue := reflect.ValueOf(dest)
+	if destValue.Kind() != reflect.Pointer || destValue.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("expected a pointer to a slice of structs but got: %T", dest)
+	}
+
+	sliceValue := destValue.Elem()
+	elemType := sliceValue.Type().Elem()
+	isPointer := elemType.Kind() == reflect.Pointer
+	if isPointer {
+		elemType = elemType.Elem()
+	}
+
+	if elemType.Kind() != reflect.Struct {
+		return fmt.Errorf("expected a pointer to a slice of structs but got: %T", dest)
+	}
+
+	columns, columnsErr := structColumns(elemType)
+	if columnsErr != nil {
+		return columnsErr
+	}
+
+	// Find the field for each column of the result, -1 when there isn't one
+	fieldIndexes := []int{}
+	for _, resultColumn := range result.Columns {
+		name := resultColumn.Name[strings.LastIndex(resultColumn.Name, ".")+1:]
+		columnIndex := slices.IndexFunc(columns, func(column structColumn) bool { return column.config.ColumnName == name })
+
+		fieldIndex := -1
+		if columnIndex != -1 {
+			fieldIndex = columns[columnIndex].field
+		}
+
+		fieldIndexes = append(fieldIndexes, fieldIndex)
+	}
+
+	scanned := reflect.MakeSlice(sliceValue.Type(), 0, len(result.Rows))
+	for rowIndex, row := range result.Rows {
+		element := reflect.New(elemType).Elem()
+
+		for columnIndex, value := range row {
+			if fieldIndexes[columnIndex] == -1 || value == nil {
+				continue
+			}
+
+			setErr := setStructField(element.Field(fieldIndexes[columnIndex]), value)
+			if setErr != nil {
+				return fmt.Errorf("failed to scan row %v, column %v: %w", rowIndex+1, result.Columns[columnIndex].Name, setErr)
+			}
+		}
+
+		if isPointer {
+			element = element.Addr()
+		}
+
+		scanned = reflect.Append(scanned, element)
+	}
+
+	sliceValue.Set(scanned)
+	return nil
+}
+
+// Sets a struct field from a column value, converting between number types and filling pointer fields
+func setStructField(field reflect.Value, value any) error {
+	if field.Kind() == reflect.Pointer {
+		field.Set(reflect.New(field.Type().Elem()))
+		field = field.Elem()
+	}
+
+	// Only values of the same column type are converted, so e.g. an int is never turned into a string
+	columnValue := reflect.ValueOf(value)
+	fieldType, _ := columnTypeOf(field.Type())
+	if valueType, _ := columnTypeOf(columnValue.Type()); valueType != fieldType || !columnValue.Type().ConvertibleTo(field.Type()) {
+		return fmt.Errorf("a %T value can't be stored in a field of type %v", value, field.Type())
+	}
+
+	field.Set(columnValue.Convert(field.Type()))
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testMember struct {
+	ID       int    `untold:"pk,auto"`
+	Email    string `untold:"unique"`
+	Nickname *string
+	Score    float32 `untold:"nullable"`
+	Joined   time.Time
+	Avatar   []byte `untold:"nullable"`
+	session  string
+	Cache    string `untold:"-"`
+}
+
+// test the columns built from the fields and tags of a struct
+func Test_structDefinition(t *testing.T) {
+	type testNoKey struct {
+		Name string
+	}
+
+	type testAutoString struct {
+		Code string `untold:"pk,auto"`
+	}
+
+	type testListField struct {
+		ID   int `untold:"pk"`
+		Tags []string
+	}
+
+	type testUnknownTag struct {
+		ID int `untold:"pk,primary"`
+	}
+
+	testTemplates := []TestTemplate{
+		{
+			TestName: "Test fields and tags",
+			Inputs:   map[string]any{"model": testMember{}},
+			ExpectedOutput: TableDefinition{
+				Columns: []ColumnConfig{
+					{ColumnName: "ID", ColumnType: "int", Nullable: false},
+					{ColumnName: "Email", ColumnType: "string", Nullable: false, Unique: true},
+					{ColumnName: "Nickname", ColumnType: "string", Nullable: true},
+					{ColumnName: "Score", ColumnType: "float64", Nullable: true},
+					{ColumnName: "Joined", ColumnType: "time", Nullable: false},
+					{ColumnName: "Avatar", ColumnType: "[]byte", Nullable: true},
+				},
+				PrimaryKey:    "ID",
+				AutoIncrement: true,
+			},
+		},
+		{
+			TestName:       "Test struct without a primary key",
+			IsError:        true,
+			Inputs:         map[string]any{"model": testNoKey{}},
+			ExpectedOutput: "testNoKey needs a field tagged as the primary key, e.g. `untold:\"pk\"`",
+		},
+		{
+			TestName:       "Test auto on a string primary key",
+			IsError:        true,
+			Inputs:         map[string]any{"model": testAutoString{}},
+			ExpectedOutput: "field Code of testAutoString is tagged auto, which can only be used on an int primary key",
+		},
+		{
+			TestName:       "Test unsupported field type",
+			IsError:        true,
+			Inputs:         map[string]any{"model": testListField{}},
+			ExpectedOutput: "field Tags of testListField has the type []string, which can't be stored in a column",
+		},
+		{
+			TestName:       "Test unknown tag option",
+			IsError:        true,
+			Inputs:         map[string]any{"model": testUnknownTag{}},
+			ExpectedOutput: "field ID of testUnknownTag has an unknown untold tag option primary, expected one of pk, auto, nullable, unique",
+		},
+	}
+
+	for _, test := range testTemplates {
+		t.Run(test.TestName, func(t *testing.T) {
+			definition, err := structDefinition(reflect.TypeOf(test.Inputs["model"]))
+			if test.IsError {
+				if err == nil || err.Error() != test.ExpectedOutput.(string) {
+					t.Fatalf("error result was incorrect, got: %v, expected: %v", err, test.ExpectedOutput)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(definition, test.ExpectedOutput) {
+				t.Fatalf("result was incorrect, got: %+v, expected: %+v", definition, test.ExpectedOutput)
+			}
+		})
+	}
+}
+
+// test structs can be added as rows and read back from a PULL
+func Test_structRows(t *testing.T) {
+	useTestStore(t)
+
+	db := DB{}
+	if createErr := db.CreateTableFromStruct("Members", &testMember{}); createErr != nil {
+		t.Fatalf("unexpected error: %v", createErr)
+	}
+
+	nickname := "Countess"
+	joined := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	members := []*testMember{
+		{Email: "ada@example.com", Nickname: &nickname, Score: 9.5, Joined: joined, Avatar: []byte{1, 2}, Cache: "skipped"},
+		{Email: "alan@example.com", Joined: joined},
+	}
+
+	for _, member := range members {
+		if _, insertErr := db.InsertStruct("Members", member); insertErr != nil {
+			t.Fatalf("unexpected error: %v", insertErr)
+		}
+	}
+
+	t.Run("Test auto increment primary keys are set on the structs", func(t *testing.T) {
+		if members[0].ID != 1 || members[1].ID != 2 {
+			t.Fatalf("primary keys were incorrect, got: %v and %v", members[0].ID, members[1].ID)
+		}
+	})
+
+	t.Run("Test table constraints apply to inserted structs", func(t *testing.T) {
+		_, insertErr := db.InsertStruct("Members", testMember{Email: "ada@example.com"})
+		if insertErr == nil || insertErr.Error() != "a row already exists in table Members with the value ada@example.com for UNIQUE column Email" {
+			t.Fatalf("error result was incorrect, got: %v", insertErr)
+		}
+	})
+
+	t.Run("Test rows are scanned back into structs", func(t *testing.T) {
+		result, queryErr := db.runQuery("PULL * FROM Members SORT BY ID")
+		if queryErr != nil {
+			t.Fatalf("unexpected error: %v", queryErr)
+		}
+
+		scanned := []testMember{}
+		if scanErr := result.ScanStructs(&scanned); scanErr != nil {
+			t.Fatalf("unexpected error: %v", scanErr)
+		}
+
+		expected := []testMember{
+			{ID: 1, Email: "ada@example.com", Nickname: &nickname, Score: 9.5, Joined: joined, Avatar: []byte{1, 2}},
+			{ID: 2, Email: "alan@example.com", Joined: joined},
+		}
+
+		if !reflect.DeepEqual(scanned, expected) {
+			t.Fatalf("result was incorrect, got: %+v, expected: %+v", scanned, expected)
+		}
+	})
+
+	t.Run("Test qualified columns are scanned into struct pointers", func(t *testing.T) {
+		result, queryErr := db.runQuery("PULL m.Email FROM Members AS m WHERE m.ID = 2")
+		if queryErr != nil {
+			t.Fatalf("unexpected error: %v", queryErr)
+		}
+
+		scanned := []*testMember{}
+		if scanErr := result.ScanStructs(&scanned); scanErr != nil {
+			t.Fatalf("unexpected error: %v", scanErr)
+		}
+
+		if len(scanned) != 1 || scanned[0].Email != "alan@example.com" {
+			t.Fatalf("result was incorrect, got: %+v", scanned)
+		}
+	})
+
+	t.Run("Test a column of a different type is rejected", func(t *testing.T) {
+		result := ResultSet{Operation: "PULL", Columns: []ResultColumn{{Name: "Email", Type: "int"}}, Rows: [][]any{{1}}}
+
+		scanned := []testMember{}
+		scanErr := result.ScanStructs(&scanned)
+		if scanErr == nil || scanErr.Error() != "failed to scan row 1, column Email: a int value can't be stored in a field of type string" {
+			t.Fatalf("error result was incorrect, got: %v", scanErr)
+		}
+	})
+}
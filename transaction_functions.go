@@ -1,12 +1,305 @@
 package main
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// An entry in the audit log, Blame is the username of the user that took the action
+// ** Each entry holds the hash of the entry before it, so the log can't be changed without breaking the chain
 type TransactionLog struct {
-	EventTime string
-	Action    TransactionAction
-	Blame     string
+	Sequence     int
+	EventTime    string
+	Action       TransactionAction
+	Blame        string
+	Detail       string `json:",omitempty"` // the query that was run, or what a system change was made with
+	Error        string `json:",omitempty"` // set when the action failed
+	PreviousHash string
+	Hash         string
 }
 
 type TransactionAction struct {
 	ActionType  string
 	ActionScope string
 }
+
+// A set of row changes applied to one or more tables as a single unit
+// ** Changes are made in memory straight away, but only written to the table logs once the transaction is committed
+type Tx struct {
+	db        *DB
+	snapshots map[string]DBTable
+	records   []transactionRecord
+	done      bool
+}
+
+// A row change made within a transaction, along with the table it belongs to
+type transactionRecord struct {
+	Table  string
+	Record walRecord
+}
+
+// Starts a transaction, the queries run on the database until it is committed or rolled back are part of it
+func (db *DB) Begin() (*Tx, error) {
+	if db.tx != nil {
+		return nil, fmt.Errorf("a transaction is already in progress")
+	}
+
+	db.tx = &Tx{db: db, snapshots: map[string]DBTable{}, records: []transactionRecord{}}
+	return db.tx, nil
+}
+
+// Runs a query as part of the transaction
+func (tx *Tx) runQuery(queryStr string) (ResultSet, error) {
+	if tx.done {
+		return ResultSet{}, fmt.Errorf("the transaction has already been committed or rolled back")
+	}
+
+	return tx.db.runQuery(queryStr)
+}
+
+// Runs BEGIN, COMMIT or ROLLBACK from a query
+func (db *DB) runTransactionQuery(query DBQuery) (ResultSet, error) {
+	result := ResultSet{Operation: query.Operation}
+
+	if query.Operation == "BEGIN" {
+		_, beginErr := db.Begin()
+		return result, beginErr
+	}
+
+	if db.tx == nil {
+		return ResultSet{}, fmt.Errorf("%v was used without a transaction in progress", query.Operation)
+	}
+
+	result.RowsAffected = len(db.tx.records)
+	if query.Operation == "ROLLBACK" {
+		return result, db.tx.Rollback()
+	}
+
+	return result, db.tx.Commit()
+}
+
+// Keeps a copy of a table before the transaction first changes it, so the change can be rolled back
+func (tx *Tx) track(table *DBTable) {
+	if _, tracked := tx.snapshots[table.Name]; !tracked {
+		tx.snapshots[table.Name] = table.copyTable()
+	}
+
+	table.tx = tx
+}
+
+// Holds a change made to a table until the transaction is committed
+func (tx *Tx) record(tableName string, record walRecord) {
+	tx.records = append(tx.records, transactionRecord{Table: tableName, Record: record})
+}
+
+// Writes every change made within the transaction to file
+// ** The changes are first written together to the transaction journal, so a crash part way through adding them to each table log
+// can be recovered from the journal the next time a table is loaded
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return fmt.Errorf("the transaction has already been committed or rolled back")
+	}
+
+	defer tx.finish()
+
+	// Tables that haven't been saved to file yet have no log, their changes are kept when the database is closed
+	loggedRecords := []transactionRecord{}
+	for _, record := range tx.records {
+		tableIndex, tableErr := tx.db.getTable(record.Table)
+		if tableErr == nil && tx.db.Tables[tableIndex].wal != nil {
+			loggedRecords = append(loggedRecords, record)
+		}
+	}
+
+	if len(loggedRecords) == 0 {
+		return nil
+	}
+
+	content, marshalErr := json.Marshal(loggedRecords)
+	if marshalErr != nil {
+		tx.restoreSnapshots()
+		return fmt.Errorf("failed to convert the transaction for saving: %w", marshalErr)
+	}
+
+	journalErr := writeEncryptedFile(storePath(tx.db.databaseName(), "transaction", "journal"), content)
+	if journalErr != nil {
+		tx.restoreSnapshots()
+		return fmt.Errorf("failed to commit the transaction: %w", journalErr)
+	}
+
+	// The transaction is committed once the journal is written, from here the table logs only need to catch up with it
+	logErrs := []error{}
+	for _, record := range loggedRecords {
+		tableIndex, tableErr := tx.db.getTable(record.Table)
+		if tableErr != nil {
+			logErrs = append(logErrs, tableErr)
+			continue
+		}
+
+		table := &tx.db.Tables[tableIndex]
+		if table.wal == nil {
+			continue
+		}
+
+		appendErr := table.wal.append(record.Record)
+		if appendErr != nil {
+			// Later changes can't be added after the missing one, so the table is only saved as a whole from now on
+			logErrs = append(logErrs, fmt.Errorf("failed to write the change to the log for table %v: %w", table.Name, appendErr))
+			table.closeLog()
+		}
+	}
+
+	if len(logErrs) > 0 {
+		return fmt.Errorf("the transaction was committed, but the table logs could not be updated: %w", errors.Join(logErrs...))
+	}
+
+	removeErr := removeFileDurably(storePath(tx.db.databaseName(), "transaction", "journal"))
+	if removeErr != nil {
+		return fmt.Errorf("the transaction was committed, but the journal could not be removed: %w", removeErr)
+	}
+
+	// Checkpoint any tables whose logs have grown past the limit while the transaction was open
+	for tableName := range tx.snapshots {
+		tableIndex, tableErr := tx.db.getTable(tableName)
+		if tableErr != nil {
+			continue
+		}
+
+		table := &tx.db.Tables[tableIndex]
+		if table.wal != nil && table.wal.records >= walCheckpointRecords {
+			checkpointErr := table.checkpoint()
+			if checkpointErr != nil {
+				return checkpointErr
+			}
+		}
+	}
+
+	return nil
+}
+
+// Undoes every change made within the transaction, nothing has been written to file so only the tables in memory are restored
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return fmt.Errorf("the transaction has already been committed or rolled back")
+	}
+
+	tx.restoreSnapshots()
+	tx.finish()
+
+	return nil
+}
+
+// Puts every table changed by the transaction back to how it was before the transaction began
+func (tx *Tx) restoreSnapshots() {
+	for tableName, snapshot := range tx.snapshots {
+		tableIndex, tableErr := tx.db.getTable(tableName)
+		if tableErr != nil {
+			continue
+		}
+
+		snapshot.wal = tx.db.Tables[tableIndex].wal
+		tx.db.Tables[tableIndex] = snapshot
+	}
+}
+
+// Ends the transaction, leaving the tables to write their changes straight to their logs again
+func (tx *Tx) finish() {
+	for index := range tx.db.Tables {
+		if tx.db.Tables[index].tx == tx {
+			tx.db.Tables[index].tx = nil
+		}
+	}
+
+	tx.done = true
+	tx.db.tx = nil
+}
+
+// Replays a transaction that was committed but not fully written to the table logs before the process stopped
+// ** Changes already in a table snapshot or log are skipped, so a journal can be recovered more than once without repeating them
+func (db *DB) recoverTransaction() error {
+	journalPath := storePath(db.databaseName(), "transaction", "journal")
+
+	content, readErr := os.ReadFile(journalPath)
+	if errors.Is(readErr, fs.ErrNotExist) {
+		return nil
+	} else if readErr != nil {
+		return readErr
+	}
+
+	decryptedContent, decryptErr := openData(content)
+	if decryptErr != nil {
+		return fmt.Errorf("failed to read the transaction journal: %w", decryptErr)
+	}
+
+	records := []transactionRecord{}
+	unmarshalErr := json.Unmarshal(decryptedContent, &records)
+	if unmarshalErr != nil {
+		return fmt.Errorf("failed to read the transaction journal: %w", unmarshalErr)
+	}
+
+	for _, record := range records {
+		if _, existingErr := db.getTable(record.Table); existingErr != nil {
+			readErr := db.readTable(record.Table)
+			if readErr != nil {
+				return fmt.Errorf("failed to recover table %v from the transaction journal: %w", record.Table, readErr)
+			}
+		}
+
+		tableIndex, tableErr := db.getTable(record.Table)
+		if tableErr != nil {
+			return tableErr
+		}
+
+		table := &db.Tables[tableIndex]
+		if record.Record.Sequence <= table.LastSequence {
+			continue
+		}
+
+		if record.Record.Sequence != table.LastSequence+1 {
+			return fmt.Errorf("the transaction journal for table %v is missing changes %v to %v", table.Name, table.LastSequence+1, record.Record.Sequence-1)
+		}
+
+		normaliseErr := table.normaliseRecord(&record.Record)
+		if normaliseErr != nil {
+			return normaliseErr
+		}
+
+		if table.wal != nil {
+			appendErr := table.wal.append(record.Record)
+			if appendErr != nil {
+				return fmt.Errorf("failed to recover table %v from the transaction journal: %w", table.Name, appendErr)
+			}
+		}
+
+		applyErr := table.applyChange(record.Record)
+		if applyErr != nil {
+			return applyErr
+		}
+	}
+
+	return removeFileDurably(journalPath)
+}
+
+// Makes a copy of the table that shares none of its rows, so either can be changed without affecting the other
+func (table *DBTable) copyTable() DBTable {
+	copied := *table
+	copied.ColumnConfig = append([]ColumnConfig{}, table.ColumnConfig...)
+	copied.RowValues = []RowValue{}
+	copied.primaryIndex = nil
+	copied.uniqueIndexes = nil
+	copied.indexes = table.copyIndexes()
+
+	for _, row := range table.RowValues {
+		copiedRow := RowValue{ColumnValues: map[string]any{}}
+		for name, value := range row.ColumnValues {
+			copiedRow.ColumnValues[name] = value
+		}
+
+		copied.RowValues = append(copied.RowValues, copiedRow)
+	}
+
+	return copied
+}
@@ -1,9 +1,10 @@
 package main
 
 import (
-	"strings"
+	"errors"
 	"fmt"
 	"encoding/base64"
+	"io/fs"
 )
 
 type PreparedUserAuth struct {
@@ -19,7 +20,7 @@ type UserAuth struct {
 type User struct {
 	Username string
 	Password string
-	PrivateToken string
+	PrivateToken []byte
 }
 
 type UserLogin struct {
@@ -70,7 +71,7 @@ func createUser(newUser UserLogin) (error) {
 	//If one doesn't exist, make one
 	loadTableErr := db.loadTable("Users")
 	if loadTableErr != nil {
-		if strings.Contains(loadTableErr.Error(), "cannot find the file") { 
+		if errors.Is(loadTableErr, fs.ErrNotExist) {
 			columns := []map[string]any{
 				{
 					"ColumnName": "User_ID",
@@ -112,13 +113,11 @@ func createUser(newUser UserLogin) (error) {
 		return privateKeyErr
 	}
 
-	privKeyStr := base64.StdEncoding.EncodeToString(userPrivateKey)
-
 	// Generate the user object
 	userObj := User{
 		Username: newUser.Username,
 		Password: newUser.Password,
-		PrivateToken: privKeyStr,
+		PrivateToken: userPrivateKey,
 	}
 
 	// Add the user object to the users system table
@@ -127,9 +126,8 @@ func createUser(newUser UserLogin) (error) {
 		return addTableRowErr
 	}
 
-	defer db.Close()
-
-	return nil
+	// Save the new user, returning any failure to write the table
+	return db.Close()
 }
 
 // Lets the user login, will return a UserAuth object if successful
@@ -151,10 +149,10 @@ func userLogin(login UserLogin) (UserAuth, error) {
 	// Checks for matching credentials, returns a user auth object if successful
 	for _, value := range db.Tables[tableIndex].RowValues {
 		if value.ColumnValues["Username"] == login.Username && value.ColumnValues["Password"] == login.Password {
-			// Decodes from Base64 and Generates a public key for the user - this will be used to validate access later
-			data, err := base64.StdEncoding.DecodeString(value.ColumnValues["PrivateToken"].(string))
-			if err != nil {
-				return UserAuth{}, err
+			// Generates a public key for the user - this will be used to validate access later
+			data, isBytes := value.ColumnValues["PrivateToken"].([]byte)
+			if !isBytes {
+				return UserAuth{}, fmt.Errorf("the private token stored for the user is invalid")
 			}
 
 			pubKey, pubKeyErr := generatePublicKey(data)
@@ -195,10 +193,9 @@ func confirmUserAuth(auth UserAuth) (bool, error) {
 	// Checks for matching credentials, returns a user auth object if successful
 	for _, value := range db.Tables[tableIndex].RowValues {
 		if value.ColumnValues["Username"] == auth.Username {
-			// Decodes from Base64 and Generates a public key for the user - this will be used to validate access later
-			data, err := base64.StdEncoding.DecodeString(value.ColumnValues["PrivateToken"].(string))
-			if err != nil {
-				return false, err
+			data, isBytes := value.ColumnValues["PrivateToken"].([]byte)
+			if !isBytes {
+				return false, fmt.Errorf("the private token stored for the user is invalid")
 			}
 
 			// Generates a new public key from the user's private key and sees if the two match up
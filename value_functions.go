@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A bare identifier used as a value within a WHERE clause, resolved to a column when the table has one with the name
+type ColumnReference string
+
+// Layouts accepted when a string is stored into a time column
+var timeLayouts = []string{time.RFC3339Nano, time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+// Converts the text of a literal token into a typed value
+func parseLiteral(token Token) (any, error) {
+	switch token.Type {
+	case tokenString:
+		return token.Text, nil
+
+	case tokenNumber:
+		if !strings.Contains(token.Text, ".") {
+			intValue, intErr := strconv.Atoi(token.Text)
+			if intErr == nil {
+				return intValue, nil
+			}
+		}
+
+		floatValue, floatErr := strconv.ParseFloat(token.Text, 64)
+		if floatErr != nil {
+			return nil, &QueryError{Pos: token.Pos, Message: fmt.Sprintf("invalid number: %v", token.Text)}
+		}
+
+		return floatValue, nil
+
+	case tokenIdentifier:
+		switch strings.ToLower(token.Text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+
+		return token.Text, nil
+	}
+
+	return nil, &QueryError{Pos: token.Pos, Message: fmt.Sprintf("%v is not a literal value", token.Type)}
+}
+
+// Column types that can be used in CREATE TABLE and ALTER TABLE, bytes is stored as a []byte column
+var columnTypeNames = []string{"int", "float64", "string", "bool", "bytes", "time"}
+
+// Converts a column type written in a query into the name stored in the column config
+func parseColumnType(typeName string) (string, bool) {
+	typeName = strings.ToLower(typeName)
+	if typeName == "bytes" {
+		return "[]byte", true
+	}
+
+	typeName = normaliseColumnType(typeName)
+	return typeName, Contains(columnTypeNames, typeName)
+}
+
+// Returns the name used for a column type, accepting the names reflect gives back in createTableFromMap
+func normaliseColumnType(columnType string) string {
+	switch columnType {
+	case "[]uint8":
+		return "[]byte"
+	case "time.Time":
+		return "time"
+	case "float", "float32":
+		return "float64"
+	case "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64":
+		return "int"
+	}
+
+	return columnType
+}
+
+// Validates a value against a column type, converting it where it can be done without losing information
+// ** Unknown column types are passed through untouched
+func coerceValue(value any, columnType string) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch normaliseColumnType(columnType) {
+	case "int":
+		switch v := value.(type) {
+		case int:
+			return v, nil
+		case int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+			return int(reflect.ValueOf(v).Convert(reflect.TypeOf(0)).Int()), nil
+		case float64:
+			if v == math.Trunc(v) {
+				return int(v), nil
+			}
+		}
+
+	case "float64":
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case float32:
+			return float64(v), nil
+		case int:
+			return float64(v), nil
+		}
+
+	case "string":
+		if v, ok := value.(string); ok {
+			return v, nil
+		}
+
+	case "bool":
+		if v, ok := value.(bool); ok {
+			return v, nil
+		}
+
+	case "[]byte":
+		switch v := value.(type) {
+		case []byte:
+			return v, nil
+		case string:
+			return []byte(v), nil
+		}
+
+	case "time":
+		switch v := value.(type) {
+		case time.Time:
+			return v, nil
+		case string:
+			for _, layout := range timeLayouts {
+				parsed, parseErr := time.Parse(layout, v)
+				if parseErr == nil {
+					return parsed, nil
+				}
+			}
+		}
+
+	default:
+		return value, nil
+	}
+
+	return nil, fmt.Errorf("value %v of type %T does not match column type %v", value, value, columnType)
+}
+
+// Converts a value read back from the JSON store into the Go type of its column
+// ** JSON turns every number into a float64, []byte into base64 and time.Time into an RFC3339 string
+func normaliseStoredValue(value any, columnType string) (any, error) {
+	switch v := value.(type) {
+	case string:
+		switch normaliseColumnType(columnType) {
+		case "[]byte":
+			return base64.StdEncoding.DecodeString(v)
+		case "time":
+			return time.Parse(time.RFC3339Nano, v)
+		}
+	}
+
+	return coerceValue(value, columnType)
+}
+
+// Compares two values for equality, treating int and float64 as the same kind of number
+func valuesEqual(left any, right any) bool {
+	leftNumber, leftIsNumber := numericValue(left)
+	rightNumber, rightIsNumber := numericValue(right)
+	if leftIsNumber && rightIsNumber {
+		return leftNumber == rightNumber
+	}
+
+	// Byte slices can't be compared with ==, so compare them as strings instead
+	if l, ok := left.([]byte); ok {
+		left = string(l)
+	}
+	if r, ok := right.([]byte); ok {
+		right = string(r)
+	}
+
+	if l, ok := left.(time.Time); ok {
+		r, ok := right.(time.Time)
+		return ok && l.Equal(r)
+	}
+
+	if left == nil || right == nil {
+		return left == right
+	}
+
+	if !reflect.TypeOf(left).Comparable() || !reflect.TypeOf(right).Comparable() {
+		return false
+	}
+
+	return left == right
+}
+
+// Returns a value as a float64 if it holds a number
+func numericValue(value any) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+
+	return 0, false
+}
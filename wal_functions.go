@@ -0,0 +1,442 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+)
+
+// Number of records a write-ahead log can hold before it is checkpointed into the table snapshot
+const walCheckpointRecords = 500
+
+// Size of the length prefix written before every record in a write-ahead log
+const walLengthPrefixSize = 4
+
+// A single row change held in the write-ahead log of a table
+// ** Rows are found by their position in the table, so records have to be replayed in order against the snapshot they were written after
+type walRecord struct {
+	Sequence  int
+	Operation string         // one of PUSH, PUT or DELETE
+	Row       map[string]any `json:",omitempty"` // PUSH only, the values of the new row
+	NextID    int            `json:",omitempty"` // PUSH only, the next auto increment ID once the row is added
+	Rows      []int          `json:",omitempty"` // PUT and DELETE, the positions of the rows changed
+	Values    map[string]any `json:",omitempty"` // PUT only, the new values for each changed row
+}
+
+// The open write-ahead log of a table loaded from file
+type tableLog struct {
+	path     string
+	file     *os.File
+	records  int
+	key      dataKey      // the data key of the table, which every record is sealed with
+	identity fileIdentity // the table the log belongs to, with the generation of the .dat file its records follow
+	frames   int          // number of records in the file, including any already part of the .dat file
+	last     int          // sequence of the last record written since the log was opened or emptied, 0 if there isn't one
+}
+
+// Returns the path of a file belonging to a table within the store, e.g. the .dat snapshot or .wal log
+func storePath(databaseName string, tableName string, extension string) string {
+	return fmt.Sprintf("%v/%v.%v", databaseFolder(databaseName), tableName, extension)
+}
+
+// Writes a row change to the write-ahead log before applying it to the table
+// ** Tables that haven't been saved to file yet have no log, their changes are kept when the database is closed.
+// Changes made within a transaction are held by the transaction until it is committed
+func (table *DBTable) commitChange(record walRecord) error {
+	record.Sequence = table.LastSequence + 1
+
+	if table.tx != nil {
+		table.tx.record(table.Name, record)
+		return table.applyChange(record)
+	}
+
+	if table.wal != nil {
+		appendErr := table.wal.append(record)
+		if appendErr != nil {
+			return fmt.Errorf("failed to write the change to the log for table %v: %w", table.Name, appendErr)
+		}
+	}
+
+	applyErr := table.applyChange(record)
+	if applyErr != nil {
+		return applyErr
+	}
+
+	if table.wal != nil && table.wal.records >= walCheckpointRecords {
+		return table.checkpoint()
+	}
+
+	return nil
+}
+
+// Applies a row change from the write-ahead log to the rows held in memory
+func (table *DBTable) applyChange(record walRecord) error {
+	for _, rowIndex := range record.Rows {
+		if rowIndex < 0 || rowIndex >= len(table.RowValues) {
+			return fmt.Errorf("log record %v for table %v refers to row %v, which doesn't exist", record.Sequence, table.Name, rowIndex)
+		}
+	}
+
+	switch record.Operation {
+	case "PUSH":
+		table.RowValues = append(table.RowValues, RowValue{ColumnValues: maps.Clone(record.Row)})
+		table.NextID = record.NextID
+
+		if table.primaryIndex != nil {
+			table.primaryIndex.add(record.Row[table.PrimaryKeyColumnName], len(table.RowValues)-1)
+		}
+
+		for _, index := range table.indexes {
+			index.add(record.Row[index.Column], len(table.RowValues)-1)
+		}
+
+		for columnName, index := range table.uniqueIndexes {
+			index.add(record.Row[columnName], len(table.RowValues)-1)
+		}
+
+	case "PUT":
+		for _, rowIndex := range record.Rows {
+			row := table.RowValues[rowIndex].ColumnValues
+
+			if newPrimary, setsPrimary := record.Values[table.PrimaryKeyColumnName]; setsPrimary && table.primaryIndex != nil {
+				table.primaryIndex.remove(row[table.PrimaryKeyColumnName], rowIndex)
+				table.primaryIndex.add(newPrimary, rowIndex)
+			}
+
+			for _, index := range table.indexes {
+				if newValue, setsColumn := record.Values[index.Column]; setsColumn {
+					index.remove(row[index.Column], rowIndex)
+					index.add(newValue, rowIndex)
+				}
+			}
+
+			for columnName, index := range table.uniqueIndexes {
+				if newValue, setsColumn := record.Values[columnName]; setsColumn {
+					index.remove(row[columnName], rowIndex)
+					index.add(newValue, rowIndex)
+				}
+			}
+
+			for name, value := range record.Values {
+				row[name] = value
+			}
+		}
+
+	case "DELETE":
+		remainingRows := []RowValue{}
+		for rowIndex, row := range table.RowValues {
+			if !slices.Contains(record.Rows, rowIndex) {
+				remainingRows = append(remainingRows, row)
+			}
+		}
+
+		table.RowValues = remainingRows
+
+		// Removing rows moves every row after them, so the indexes are rebuilt the next time they are used
+		table.primaryIndex = nil
+		table.uniqueIndexes = nil
+		for _, index := range table.indexes {
+			index.clear()
+		}
+
+	default:
+		return fmt.Errorf("log record %v for table %v has an unknown operation: %v", record.Sequence, table.Name, record.Operation)
+	}
+
+	table.LastSequence = record.Sequence
+	return nil
+}
+
+// Saves the whole table to its snapshot and empties its write-ahead log
+// ** The snapshot holds the sequence of the last change it includes, so a crash before the log is emptied only leaves records that get skipped
+func (table *DBTable) checkpoint() error {
+	content, err := json.Marshal(table)
+	if err != nil {
+		return fmt.Errorf("failed to convert table %v for saving: %w", table.Name, err)
+	}
+
+	key, keyErr := table.sealingKey()
+	if keyErr != nil {
+		return fmt.Errorf("failed to make a data key for table %v: %w", table.Name, keyErr)
+	}
+
+	identity := tableIdentity(table.database, table.Name)
+	identity.Generation = table.generation + 1
+	fileWriteErr := writeEnvelopeFile(storePath(table.database, table.Name, "dat"), key, identity, content)
+	if fileWriteErr != nil {
+		return fmt.Errorf("failed to save table %v: %w", table.Name, fileWriteErr)
+	}
+
+	table.generation = identity.Generation
+	generationErr := recordGenerations(identity)
+	if generationErr != nil {
+		return fmt.Errorf("failed to save table %v: %w", table.Name, generationErr)
+	}
+
+	indexErr := table.saveIndexes()
+	if indexErr != nil {
+		return fmt.Errorf("failed to save the indexes for table %v: %w", table.Name, indexErr)
+	}
+
+	// ** The catalog is only written when the schema has changed, a failure here is put right by the next checkpoint
+	catalogErr := table.recordInCatalog()
+	if catalogErr != nil {
+		return fmt.Errorf("failed to add table %v to the catalog: %w", table.Name, catalogErr)
+	}
+
+	if table.wal == nil {
+		return nil
+	}
+
+	// Records written from here on follow the .dat file that was just saved
+	table.wal.identity.Generation = table.generation
+	return table.wal.truncate()
+}
+
+// Replays the write-ahead log of a table over its snapshot, then opens the log so new changes can be added to it
+func (table *DBTable) openLog() error {
+	path := storePath(table.database, table.Name, "wal")
+
+	content, readErr := os.ReadFile(path)
+	if readErr != nil && !errors.Is(readErr, fs.ErrNotExist) {
+		return readErr
+	}
+
+	identity := tableIdentity(table.database, table.Name)
+	identity.Generation = table.generation
+
+	// Records bound to the generation before were written before the last checkpoint, which stopped before emptying the log
+	staleRecords := map[int]bool{}
+	records, validLength, parseErr := parseLogRecords(content, func(position int, frame []byte) ([]byte, error) {
+		decryptedFrame, isStale, openErr := table.dataKey.openForFile(frame, identity.logRecord(position))
+		staleRecords[position] = isStale
+		return decryptedFrame, openErr
+	})
+	if parseErr != nil {
+		return fmt.Errorf("failed to read the log for table %v: %w", table.Name, parseErr)
+	}
+
+	pendingRecords := 0
+	for recordIndex, record := range records {
+		// Records from before the last checkpoint are already part of the snapshot
+		if record.Sequence <= table.LastSequence {
+			continue
+		}
+
+		if staleRecords[recordIndex+1] {
+			return fmt.Errorf("change %v in the log for table %v was written before the table was last saved", record.Sequence, table.Name)
+		}
+
+		if record.Sequence != table.LastSequence+1 {
+			return fmt.Errorf("the log for table %v is missing changes %v to %v", table.Name, table.LastSequence+1, record.Sequence-1)
+		}
+
+		normaliseErr := table.normaliseRecord(&record)
+		if normaliseErr != nil {
+			return normaliseErr
+		}
+
+		applyErr := table.applyChange(record)
+		if applyErr != nil {
+			return applyErr
+		}
+
+		pendingRecords = pendingRecords + 1
+	}
+
+	sequenceErr := confirmLogSequence(identity, table.LastSequence)
+	if sequenceErr != nil {
+		return sequenceErr
+	}
+
+	file, openErr := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0755)
+	if openErr != nil {
+		return openErr
+	}
+
+	// A record cut short by a crash was never applied, so it is dropped from the end of the log
+	if validLength < len(content) {
+		truncateErr := file.Truncate(int64(validLength))
+		if truncateErr != nil {
+			file.Close()
+			return fmt.Errorf("failed to remove an incomplete record from the log for table %v: %w", table.Name, truncateErr)
+		}
+	}
+
+	table.wal = &tableLog{path: path, file: file, records: pendingRecords, key: table.dataKey, identity: identity, frames: len(records)}
+	return nil
+}
+
+// Converts the values of a replayed record back into the Go types of their columns
+func (table *DBTable) normaliseRecord(record *walRecord) error {
+	for _, values := range []map[string]any{record.Row, record.Values} {
+		for name, value := range values {
+			config, columnErr := table.getColumnConfig(name)
+			if columnErr != nil {
+				return fmt.Errorf("log record %v for table %v is invalid: %w", record.Sequence, table.Name, columnErr)
+			}
+
+			if value == nil {
+				continue
+			}
+
+			typedValue, typeErr := normaliseStoredValue(value, config.ColumnType)
+			if typeErr != nil {
+				return fmt.Errorf("log record %v for table %v is invalid: %w", record.Sequence, table.Name, typeErr)
+			}
+
+			values[name] = typedValue
+		}
+	}
+
+	return nil
+}
+
+// Splits a write-ahead log into its records, returning the length of the log up to the last complete record
+func parseLogRecords(content []byte, open openFrame) ([]walRecord, int, error) {
+	frames, validLength, frameErr := readLogFrames(content, open)
+	if frameErr != nil {
+		return nil, 0, frameErr
+	}
+
+	records := []walRecord{}
+	for frameIndex, frame := range frames {
+		record := walRecord{}
+		unmarshalErr := json.Unmarshal(frame, &record)
+		if unmarshalErr != nil {
+			return nil, 0, fmt.Errorf("record %v is invalid: %w", frameIndex+1, unmarshalErr)
+		}
+
+		records = append(records, record)
+	}
+
+	return records, validLength, nil
+}
+
+// Splits the content of a log file into its encrypted frames, returning the length of the content up to the last complete frame
+// ** Each frame is a 4 byte length followed by the encrypted content, so a frame cut short by a crash can only be at the end
+func splitLogFrames(content []byte) ([][]byte, int) {
+	frames := [][]byte{}
+	offset := 0
+
+	for offset < len(content) {
+		if len(content)-offset < walLengthPrefixSize {
+			break
+		}
+
+		frameLength := int(binary.BigEndian.Uint32(content[offset : offset+walLengthPrefixSize]))
+		frameEnd := offset + walLengthPrefixSize + frameLength
+		if frameEnd > len(content) {
+			break
+		}
+
+		frames = append(frames, content[offset+walLengthPrefixSize:frameEnd])
+		offset = frameEnd
+	}
+
+	return frames, offset
+}
+
+// Decrypts a single frame of a log, given its position within the log starting from 1
+type openFrame func(position int, frame []byte) ([]byte, error)
+
+// Splits the content of a log file into its decrypted frames, returning the length of the content up to the last complete frame
+func readLogFrames(content []byte, open openFrame) ([][]byte, int, error) {
+	encryptedFrames, validLength := splitLogFrames(content)
+
+	frames := [][]byte{}
+	for frameIndex, encryptedFrame := range encryptedFrames {
+		decryptedFrame, decryptErr := open(frameIndex+1, encryptedFrame)
+		if decryptErr != nil {
+			return nil, 0, fmt.Errorf("record %v could not be decrypted: %w", frameIndex+1, decryptErr)
+		}
+
+		frames = append(frames, decryptedFrame)
+	}
+
+	return frames, validLength, nil
+}
+
+// Returns encrypted content with the length prefix of a log frame in front of it
+func logFrame(encryptedContent []byte) []byte {
+	frame := binary.BigEndian.AppendUint32(make([]byte, 0, walLengthPrefixSize+len(encryptedContent)), uint32(len(encryptedContent)))
+	return append(frame, encryptedContent...)
+}
+
+// Encrypts content and adds it to the end of a log file as a single frame, syncing it to disk before returning
+func appendLogFrame(file *os.File, content []byte, seal func(content []byte) ([]byte, error)) error {
+	encryptedContent, encryptErr := seal(content)
+	if encryptErr != nil {
+		return encryptErr
+	}
+
+	// The length prefix and content are written together, so a crash can only ever cut short the last frame
+	frame := logFrame(encryptedContent)
+
+	if _, writeErr := file.Write(frame); writeErr != nil {
+		return writeErr
+	}
+
+	return file.Sync()
+}
+
+// Encrypts a record and adds it to the end of the log, syncing it to disk before returning
+func (wal *tableLog) append(record walRecord) error {
+	content, marshalErr := json.Marshal(record)
+	if marshalErr != nil {
+		return marshalErr
+	}
+
+	recordIdentity := wal.identity.logRecord(wal.frames + 1)
+	appendErr := appendLogFrame(wal.file, content, func(content []byte) ([]byte, error) {
+		return wal.key.sealForFile(content, recordIdentity)
+	})
+	if appendErr != nil {
+		return appendErr
+	}
+
+	wal.records = wal.records + 1
+	wal.frames = wal.frames + 1
+	wal.last = record.Sequence
+	return nil
+}
+
+// Empties the log once its records are part of the table snapshot
+func (wal *tableLog) truncate() error {
+	if truncateErr := wal.file.Truncate(0); truncateErr != nil {
+		return fmt.Errorf("failed to empty the log %v: %w", wal.path, truncateErr)
+	}
+
+	if syncErr := wal.file.Sync(); syncErr != nil {
+		return fmt.Errorf("failed to sync the log %v: %w", wal.path, syncErr)
+	}
+
+	wal.records = 0
+	wal.frames = 0
+	wal.last = 0
+	return nil
+}
+
+// Closes the log file, the table stops logging its changes until it is loaded again
+// ** The last change written to the log is saved on close rather than on every change, so writing a change only costs the
+// record itself. A log cut short while it is open, or before a crash, can't be detected
+func (table *DBTable) closeLog() error {
+	if table.wal == nil {
+		return nil
+	}
+
+	closeErr := table.wal.file.Close()
+
+	sequenceErr := error(nil)
+	if table.wal.last > 0 {
+		sequenceErr = recordLogSequence(table.wal.identity, table.wal.last)
+	}
+	table.wal = nil
+
+	return errors.Join(closeErr, sequenceErr)
+}